@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// accountList is the comma-separated list of account names given by -accounts
+var accountList string
+
+// account is a Red Hat account subscriptions are fetched for
+type account struct {
+	Name      string
+	Token     string
+	TokenFile string
+	// ClientID and ClientSecret of a service account replace the offline
+	// token when set
+	ClientID     string
+	ClientSecret string
+	client       *http.Client
+	// query selects a discovered account in the API requests
+	query url.Values
+}
+
+// accountEnvSuffix turns an account name into the suffix of its env vars, e.g.
+// "acme-prod" reads RH_OFFLINE_TOKEN_ACME_PROD
+func accountEnvSuffix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// configuredAccounts returns the accounts of -accounts with their
+// credentials, or a single unnamed account using RH_OFFLINE_TOKEN or
+// RH_CLIENT_ID and RH_CLIENT_SECRET
+func configuredAccounts() ([]account, error) {
+	names := splitList(accountList)
+	if len(names) == 0 {
+		a := accountFromEnv("", "")
+		if vaultSource != nil && a.ClientSecret == "" {
+			a.Token = vaultSource.OfflineToken()
+		}
+		return []account{a}, nil
+	}
+
+	var accounts []account
+	for _, name := range names {
+		suffix := "_" + accountEnvSuffix(name)
+		a := accountFromEnv(name, suffix)
+		if a.Token == "" && a.ClientSecret == "" {
+			return nil, fmt.Errorf("please set RH_OFFLINE_TOKEN%s or RH_CLIENT_ID%s and RH_CLIENT_SECRET%s for account %s", suffix, suffix, suffix, name)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+// accountFromEnv reads the credentials of an account from the env vars with
+// the given suffix, supporting the *_FILE convention for secrets
+func accountFromEnv(name, suffix string) account {
+	a := account{
+		Name:         name,
+		Token:        getSecretEnv("RH_OFFLINE_TOKEN" + suffix),
+		TokenFile:    os.Getenv("RH_OFFLINE_TOKEN" + suffix + "_FILE"),
+		ClientID:     os.Getenv("RH_CLIENT_ID" + suffix),
+		ClientSecret: getSecretEnv("RH_CLIENT_SECRET" + suffix),
+	}
+	if a.ClientID == "" {
+		a.ClientSecret = ""
+	}
+	return a
+}
+
+// fetchAccounts fetches the subscriptions of all accounts. With multiple
+// accounts a failing account keeps its subscriptions as fetched for the last
+// update, so one broken token doesn't blank the others. Only if every account
+// fails the cycle fails. With -accounts.discovery-url the accounts accessible with each
+// configured token are fetched instead.
+func fetchAccounts(ctx context.Context, accounts []account, apiUrl string) ([]rhsm.Subscription, error) {
+	if discoveryURL != "" {
+		var discovered []account
+		for _, a := range accounts {
+			found, err := discoverAccounts(ctx, a)
+			if err != nil {
+				return nil, fmt.Errorf("account discovery failed: %w", err)
+			}
+			discovered = append(discovered, found...)
+		}
+		if len(discovered) == 0 {
+			return nil, errors.New("account discovery found no accounts")
+		}
+		accounts = discovered
+	}
+
+	if len(accounts) == 1 && accounts[0].Name == "" {
+		return apiClient(accounts[0].client, apiUrl, "").FetchAll(ctx)
+	}
+
+	var all []rhsm.Subscription
+	var errs []error
+	for _, a := range accounts {
+		subs, err := apiClient(a.client, accountURL(apiUrl, a), a.Name).FetchAll(ctx)
+		if err != nil {
+			slog.Error("Error fetching subscriptions of account", "account", a.Name, "err", err)
+			AccountFetchErrorsCounter.WithLabelValues(a.Name).Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", a.Name, err))
+
+			updateMu.Lock()
+			for _, s := range lastFetched {
+				if s.Account == a.Name {
+					all = append(all, s)
+				}
+			}
+			updateMu.Unlock()
+			continue
+		}
+		for i := range subs {
+			subs[i].Account = a.Name
+		}
+		all = append(all, subs...)
+	}
+	if len(errs) == len(accounts) {
+		return nil, errors.Join(errs...)
+	}
+	return all, nil
+}
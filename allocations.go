@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var collectAllocations bool
+
+var allocationsRegistry = prometheus.NewRegistry()
+
+var (
+	AllocationEntitlementsGauge = promauto.With(allocationsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_allocation_entitlements",
+		Help: "Number of entitlements attached to the subscription allocation (manifest).",
+	},
+		[]string{"uuid", "name", "type", "version"})
+	AllocationLastModifiedGauge = promauto.With(allocationsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_allocation_last_modified_timestamp_seconds",
+		Help: "When the subscription allocation (manifest) was last modified.",
+	},
+		[]string{"uuid", "name"})
+)
+
+func init() {
+	optionalCollectors = append(optionalCollectors, &optionalCollector{
+		name:     "allocations",
+		path:     "allocations",
+		enabled:  &collectAllocations,
+		registry: allocationsRegistry,
+		collect:  collectAllocationMetrics,
+	})
+}
+
+// collectAllocationMetrics fetches the subscription allocations and exports
+// their entitlement counts and modification times
+func collectAllocationMetrics(ctx context.Context, client *rhsm.Client) error {
+	allocations, err := client.FetchAllocations(ctx)
+	if err != nil {
+		return err
+	}
+
+	AllocationEntitlementsGauge.Reset()
+	AllocationLastModifiedGauge.Reset()
+	for _, a := range allocations {
+		AllocationEntitlementsGauge.WithLabelValues(a.UUID, a.Name, a.Type, a.Version).Set(float64(a.EntitlementQuantity))
+		if !a.LastModified.IsZero() {
+			AllocationLastModifiedGauge.WithLabelValues(a.UUID, a.Name).Set(float64(a.LastModified.Unix()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// querySubscriptions returns the subscriptions of the last fetch matching
+// the ?sku= and ?status= filters, sorted by subscription number. The filters
+// may be repeated to match any of their values, status is compared
+// case-insensitively.
+func querySubscriptions(query url.Values) []rhsm.Subscription {
+	skus := query["sku"]
+	statuses := query["status"]
+
+	updateMu.Lock()
+	subs := []rhsm.Subscription{}
+	for _, s := range lastSubscriptions {
+		if len(skus) > 0 && !slices.Contains(skus, s.SKU) {
+			continue
+		}
+		if len(statuses) > 0 && !slices.ContainsFunc(statuses, func(status string) bool { return strings.EqualFold(status, s.Status) }) {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	updateMu.Unlock()
+
+	slices.SortStableFunc(subs, func(a, b rhsm.Subscription) int {
+		return cmp.Compare(a.SubscriptionNumber, b.SubscriptionNumber)
+	})
+	return subs
+}
+
+// subscriptionsHandler serves /api/v1/subscriptions, the subscriptions of
+// the last fetch as json, see querySubscriptions for the filters
+func subscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subs := querySubscriptions(r.URL.Query())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":         len(subs),
+		"subscriptions": subs,
+	})
+}
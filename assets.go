@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// assetsFS contains the default Grafana dashboard and alerting rules matching
+// the metrics of this build
+//
+//go:embed assets/dashboard.json assets/rules.yaml
+var assetsFS embed.FS
+
+// assetsHandler serves the embedded assets below /assets/
+func assetsHandler() http.Handler {
+	sub, err := fs.Sub(assetsFS, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/assets/", http.FileServer(http.FS(sub)))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var auditFile string
+
+var AuditErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+	Name: "redhat_subscription_audit_errors_total",
+	Help: "Total number of failed writes to -audit.file.",
+})
+
+// auditRecord is a line of -audit.file
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	subscriptionChange
+}
+
+// writeAudit appends a json line per change to -audit.file, "-" writes to
+// stdout. The file is opened for every write, so it can be rotated.
+func writeAudit(changes []subscriptionChange, t time.Time) {
+	if auditFile == "" || len(changes) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range changes {
+		enc.Encode(auditRecord{Time: t.UTC(), subscriptionChange: c})
+	}
+
+	if auditFile == stdoutExport {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	f, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		_, err = f.Write(buf.Bytes())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("Error writing audit file", "file", auditFile, "err", err)
+		AuditErrorsCounter.Inc()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheTTL time.Duration
+	// lastFetchStart is the unix nano timestamp of the start of the last
+	// fetch, the age of the served metrics
+	lastFetchStart atomic.Int64
+	// refreshRequests wakes the fetch loop before the next scheduled fetch
+	refreshRequests = make(chan struct{}, 1)
+
+	ScrapeRefreshesCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_scrape_refreshes_total",
+		Help: "Total number of fetches triggered by a scrape because the cached metrics were older than -cache.ttl.",
+	})
+)
+
+// refreshIfStale wakes the fetch loop when -cache.ttl is set and the last
+// fetch started longer than the TTL ago. The scrape isn't delayed, it gets
+// the cached metrics. Failed fetches are retried at most once per TTL too,
+// and not before -circuit.interval while the circuit is open.
+func refreshIfStale() {
+	start := lastFetchStart.Load()
+	if cacheTTL <= 0 || start == 0 || time.Since(time.Unix(0, start)) < cacheTTL || circuit.isOpen() {
+		return
+	}
+	if requestRefresh() {
+		slog.Debug("Cached metrics expired, refreshing", "age", time.Since(time.Unix(0, start)), "ttl", cacheTTL)
+		ScrapeRefreshesCounter.Inc()
+	}
+}
+
+// requestRefresh asks the fetch loop to fetch now and reports whether the
+// request was queued. It doesn't block if a refresh is already pending.
+func requestRefresh() bool {
+	select {
+	case refreshRequests <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleepUntilRefresh waits for d, until a refresh is requested or ctx is
+// cancelled. While the circuit is open refresh requests are dropped, the
+// fetch is retried after -circuit.interval.
+func sleepUntilRefresh(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			return nil
+		case <-refreshRequests:
+			if !circuit.isOpen() {
+				return nil
+			}
+			slog.Debug("Ignoring refresh request, the circuit is open")
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/candlepin"
+)
+
+var (
+	fetchSource       string
+	candlepinURL      string
+	candlepinOwner    string
+	candlepinUsername string
+	candlepinPassword string
+	entitlementDir    string
+)
+
+// candlepinClient returns the client of the -candlepin.* flags. Client
+// certificates are configured in the transport with -tls.cert-file.
+func candlepinClient(client *http.Client) *candlepin.Client {
+	return &candlepin.Client{
+		HTTPClient: client,
+		URL:        candlepinURL,
+		Owner:      candlepinOwner,
+		Username:   candlepinUsername,
+		Password:   candlepinPassword,
+		Retry:      retryPolicy(),
+		Now:        currentTime,
+	}
+}
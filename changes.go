@@ -0,0 +1,112 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Types of changes between two snapshots of the subscriptions
+const (
+	changeAdded           = "added"
+	changeRemoved         = "removed"
+	changeQuantityChanged = "quantity_changed"
+	changeStatusChanged   = "status_changed"
+)
+
+var ChangesCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "redhat_subscription_changes_total",
+	Help: "Total number of changes of the subscriptions between two fetches, by type: added, removed, quantity_changed or status_changed.",
+},
+	[]string{"type"})
+
+func init() {
+	// Exported from the start, so increase() sees the first change
+	for _, t := range []string{changeAdded, changeRemoved, changeQuantityChanged, changeStatusChanged} {
+		ChangesCounter.WithLabelValues(t)
+	}
+}
+
+// subscriptionChange is a difference of a subscription between two
+// snapshots. Old and New are the changed values, empty for added and removed
+// subscriptions.
+type subscriptionChange struct {
+	Type               string `json:"type"`
+	Account            string `json:"account,omitempty"`
+	SubscriptionNumber string `json:"subscriptionNumber"`
+	SKU                string `json:"sku"`
+	SubscriptionName   string `json:"subscriptionName"`
+	Old                string `json:"old,omitempty"`
+	New                string `json:"new,omitempty"`
+}
+
+// subscriptionKey identifies a subscription across snapshots
+type subscriptionKey struct {
+	account, number string
+}
+
+// diffSubscriptions returns the changes from the previous to the current
+// snapshot, sorted by subscription. Rows of the same subscription are merged
+// first, so their order doesn't show up as a change.
+func diffSubscriptions(previous, current []rhsm.Subscription) []subscriptionChange {
+	previous, _ = collector.MergeDuplicates(previous)
+	current, _ = collector.MergeDuplicates(current)
+
+	before := make(map[subscriptionKey]rhsm.Subscription, len(previous))
+	for _, s := range previous {
+		before[subscriptionKey{s.Account, s.SubscriptionNumber}] = s
+	}
+
+	var changes []subscriptionChange
+	change := func(t string, s rhsm.Subscription, oldValue, newValue string) {
+		changes = append(changes, subscriptionChange{
+			Type:               t,
+			Account:            s.Account,
+			SubscriptionNumber: s.SubscriptionNumber,
+			SKU:                s.SKU,
+			SubscriptionName:   s.SubscriptionName,
+			Old:                oldValue,
+			New:                newValue,
+		})
+	}
+	for _, s := range current {
+		key := subscriptionKey{s.Account, s.SubscriptionNumber}
+		prev, ok := before[key]
+		if !ok {
+			change(changeAdded, s, "", "")
+			continue
+		}
+		delete(before, key)
+		if prev.Quantity != s.Quantity {
+			change(changeQuantityChanged, s, prev.Quantity, s.Quantity)
+		}
+		if prev.Status != s.Status {
+			change(changeStatusChanged, s, prev.Status, s.Status)
+		}
+	}
+	for _, s := range before {
+		change(changeRemoved, s, "", "")
+	}
+
+	slices.SortStableFunc(changes, func(a, b subscriptionChange) int {
+		return cmp.Or(cmp.Compare(a.Account, b.Account), cmp.Compare(a.SubscriptionNumber, b.SubscriptionNumber), cmp.Compare(a.Type, b.Type))
+	})
+	return changes
+}
+
+// recordChanges counts the changes from the previous snapshot. Nothing is
+// counted for the first snapshot, which isn't a change of the account.
+func recordChanges(previous, subs []rhsm.Subscription, first bool) []subscriptionChange {
+	if first {
+		return nil
+	}
+	changes := diffSubscriptions(previous, subs)
+	for _, c := range changes {
+		ChangesCounter.WithLabelValues(c.Type).Inc()
+	}
+	return changes
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// Exit codes of the check subcommand, following the Nagios plugin convention
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+// checkStatusNames are the names of the exit codes in the summary
+var checkStatusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkSummaryProblems is the number of problems named in the summary
+const checkSummaryProblems = 3
+
+var (
+	checkWarningDays   int
+	checkCriticalDays  int
+	checkWarningUsage  float64
+	checkCriticalUsage float64
+)
+
+// fetchOnce makes the fetch loop return after the first fetch, with its error
+// instead of retrying
+var fetchOnce bool
+
+// checkProblem is a subscription exceeding a threshold
+type checkProblem struct {
+	status int
+	text   string
+}
+
+// runCheck fetches the subscriptions once, prints a one-line summary of the
+// subscriptions exceeding the -check.* thresholds and returns the exit code
+func runCheck(ctx context.Context) int {
+	fetchOnce = true
+	done := make(chan error, 1)
+	metricsLoop(ctx, done)
+	if err := <-done; err != nil {
+		fmt.Printf("UNKNOWN - failed to fetch subscriptions: %v\n", err)
+		return checkUnknown
+	}
+
+	updateMu.Lock()
+	subs := lastSubscriptions
+	updateMu.Unlock()
+
+	status, summary := evaluateCheck(subs)
+	fmt.Println(summary)
+	return status
+}
+
+// evaluateCheck checks the subscriptions that didn't end yet against the
+// thresholds and returns the exit code and summary line with perfdata
+func evaluateCheck(subs []rhsm.Subscription) (int, string) {
+	now := currentTime()
+	var problems []checkProblem
+	checked := 0
+	// Problems of the same status are listed by end date
+	subs = slices.Clone(subs)
+	slices.SortStableFunc(subs, func(a, b rhsm.Subscription) int { return a.EndDate.Compare(b.EndDate) })
+	for _, s := range subs {
+		if s.EndDate.IsZero() || !s.EndDate.After(now) {
+			continue
+		}
+		checked++
+		name := fmt.Sprintf("%s #%s", s.SKU, s.SubscriptionNumber)
+
+		days := int(math.Floor(s.EndDate.Sub(now).Hours() / 24))
+		switch {
+		case checkCriticalDays > 0 && days <= checkCriticalDays:
+			problems = append(problems, checkProblem{checkCritical, fmt.Sprintf("%s ends in %d days", name, days)})
+		case checkWarningDays > 0 && days <= checkWarningDays:
+			problems = append(problems, checkProblem{checkWarning, fmt.Sprintf("%s ends in %d days", name, days)})
+		}
+
+		usage, ok := poolUsage(s)
+		if !ok {
+			continue
+		}
+		switch {
+		case checkCriticalUsage > 0 && usage >= checkCriticalUsage:
+			problems = append(problems, checkProblem{checkCritical, fmt.Sprintf("%s %.0f%% used", name, usage)})
+		case checkWarningUsage > 0 && usage >= checkWarningUsage:
+			problems = append(problems, checkProblem{checkWarning, fmt.Sprintf("%s %.0f%% used", name, usage)})
+		}
+	}
+
+	// The worst problems are listed first
+	slices.SortStableFunc(problems, func(a, b checkProblem) int { return b.status - a.status })
+	status := checkOK
+	counts := map[int]int{}
+	for _, p := range problems {
+		status = max(status, p.status)
+		counts[p.status]++
+	}
+
+	summary := fmt.Sprintf("%s - %d subscriptions checked", checkStatusNames[status], checked)
+	if len(problems) > 0 {
+		var texts []string
+		for _, p := range problems[:min(len(problems), checkSummaryProblems)] {
+			texts = append(texts, p.text)
+		}
+		if more := len(problems) - len(texts); more > 0 {
+			texts = append(texts, fmt.Sprintf("%d more", more))
+		}
+		summary += ", " + strings.Join(texts, ", ")
+	}
+	summary += fmt.Sprintf(" | checked=%d critical=%d warning=%d", checked, counts[checkCritical], counts[checkWarning])
+	return status, summary
+}
+
+// poolUsage returns the percentage of the entitlements of the pools of s that
+// are consumed, false if s has no pools with a limited quantity
+func poolUsage(s rhsm.Subscription) (float64, bool) {
+	quantity, consumed := 0, 0
+	for _, p := range s.Pools {
+		if p.Quantity <= 0 {
+			continue
+		}
+		quantity += p.Quantity
+		consumed += p.Consumed
+	}
+	if quantity == 0 {
+		return 0, false
+	}
+	return float64(consumed) / float64(quantity) * 100, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	circuitFailures int
+	circuitInterval time.Duration
+
+	// circuit counts the consecutive failed fetch cycles
+	circuit circuitBreaker
+
+	CircuitOpenGauge = promauto.With(exporterRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "redhat_subscription_circuit_open",
+		Help: "Whether the circuit is open after -circuit.failures consecutive failed fetches (1), retrying only every -circuit.interval, or closed (0).",
+	})
+)
+
+// circuitBreaker opens after -circuit.failures consecutive failed fetch
+// cycles, so a broken token or API isn't hammered every interval and the log
+// isn't flooded with the same error
+type circuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	open     bool
+}
+
+// failure records a failed fetch cycle and reports whether the circuit is
+// open and whether it was open before already
+func (c *circuitBreaker) failure() (open, wasOpen bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	wasOpen = c.open
+	if circuitFailures > 0 && c.failures >= circuitFailures && !c.open {
+		c.open = true
+		CircuitOpenGauge.Set(1)
+		slog.Warn("Opening the circuit, retrying less often until a fetch succeeds", "failures", c.failures, "retry_interval", circuitInterval)
+	}
+	return c.open, wasOpen
+}
+
+// isOpen reports whether the circuit is open
+func (c *circuitBreaker) isOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// success closes the circuit after a successful fetch cycle
+func (c *circuitBreaker) success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open {
+		slog.Info("Closing the circuit, the fetch succeeded again", "failures", c.failures)
+		CircuitOpenGauge.Set(0)
+	}
+	c.failures = 0
+	c.open = false
+}
+
+// retryInterval returns the time until the next fetch after a failure, the
+// longer -circuit.interval while the circuit is open
+func retryInterval(interval time.Duration, open bool) time.Duration {
+	if open {
+		return max(interval, circuitInterval)
+	}
+	return interval
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	defer func(failures int, interval time.Duration) {
+		circuitFailures, circuitInterval = failures, interval
+		circuit.success()
+	}(circuitFailures, circuitInterval)
+	circuitInterval = time.Hour
+
+	type step struct {
+		// success closes the circuit, a failure is recorded otherwise
+		success     bool
+		wantOpen    bool
+		wantWasOpen bool
+	}
+	tests := []struct {
+		name     string
+		failures int
+		steps    []step
+	}{
+		{
+			name:     "opens after failures",
+			failures: 3,
+			steps:    []step{{}, {}, {wantOpen: true}, {wantOpen: true, wantWasOpen: true}},
+		},
+		{
+			name:     "success resets the count",
+			failures: 2,
+			steps:    []step{{}, {success: true}, {}, {wantOpen: true}, {success: true}, {}},
+		},
+		{
+			name:     "opens at once",
+			failures: 1,
+			steps:    []step{{wantOpen: true}, {wantOpen: true, wantWasOpen: true}, {success: true}, {wantOpen: true}},
+		},
+		{
+			name:     "disabled",
+			failures: 0,
+			steps:    []step{{}, {}, {}, {}, {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circuitFailures = tt.failures
+			var c circuitBreaker
+			for i, s := range tt.steps {
+				if s.success {
+					c.success()
+					if c.isOpen() {
+						t.Fatalf("step %d: circuit open after a success", i)
+					}
+					continue
+				}
+				open, wasOpen := c.failure()
+				if open != s.wantOpen || wasOpen != s.wantWasOpen {
+					t.Fatalf("step %d: failure() = %v, %v, want %v, %v", i, open, wasOpen, s.wantOpen, s.wantWasOpen)
+				}
+				if c.isOpen() != open {
+					t.Fatalf("step %d: isOpen() = %v, want %v", i, c.isOpen(), open)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	defer func(interval time.Duration) { circuitInterval = interval }(circuitInterval)
+	circuitInterval = 10 * time.Minute
+
+	tests := []struct {
+		interval time.Duration
+		open     bool
+		want     time.Duration
+	}{
+		{interval: time.Minute, want: time.Minute},
+		{interval: time.Minute, open: true, want: 10 * time.Minute},
+		{interval: time.Hour, open: true, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryInterval(tt.interval, tt.open); got != tt.want {
+			t.Errorf("retryInterval(%s, %v) = %s, want %s", tt.interval, tt.open, got, tt.want)
+		}
+	}
+}
+
+// TestSleepUntilRefreshCircuitOpen checks that refresh requests don't cut the
+// wait short while the circuit is open
+func TestSleepUntilRefreshCircuitOpen(t *testing.T) {
+	defer func(failures int) {
+		circuitFailures = failures
+		circuit.success()
+	}(circuitFailures)
+	circuitFailures = 1
+
+	const wait = 200 * time.Millisecond
+	tests := []struct {
+		name    string
+		open    bool
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{name: "closed", wantMax: wait / 2},
+		{name: "open", open: true, wantMin: wait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circuit.success()
+			if tt.open {
+				circuit.failure()
+			}
+			// Drain a request left over by another test
+			select {
+			case <-refreshRequests:
+			default:
+			}
+
+			start := time.Now()
+			requestRefresh()
+			if err := sleepUntilRefresh(context.Background(), wait); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.wantMin || (tt.wantMax > 0 && elapsed > tt.wantMax) {
+				t.Errorf("slept %s, want between %s and %s", elapsed, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
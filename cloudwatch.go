@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// cloudWatchBatchSize is the number of metrics sent per PutMetricData call,
+// well below the limit of the API
+const cloudWatchBatchSize = 500
+
+var (
+	cloudWatchNamespace string
+
+	CloudWatchErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_cloudwatch_errors_total",
+		Help: "Total number of failed publishes to CloudWatch.",
+	})
+)
+
+// cloudWatchDatum returns a metric with the dimensions, those with an empty
+// value are left out
+func cloudWatchDatum(name string, value float64, unit types.StandardUnit, t time.Time, dimensions ...string) types.MetricDatum {
+	datum := types.MetricDatum{
+		MetricName: aws.String(name),
+		Value:      aws.Float64(value),
+		Unit:       unit,
+		Timestamp:  aws.Time(t),
+	}
+	for i := 0; i+1 < len(dimensions); i += 2 {
+		if dimensions[i+1] != "" {
+			datum.Dimensions = append(datum.Dimensions, types.Dimension{Name: aws.String(dimensions[i]), Value: aws.String(dimensions[i+1])})
+		}
+	}
+	return datum
+}
+
+// cloudWatchData aggregates the subscriptions per account: the number of
+// active subscriptions, the days until the soonest end of an active
+// subscription and the summed quantity of the active subscriptions per SKU.
+// Unlimited quantities are left out of the sums.
+func cloudWatchData(subs []rhsm.Subscription, now time.Time) []types.MetricDatum {
+	subs, _ = collector.MergeDuplicates(subs)
+
+	var active []rhsm.Subscription
+	counts := map[string]int{}
+	soonest := map[string]time.Time{}
+	for _, s := range subs {
+		if s.StartDate.After(now) || (!s.EndDate.IsZero() && !s.EndDate.After(now)) {
+			continue
+		}
+		active = append(active, s)
+		counts[s.Account]++
+		if !s.EndDate.IsZero() && (soonest[s.Account].IsZero() || s.EndDate.Before(soonest[s.Account])) {
+			soonest[s.Account] = s.EndDate
+		}
+	}
+
+	var data []types.MetricDatum
+	for _, account := range accountsOf(subs) {
+		data = append(data, cloudWatchDatum("ActiveSubscriptions", float64(counts[account]), types.StandardUnitCount, now, "Account", account))
+		if end, ok := soonest[account]; ok {
+			days := math.Floor(end.Sub(now).Hours() / 24)
+			data = append(data, cloudWatchDatum("DaysUntilSoonestExpiry", days, types.StandardUnitNone, now, "Account", account))
+		}
+	}
+	quantities := sumQuantities(active)
+	keys := slices.Collect(maps.Keys(quantities))
+	slices.SortFunc(keys, func(a, b skuKey) int {
+		return cmp.Or(cmp.Compare(a.account, b.account), cmp.Compare(a.sku, b.sku))
+	})
+	for _, key := range keys {
+		data = append(data, cloudWatchDatum("TotalQuantity", quantities[key], types.StandardUnitCount, now, "Account", key.account, "SKU", key.sku))
+	}
+	return data
+}
+
+// publishCloudWatch puts the aggregates of the subscriptions into the
+// -cloudwatch.namespace with the standard AWS credentials
+func publishCloudWatch(ctx context.Context, subs []rhsm.Subscription) error {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	client := cloudwatch.NewFromConfig(cfg)
+
+	for batch := range slices.Chunk(cloudWatchData(subs, currentTime()), cloudWatchBatchSize) {
+		if _, err := client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(cloudWatchNamespace),
+			MetricData: batch,
+		}); err != nil {
+			return fmt.Errorf("failed to put metric data: %w", err)
+		}
+	}
+	return nil
+}
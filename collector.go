@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	CollectorUpGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_exporter_collector_up",
+		Help: "Whether the last run of the collector succeeded (1) or failed (0).",
+	},
+		[]string{"collector"})
+	CollectorPanicsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_exporter_collector_panics_total",
+		Help: "Total number of recovered panics per collector.",
+	},
+		[]string{"collector"})
+)
+
+// runCollector runs fn and turns a panic into an error, so a single
+// malformed response can't take down the whole exporter
+func runCollector(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Collector panicked", "collector", name, "panic", r, "stack", string(debug.Stack()))
+			CollectorPanicsCounter.WithLabelValues(name).Inc()
+			err = fmt.Errorf("collector %s panicked: %v", name, r)
+		}
+		if err != nil {
+			CollectorUpGauge.WithLabelValues(name).Set(0)
+		} else {
+			CollectorUpGauge.WithLabelValues(name).Set(1)
+		}
+	}()
+
+	return fn()
+}
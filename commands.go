@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// command is the subcommand given after the flags, empty for the legacy
+// behavior of serve that exports once with -export or -export-textfile
+var command string
+
+// subcommand is a command with its description for the usage
+type subcommand struct {
+	name        string
+	description string
+}
+
+// commands are the subcommands
+var commands = []subcommand{
+	{"serve", "Run the exporter and serve the metrics (default)"},
+	{"export", "Fetch once, write -export and/or -export-textfile and exit"},
+	{"check", "Fetch once, check the -check.* thresholds and exit 0/1/2 (OK/WARNING/CRITICAL)"},
+	{"validate", "Check the config, the offline token and the first page of subscriptions and exit"},
+	{"tui", "Show a live dashboard in the terminal instead of serving HTTP"},
+	{"dashboard", "Print a Grafana dashboard for the metric names and labels of the flags and exit"},
+	{"rules", "Print Prometheus alerting rules for the -check.* thresholds and labels of the flags and exit"},
+}
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] [command] [flags]\n\nCommands:\n", os.Args[0])
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-10s %s\n", c.name, c.description)
+		}
+		fmt.Fprintln(out, "\nFlags:")
+		flag.PrintDefaults()
+	}
+}
+
+// parseCommand takes the subcommand from the arguments left by flag.Parse
+// and parses the flags following it
+func parseCommand() error {
+	if flag.NArg() == 0 {
+		return nil
+	}
+	command = flag.Arg(0)
+	if !slices.ContainsFunc(commands, func(c subcommand) bool { return c.name == command }) {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+		return err
+	}
+	if flag.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments after %s: %v", command, flag.Args())
+	}
+	return nil
+}
+
+// exportOnce reports whether the exports are written after the first fetch
+// and the process exits, either by the export command or by the deprecated
+// -export without a command
+func exportOnce() bool {
+	switch command {
+	case "export":
+		return true
+	case "":
+		return exportScheduleSpec == "" && (exportToFile != "" || exportTextfile != "")
+	}
+	return false
+}
+
+// validateCommand checks the flags needed by the command
+func validateCommand() error {
+	switch command {
+	case "export":
+		if exportToFile == "" && exportTextfile == "" {
+			return errors.New("the export command requires -export or -export-textfile")
+		}
+		if exportScheduleSpec != "" {
+			return errors.New("the export command writes once, use serve with -export.schedule")
+		}
+	case "serve":
+		if (exportToFile != "" || exportTextfile != "") && exportScheduleSpec == "" {
+			return errors.New("serve writes -export and -export-textfile only with -export.schedule, use the export command to write them once")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// configEnvVars maps the keys of the -config file to the env var of the same
+// setting. Values from the file are only used when neither the flag nor the
+// env var is set, so the precedence is flags > env > file. Keys match the flag
+// names and may be nested, e.g. web.listen-address or web: {listen-address: }.
+var configEnvVars = map[string]string{
+	"api.url":                       "RH_API_URL",
+	"api.token-url":                 "RH_TOKEN_URL",
+	"api.offline-token":             "RH_OFFLINE_TOKEN",
+	"api.offline-token-file":        "RH_OFFLINE_TOKEN_FILE",
+	"api.client-id":                 "RH_CLIENT_ID",
+	"api.client-secret":             "RH_CLIENT_SECRET",
+	"api.client-secret-file":        "RH_CLIENT_SECRET_FILE",
+	"fetch.interval":                "RH_FETCH_INTERVAL",
+	"fetch.conditional":             "RH_FETCH_CONDITIONAL",
+	"audit.file":                    "RH_AUDIT_FILE",
+	"history.db":                    "RH_HISTORY_DB",
+	"history.retention":             "RH_HISTORY_RETENTION",
+	"history.trend-window":          "RH_HISTORY_TREND_WINDOW",
+	"state.file":                    "RH_STATE_FILE",
+	"cache.ttl":                     "RH_CACHE_TTL",
+	"fetch.jitter":                  "RH_FETCH_JITTER",
+	"export":                        "RH_EXPORT_FILE",
+	"export.keep":                   "RH_EXPORT_KEEP",
+	"export-gzip":                   "RH_EXPORT_GZIP",
+	"export-encrypt":                "RH_EXPORT_ENCRYPT",
+	"export.schedule":               "RH_EXPORT_SCHEDULE",
+	"export-textfile":               "RH_EXPORT_TEXTFILE",
+	"export-format":                 "RH_EXPORT_FORMAT",
+	"source":                        "RH_SOURCE",
+	"candlepin.url":                 "RH_CANDLEPIN_URL",
+	"candlepin.owner":               "RH_CANDLEPIN_OWNER",
+	"candlepin.username":            "RH_CANDLEPIN_USERNAME",
+	"candlepin.password":            "RH_CANDLEPIN_PASSWORD",
+	"entitlement.dir":               "RH_ENTITLEMENT_DIR",
+	"collector.systems":             "RH_COLLECTOR_SYSTEMS",
+	"collector.pools":               "RH_COLLECTOR_POOLS",
+	"collector.system-entitlements": "RH_COLLECTOR_SYSTEM_ENTITLEMENTS",
+	"collector.allocations":         "RH_COLLECTOR_ALLOCATIONS",
+	"collector.errata":              "RH_COLLECTOR_ERRATA",
+	"sca.detect":                    "RH_SCA_DETECT",
+	"sca.consumption":               "RH_SCA_CONSUMPTION",
+	"import-url":                    "RH_IMPORT_URL",
+	"import-bearer-token":           "RH_IMPORT_BEARER_TOKEN",
+	"import-bearer-token-file":      "RH_IMPORT_BEARER_TOKEN_FILE",
+	"import-header":                 "RH_IMPORT_HEADERS",
+	"import-file":                   "RH_IMPORT_FILE",
+	"import-username":               "RH_IMPORT_USERNAME",
+	"import-password":               "RH_IMPORT_PASSWORD",
+	"import-signature-key":          "RH_IMPORT_SIGNATURE_KEY",
+	"import-signature-suffix":       "RH_IMPORT_SIGNATURE_SUFFIX",
+	"metrics.compat":                "RH_METRICS_COMPAT",
+	"metrics.info-labels":           "RH_METRICS_INFO_LABELS",
+	"labels":                        "RH_LABELS",
+	"relabel":                       "RH_RELABEL",
+	"quantity.unlimited":            "RH_QUANTITY_UNLIMITED",
+	"metrics.stale-cycles":          "RH_STALE_CYCLES",
+	"sanitize.max-length":           "RH_SANITIZE_MAX_LENGTH",
+	"anonymize-labels":              "RH_ANONYMIZE_LABELS",
+	"anonymize-labels.salt":         "RH_ANONYMIZE_SALT",
+	"metrics.timestamps":            "RH_METRICS_TIMESTAMPS",
+	"metrics.attributes":            "RH_METRICS_ATTRIBUTES",
+	"metrics.fiscal-year-start":     "RH_FISCAL_YEAR_START",
+	"metrics.renewal-window":        "RH_RENEWAL_WINDOW",
+	"circuit.failures":              "RH_CIRCUIT_FAILURES",
+	"circuit.interval":              "RH_CIRCUIT_INTERVAL",
+	"retry.max-attempts":            "RH_RETRY_MAX_ATTEMPTS",
+	"retry.backoff":                 "RH_RETRY_BACKOFF",
+	"retry.max-backoff":             "RH_RETRY_MAX_BACKOFF",
+	"retry.max-retry-after":         "RH_RETRY_MAX_RETRY_AFTER",
+	"retry.max-rate-limited":        "RH_RETRY_MAX_RATE_LIMITED",
+	"fetch.empty-response":          "RH_EMPTY_RESPONSE",
+	"fetch.lenient":                 "RH_FETCH_LENIENT",
+	"fetch.concurrency":             "RH_FETCH_CONCURRENCY",
+	"fetch.page-size":               "RH_PAGE_SIZE",
+	"web.systemd-socket":            "RH_SYSTEMD_SOCKET",
+	"web.disable-exporter-metrics":  "RH_WEB_DISABLE_EXPORTER_METRICS",
+	"web.enable-lifecycle":          "RH_WEB_ENABLE_LIFECYCLE",
+	"web.socket-mode":               "RH_SOCKET_MODE",
+	"leader.election":               "RH_LEADER_ELECTION",
+	"leader.lease-name":             "RH_LEADER_LEASE_NAME",
+	"leader.namespace":              "RH_LEADER_NAMESPACE",
+	"leader.identity":               "RH_LEADER_IDENTITY",
+	"leader.lease-duration":         "RH_LEADER_LEASE_DURATION",
+	"leader.advertise-url":          "RH_LEADER_ADVERTISE_URL",
+	"leader.username":               "RH_LEADER_USERNAME",
+	"leader.password":               "RH_LEADER_PASSWORD",
+	"leader.ca-file":                "RH_LEADER_CA_FILE",
+	"leader.insecure-skip-verify":   "RH_LEADER_INSECURE_SKIP_VERIFY",
+	"web.listen-address":            "RH_LISTEN_ADDRESS",
+	"web.telemetry-path":            "RH_TELEMETRY_PATH",
+	"web.config.file":               "RH_WEB_CONFIG_FILE",
+	"no-cost.skus":                  "RH_NO_COST_SKUS",
+	"no-cost.include-in-aggregates": "RH_NO_COST_INCLUDE_IN_AGGREGATES",
+	"capacity.pool-types":           "RH_CAPACITY_POOL_TYPES",
+	"counting.modes":                "RH_COUNTING_MODES",
+	"product-families":              "RH_PRODUCT_FAMILIES",
+	"notify.slack-url":              "RH_NOTIFY_SLACK_URL",
+	"notify.teams-url":              "RH_NOTIFY_TEAMS_URL",
+	"notify.smtp.address":           "RH_NOTIFY_SMTP_ADDRESS",
+	"notify.smtp.username":          "RH_NOTIFY_SMTP_USERNAME",
+	"notify.smtp.password":          "RH_NOTIFY_SMTP_PASSWORD",
+	"notify.smtp.from":              "RH_NOTIFY_SMTP_FROM",
+	"notify.email-to":               "RH_NOTIFY_EMAIL_TO",
+	"notify.days":                   "RH_NOTIFY_DAYS",
+	"notify.schedule":               "RH_NOTIFY_SCHEDULE",
+	"remote-write.url":              "RH_REMOTE_WRITE_URL",
+	"remote-write.username":         "RH_REMOTE_WRITE_USERNAME",
+	"remote-write.password":         "RH_REMOTE_WRITE_PASSWORD",
+	"remote-write.bearer-token":     "RH_REMOTE_WRITE_BEARER_TOKEN",
+	"graphite.address":              "RH_GRAPHITE_ADDRESS",
+	"graphite.prefix":               "RH_GRAPHITE_PREFIX",
+	"graphite.tags":                 "RH_GRAPHITE_TAGS",
+	"influx.url":                    "RH_INFLUX_URL",
+	"influx.org":                    "RH_INFLUX_ORG",
+	"influx.bucket":                 "RH_INFLUX_BUCKET",
+	"influx.token":                  "RH_INFLUX_TOKEN",
+	"cloudwatch.namespace":          "RH_CLOUDWATCH_NAMESPACE",
+	"zabbix.server":                 "RH_ZABBIX_SERVER",
+	"zabbix.host":                   "RH_ZABBIX_HOST",
+	"statsd.address":                "RH_STATSD_ADDRESS",
+	"statsd.prefix":                 "RH_STATSD_PREFIX",
+	"statsd.tag-format":             "RH_STATSD_TAG_FORMAT",
+	"otlp.enabled":                  "RH_OTLP_ENABLED",
+	"otlp.traces":                   "RH_OTLP_TRACES",
+	"health.stale-multiple":         "RH_HEALTH_STALE_MULTIPLE",
+	"debug.pprof":                   "RH_DEBUG_PPROF",
+	"debug.pprof-address":           "RH_DEBUG_PPROF_ADDRESS",
+	"watchdog.multiple":             "RH_WATCHDOG_MULTIPLE",
+	"environment":                   "RH_ENVIRONMENT",
+	"api.token-url-fallbacks":       "RH_TOKEN_URL_FALLBACKS",
+	"api.offline-client-id":         "RH_OFFLINE_CLIENT_ID",
+	"api.scopes":                    "RH_API_SCOPES",
+	"api.rate-limit":                "RH_API_RATE_LIMIT",
+	"api.rate-burst":                "RH_API_RATE_BURST",
+	"api.token-refresh-before":      "RH_TOKEN_REFRESH_BEFORE",
+	"api.verify-token":              "RH_VERIFY_TOKEN",
+	"api.token-inactivity-window":   "RH_TOKEN_INACTIVITY_WINDOW",
+	"api.token-inactivity-warn":     "RH_TOKEN_INACTIVITY_WARN",
+	"now-override":                  "RH_NOW_OVERRIDE",
+	"tls.ca-file":                   "RH_TLS_CA_FILE",
+	"tls.cert-file":                 "RH_TLS_CERT_FILE",
+	"tls.key-file":                  "RH_TLS_KEY_FILE",
+	"tls.insecure-skip-verify":      "RH_TLS_INSECURE_SKIP_VERIFY",
+	"mock-server":                   "RH_MOCK_SERVER",
+	"mock.dataset":                  "RH_MOCK_DATASET",
+	"mock.latency":                  "RH_MOCK_LATENCY",
+	"mock.error-rate":               "RH_MOCK_ERROR_RATE",
+	"mock.rate-limit-rate":          "RH_MOCK_RATE_LIMIT_RATE",
+	"mock.offline-token":            "RH_MOCK_OFFLINE_TOKEN",
+	"mock.sca":                      "RH_MOCK_SCA",
+	"mock.token-ttl":                "RH_MOCK_TOKEN_TTL",
+	"check.warning-days":            "RH_CHECK_WARNING_DAYS",
+	"check.critical-days":           "RH_CHECK_CRITICAL_DAYS",
+	"check.warning-usage":           "RH_CHECK_WARNING_USAGE",
+	"check.critical-usage":          "RH_CHECK_CRITICAL_USAGE",
+	"tui.refresh-interval":          "RH_TUI_REFRESH_INTERVAL",
+	"tui.rows":                      "RH_TUI_ROWS",
+	"rules.format":                  "RH_RULES_FORMAT",
+	"rules.namespace":               "RH_RULES_NAMESPACE",
+	"rules.job":                     "RH_RULES_JOB",
+	"log.level":                     "RH_LOG_LEVEL",
+	"log.format":                    "RH_LOG_FORMAT",
+	"fetch.schedule":                "RH_FETCH_SCHEDULE",
+	"http.timeout":                  "RH_HTTP_TIMEOUT",
+	"http.dial-timeout":             "RH_HTTP_DIAL_TIMEOUT",
+	"fetch.timeout":                 "RH_FETCH_TIMEOUT",
+	"http.user-agent-suffix":        "RH_USER_AGENT_SUFFIX",
+	"proxy.url":                     "RH_PROXY_URL",
+	"proxy.token-url":               "RH_PROXY_TOKEN_URL",
+	"proxy.no-proxy":                "RH_NO_PROXY",
+	"selfcheck.allow-nan":           "RH_SELFCHECK_ALLOW_NAN",
+	"accounts.discovery-url":        "RH_ACCOUNTS_DISCOVERY_URL",
+	"accounts.discovery-param":      "RH_ACCOUNTS_DISCOVERY_PARAM",
+	"filter.sku-include":            "RH_FILTER_SKU_INCLUDE",
+	"filter.sku-exclude":            "RH_FILTER_SKU_EXCLUDE",
+	"filter.status-exclude":         "RH_FILTER_STATUS_EXCLUDE",
+	"filter.expired-lookback":       "RH_FILTER_EXPIRED_LOOKBACK",
+	"accounts.include":              "RH_ACCOUNTS_INCLUDE",
+	"accounts.exclude":              "RH_ACCOUNTS_EXCLUDE",
+	"accounts":                      "RH_ACCOUNTS",
+	"refresh.bearer-token":          "RH_REFRESH_BEARER_TOKEN",
+	"refresh.min-interval":          "RH_REFRESH_MIN_INTERVAL",
+	"secrets.watch":                 "RH_SECRETS_WATCH",
+	"vault.address":                 "RH_VAULT_ADDR",
+	"vault.path":                    "RH_VAULT_PATH",
+	"vault.key":                     "RH_VAULT_KEY",
+	"vault.auth":                    "RH_VAULT_AUTH",
+	"vault.auth-mount":              "RH_VAULT_AUTH_MOUNT",
+	"vault.role":                    "RH_VAULT_ROLE",
+	"vault.jwt-file":                "RH_VAULT_JWT_FILE",
+	"vault.approle.role-id":         "RH_VAULT_ROLE_ID",
+	"vault.approle.secret-id":       "RH_VAULT_SECRET_ID",
+	"vault.refresh-interval":        "RH_VAULT_REFRESH_INTERVAL",
+	"vault.ca-file":                 "RH_VAULT_CACERT",
+	"vault.namespace":               "RH_VAULT_NAMESPACE",
+}
+
+// configFile is the path given via -config or RH_CONFIG_FILE
+var configFile string
+
+// configFileFromArgs finds the -config flag before the flags are parsed,
+// because the file provides the env defaults of all other flags
+func configFileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("RH_CONFIG_FILE")
+}
+
+// flattenConfig flattens nested maps into dotted keys and lists into
+// comma-separated values
+func flattenConfig(prefix string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			key := fmt.Sprint(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenConfig(key, child, out)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+// jsonValue converts the maps of a YAML value into maps with string keys,
+// which encoding/json can marshal
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			m[fmt.Sprint(k)] = jsonValue(child)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = jsonValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+// readConfigFile parses the YAML config file into flattened keys
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	// The relabeling rules are structured, they are passed on as json
+	if rules, ok := raw["relabel"]; ok {
+		data, err := json.Marshal(jsonValue(rules))
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel in %s: %w", path, err)
+		}
+		values["relabel"] = string(data)
+		delete(raw, "relabel")
+	}
+	// Header values may contain commas, so the headers are one per line
+	if headers, ok := raw["import-header"].([]interface{}); ok {
+		lines := make([]string, 0, len(headers))
+		for _, h := range headers {
+			lines = append(lines, fmt.Sprint(h))
+		}
+		values["import-header"] = strings.Join(lines, "\n")
+		delete(raw, "import-header")
+	}
+	// The product families map SKUs, which aren't keys of their own
+	if families, ok := raw["product-families"].(map[interface{}]interface{}); ok {
+		pairs := make([]string, 0, len(families))
+		for sku, family := range families {
+			pairs = append(pairs, fmt.Sprint(sku)+"="+fmt.Sprint(family))
+		}
+		slices.Sort(pairs)
+		values["product-families"] = strings.Join(pairs, ",")
+		delete(raw, "product-families")
+	}
+	flattenConfig("", raw, values)
+
+	var unknown []string
+	for key := range values {
+		if _, ok := configEnvVars[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return nil, fmt.Errorf("unknown keys in %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// loadConfigFile applies the values of the config file to all env vars which
+// are not set already
+func loadConfigFile(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		env := configEnvVars[key]
+		if os.Getenv(env) != "" {
+			continue
+		}
+		if err := os.Setenv(env, value); err != nil {
+			return err
+		}
+		configEnvFromFile[env] = true
+	}
+	return nil
+}
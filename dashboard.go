@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+)
+
+// dashboardPanel is a panel of the generated dashboard
+type dashboardPanel = map[string]interface{}
+
+// endMetric returns the name of the end date metric exported with
+// -metrics.compat
+func endMetric() string {
+	if metricsCompat == "new" {
+		return "redhat_subscription_end_timestamp_seconds"
+	}
+	return "redhat_subscription_end"
+}
+
+// dashboardSelector returns the selector of the template variables of the
+// -labels, e.g. {region=~"$region"}, empty without -labels
+func dashboardSelector() string {
+	var matchers []string
+	for _, name := range slices.Sorted(maps.Keys(staticLabels)) {
+		matchers = append(matchers, name+`=~"$`+name+`"`)
+	}
+	if len(matchers) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// dashboardThresholds returns the threshold steps of a panel, starting with
+// base and changing to the colors of the non-zero values in order
+func dashboardThresholds(base string, steps ...interface{}) map[string]interface{} {
+	list := []map[string]interface{}{{"color": base, "value": nil}}
+	for i := 0; i+1 < len(steps); i += 2 {
+		switch v := steps[i+1].(type) {
+		case int:
+			if v <= 0 {
+				continue
+			}
+		case float64:
+			if v <= 0 {
+				continue
+			}
+		}
+		list = append(list, map[string]interface{}{"color": steps[i], "value": steps[i+1]})
+	}
+	return map[string]interface{}{"mode": "absolute", "steps": list}
+}
+
+// newDashboardPanel returns a panel with one query on the datasource
+// variable at the grid position
+func newDashboardPanel(id int, kind, title, expr string, x, y, w, h int) dashboardPanel {
+	return dashboardPanel{
+		"id":         id,
+		"type":       kind,
+		"title":      title,
+		"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+		"gridPos":    map[string]int{"h": h, "w": w, "x": x, "y": y},
+		"targets":    []map[string]interface{}{{"refId": "A", "expr": expr}},
+	}
+}
+
+// generateDashboard returns a Grafana dashboard for the metric names of
+// -metrics.compat, the -metrics.info-labels as table columns and a template
+// variable per label of -labels. The thresholds are those of the check
+// command and the renewal window of -metrics.renewal-window.
+func generateDashboard() map[string]interface{} {
+	sel := dashboardSelector()
+	static := slices.Sorted(maps.Keys(staticLabels))
+	// The static labels are on both sides, so the join stays one-to-one when
+	// several deployments are selected
+	on := strings.Join(append([]string{"subscriptionNumber"}, static...), ", ")
+
+	variables := []map[string]interface{}{{"name": "datasource", "type": "datasource", "query": "prometheus"}}
+	for _, name := range static {
+		variables = append(variables, map[string]interface{}{
+			"name":       name,
+			"type":       "query",
+			"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+			"query":      "label_values(redhat_subscription_info, " + name + ")",
+			"refresh":    1,
+			"multi":      true,
+			"includeAll": true,
+			"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+		})
+	}
+
+	columns := infoLabels()
+	if columns == nil {
+		columns = collector.DefaultInfoLabels
+	}
+	index := map[string]int{"subscriptionNumber": 0}
+	for i, name := range columns {
+		index[name] = i + 1
+	}
+	index["Value"] = len(columns) + 1
+	exclude := map[string]bool{"Time": true, "__name__": true, "instance": true, "job": true}
+	for _, name := range []string{"no_cost", "sca", "stale"} {
+		if !slices.Contains(columns, name) {
+			exclude[name] = true
+		}
+	}
+
+	table := newDashboardPanel(1, "table", "Subscriptions",
+		fmt.Sprintf("redhat_subscription_info%s * on (%s) group_left redhat_subscription_days_remaining%s", sel, on, sel), 0, 0, 24, 12)
+	table["targets"].([]map[string]interface{})[0]["format"] = "table"
+	table["targets"].([]map[string]interface{})[0]["instant"] = true
+	table["transformations"] = []map[string]interface{}{{
+		"id": "organize",
+		"options": map[string]interface{}{
+			"excludeByName": exclude,
+			"indexByName":   index,
+			"renameByName":  map[string]string{"Value": "Days remaining"},
+		},
+	}}
+	table["fieldConfig"] = map[string]interface{}{
+		"defaults": map[string]interface{}{},
+		"overrides": []map[string]interface{}{{
+			"matcher": map[string]string{"id": "byName", "options": "Days remaining"},
+			"properties": []map[string]interface{}{
+				{"id": "custom.cellOptions", "value": map[string]string{"type": "color-background"}},
+				{"id": "thresholds", "value": dashboardThresholds("red", "orange", checkCriticalDays, "green", checkWarningDays)},
+			},
+		}},
+	}
+
+	days := int(renewalWindow.Hours() / 24)
+	renewal := newDashboardPanel(2, "stat", fmt.Sprintf("Ending within %d days", days),
+		fmt.Sprintf("count(redhat_subscription_in_renewal_window%s == 1) or vector(0)", sel), 0, 12, 6, 6)
+
+	next := newDashboardPanel(3, "stat", "Next end date",
+		fmt.Sprintf("min(%s%s > time()) * 1000", endMetric(), sel), 6, 12, 6, 6)
+	next["fieldConfig"] = map[string]interface{}{"defaults": map[string]string{"unit": "dateTimeAsIso"}, "overrides": []interface{}{}}
+
+	quantity := newDashboardPanel(4, "stat", "Total quantity",
+		fmt.Sprintf("sum(redhat_subscription_quantity%s)", sel), 12, 12, 6, 6)
+
+	fetchErrors := newDashboardPanel(5, "timeseries", "Fetch errors",
+		fmt.Sprintf("increase(redhat_subscription_fetch_errors_total%s[1h])", sel), 18, 12, 6, 6)
+
+	utilization := newDashboardPanel(6, "timeseries", "Most utilized pools",
+		fmt.Sprintf("topk(10, redhat_subscription_pool_utilization%s)", sel), 0, 18, 24, 8)
+	utilization["targets"].([]map[string]interface{})[0]["legendFormat"] = "{{subscriptionNumber}} {{pool}}"
+	// The -check.*-usage thresholds are percentages, the utilization a ratio
+	utilization["fieldConfig"] = map[string]interface{}{
+		"defaults": map[string]interface{}{
+			"unit":       "percentunit",
+			"min":        0,
+			"max":        1,
+			"thresholds": dashboardThresholds("green", "orange", checkWarningUsage/100, "red", checkCriticalUsage/100),
+			"custom":     map[string]interface{}{"thresholdsStyle": map[string]string{"mode": "line"}},
+		},
+		"overrides": []interface{}{},
+	}
+
+	return map[string]interface{}{
+		"title":         "Red Hat Subscriptions",
+		"uid":           "redhat-subscriptions",
+		"schemaVersion": 39,
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"templating":    map[string]interface{}{"list": variables},
+		"panels":        []dashboardPanel{table, renewal, next, quantity, fetchErrors, utilization},
+	}
+}
+
+// runDashboard prints the generated dashboard and returns the exit code
+func runDashboard() int {
+	data, err := json.MarshalIndent(generateDashboard(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+var (
+	pprofEnabled bool
+	pprofAddress string
+)
+
+// pprofMux serves the profiles of net/http/pprof under /debug/pprof/
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// withPprof serves the profiles on the main listener with -debug.pprof and
+// no -debug.pprof-address. Importing net/http/pprof registers them in the
+// default mux, so they are hidden otherwise.
+func withPprof(h http.Handler) http.Handler {
+	profiles := pprofMux()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !pprofEnabled || pprofAddress != "" {
+			http.NotFound(w, r)
+			return
+		}
+		profiles.ServeHTTP(w, r)
+	})
+}
+
+// servePprof serves the profiles on -debug.pprof-address until ctx is
+// cancelled, with the TLS and auth settings of -web.config.file
+func servePprof(ctx context.Context) {
+	server := &http.Server{Handler: pprofMux()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := listenAndServe(server, pprofAddress, false); err != nil && err != http.ErrServerClosed {
+		slog.Error("pprof server failed", "address", pprofAddress, "err", err)
+	}
+}
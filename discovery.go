@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	discoveryURL       string
+	discoveryParam     string
+	discoveryInclude   string
+	discoveryExclude   string
+	discoveryIncludeRe *regexp.Regexp
+	discoveryExcludeRe *regexp.Regexp
+)
+
+// discoveredAccount is an entry of the account discovery response
+type discoveredAccount struct {
+	ID            string `json:"id"`
+	AccountNumber string `json:"accountNumber"`
+	OrgID         string `json:"orgId"`
+	Name          string `json:"name"`
+}
+
+// id returns the identifier passed to the API in -accounts.discovery-param
+func (d discoveredAccount) id() string {
+	for _, id := range []string{d.AccountNumber, d.OrgID, d.ID} {
+		if id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// compileDiscoveryPatterns compiles -accounts.include and -accounts.exclude
+func compileDiscoveryPatterns() (include, exclude *regexp.Regexp, err error) {
+	if discoveryInclude != "" {
+		if include, err = regexp.Compile(discoveryInclude); err != nil {
+			return nil, nil, fmt.Errorf("invalid -accounts.include: %w", err)
+		}
+	}
+	if discoveryExclude != "" {
+		if exclude, err = regexp.Compile(discoveryExclude); err != nil {
+			return nil, nil, fmt.Errorf("invalid -accounts.exclude: %w", err)
+		}
+	}
+	return include, exclude, nil
+}
+
+// discoveryMatches applies the include and exclude patterns to the id and
+// name of an account
+func discoveryMatches(d discoveredAccount) bool {
+	matches := func(re *regexp.Regexp) bool {
+		return re.MatchString(d.id()) || (d.Name != "" && re.MatchString(d.Name))
+	}
+	if discoveryIncludeRe != nil && !matches(discoveryIncludeRe) {
+		return false
+	}
+	return discoveryExcludeRe == nil || !matches(discoveryExcludeRe)
+}
+
+// discoverAccounts lists the customer accounts the token of a can access at
+// -accounts.discovery-url. The response is a json list of accounts or an
+// object with the list in "body", like the subscriptions API.
+func discoverAccounts(ctx context.Context, a account) ([]account, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	body, err := doWithRetry(a.client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []discoveredAccount
+	if err := json.Unmarshal(body, &list); err != nil {
+		var wrapped struct {
+			Body []discoveredAccount `json:"body"`
+		}
+		if err := json.Unmarshal(body, &wrapped); err != nil {
+			return nil, fmt.Errorf("decode failed: %w", err)
+		}
+		list = wrapped.Body
+	}
+
+	var accounts []account
+	for _, d := range list {
+		if d.id() == "" || !discoveryMatches(d) {
+			continue
+		}
+		name := d.Name
+		if name == "" {
+			name = d.id()
+		}
+		accounts = append(accounts, account{
+			Name:   name,
+			client: a.client,
+			query:  url.Values{discoveryParam: {d.id()}},
+		})
+	}
+	return accounts, nil
+}
+
+// accountURL adds the query parameters selecting a discovered account to
+// apiUrl
+func accountURL(apiUrl string, a account) string {
+	if len(a.query) == 0 {
+		return apiUrl
+	}
+	sep := "?"
+	if strings.Contains(apiUrl, "?") {
+		sep = "&"
+	}
+	return apiUrl + sep + a.query.Encode()
+}
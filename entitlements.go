@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var collectSystemEntitlements bool
+
+var systemEntitlementsRegistry = prometheus.NewRegistry()
+
+var SystemEntitlementsGauge = promauto.With(systemEntitlementsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+	Name: "redhat_system_entitlements",
+	Help: "Number of entitlements a registered system consumes from a pool.",
+},
+	[]string{"pool", "system_name"})
+
+func init() {
+	optionalCollectors = append(optionalCollectors, &optionalCollector{
+		name:     "system_entitlements",
+		path:     "systems",
+		enabled:  &collectSystemEntitlements,
+		registry: systemEntitlementsRegistry,
+		collect:  collectSystemEntitlementMetrics,
+	})
+}
+
+// systemPool identifies the entitlements of a system from a pool
+type systemPool struct {
+	pool, system string
+}
+
+// collectSystemEntitlementMetrics fetches the entitlements of every
+// registered system. Organizations in Simple Content Access mode don't attach
+// entitlements, their systems aren't fetched one by one.
+func collectSystemEntitlementMetrics(ctx context.Context, client *rhsm.Client) error {
+	org, err := client.FetchOrganization(ctx)
+	if err != nil {
+		return err
+	}
+	if org.SCA() {
+		slog.Debug("Skipping system entitlements, the organization is in Simple Content Access mode")
+		SystemEntitlementsGauge.Reset()
+		return nil
+	}
+
+	systems, err := client.FetchSystems(ctx)
+	if err != nil {
+		return err
+	}
+	consumed := map[systemPool]int{}
+	for _, s := range systems {
+		entitlements, err := client.FetchSystemEntitlements(ctx, s.UUID)
+		if err != nil {
+			return err
+		}
+		for _, e := range entitlements {
+			consumed[systemPool{e.PoolID, s.Name}] += e.Quantity
+		}
+	}
+
+	SystemEntitlementsGauge.Reset()
+	for key, quantity := range consumed {
+		SystemEntitlementsGauge.WithLabelValues(key.pool, key.system).Set(float64(quantity))
+	}
+	return nil
+}
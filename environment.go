@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// redhatEnvironment is a Red Hat environment with a matching SSO and API
+type redhatEnvironment struct {
+	tokenURL string
+	apiURL   string
+}
+
+// environments are the Red Hat environments selectable with -environment
+var environments = map[string]redhatEnvironment{
+	"production": {
+		tokenURL: DefaultTokenURL,
+		apiURL:   DefaultApiURL,
+	},
+	"stage": {
+		tokenURL: "https://sso.stage.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token",
+		apiURL:   "https://api.access.stage.redhat.com/management/v1/subscriptions",
+	},
+}
+
+// environmentName is the -environment
+var environmentName string
+
+// validateEnvironment checks that -environment names a known environment
+func validateEnvironment() error {
+	if _, ok := environments[environmentName]; !ok {
+		return fmt.Errorf("invalid -environment %q, must be one of %s", environmentName, strings.Join(slices.Sorted(maps.Keys(environments)), ", "))
+	}
+	return nil
+}
+
+// tokenURL returns the SSO token URL, RH_TOKEN_URL or the one of the
+// -environment
+func tokenURL() string {
+	return getEnv("RH_TOKEN_URL", environments[environmentName].tokenURL)
+}
+
+// apiURL returns the subscriptions endpoint, RH_API_URL or the one of the
+// -environment
+func apiURL() string {
+	return getEnv("RH_API_URL", environments[environmentName].apiURL)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var collectErrata bool
+
+var errataRegistry = prometheus.NewRegistry()
+
+var (
+	ErrataGauge = promauto.With(errataRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_errata",
+		Help: "Number of errata applicable to systems of the account by type (security, bugfix or enhancement) and severity.",
+	},
+		[]string{"type", "severity"})
+	ErrataAffectedSystemsGauge = promauto.With(errataRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_errata_affected_systems",
+		Help: "Sum of the systems affected by the applicable errata of the type.",
+	},
+		[]string{"type"})
+)
+
+// errataTypes maps the advisory types of the API to the type label
+var errataTypes = map[string]string{
+	"security advisory":            "security",
+	"bug fix advisory":             "bugfix",
+	"product enhancement advisory": "enhancement",
+}
+
+func init() {
+	optionalCollectors = append(optionalCollectors, &optionalCollector{
+		name:     "errata",
+		path:     "errata",
+		enabled:  &collectErrata,
+		registry: errataRegistry,
+		collect:  collectErrataMetrics,
+	})
+}
+
+// collectErrataMetrics fetches the applicable errata and counts them by type
+// and severity
+func collectErrataMetrics(ctx context.Context, client *rhsm.Client) error {
+	errata, err := client.FetchErrata(ctx)
+	if err != nil {
+		return err
+	}
+
+	type key struct{ errataType, severity string }
+	counts := map[key]int{}
+	affected := map[string]int{}
+	// Every type is exported, so a type without errata is 0 instead of absent
+	for _, t := range errataTypes {
+		affected[t] = 0
+	}
+	for _, e := range errata {
+		t, ok := errataTypes[strings.ToLower(e.Type)]
+		if !ok {
+			t = valueOrUnknown(strings.ToLower(e.Type))
+		}
+		counts[key{t, valueOrUnknown(strings.ToLower(e.Severity))}]++
+		affected[t] += e.AffectedSystemsCount
+	}
+
+	ErrataGauge.Reset()
+	for k, n := range counts {
+		ErrataGauge.WithLabelValues(k.errataType, k.severity).Set(float64(n))
+	}
+	ErrataAffectedSystemsGauge.Reset()
+	for t, n := range affected {
+		ErrataAffectedSystemsGauge.WithLabelValues(t).Set(float64(n))
+	}
+	return nil
+}
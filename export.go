@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	exportGzip bool
+	exportKeep int
+
+	ExportLastSuccessGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_export_last_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful write of an export file.",
+	},
+		[]string{"file"})
+	ExportBytesGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_export_bytes",
+		Help: "Size of the export file in bytes after the last successful write.",
+	},
+		[]string{"file"})
+	ExportInfoGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_export_info",
+		Help: "SHA-256 checksum of the export file after the last successful write, always 1.",
+	},
+		[]string{"file", "sha256"})
+	ExportErrorsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_export_errors_total",
+		Help: "Total number of failed writes of an export file.",
+	},
+		[]string{"file"})
+)
+
+// exportPlaceholders are replaced in the export file names by the date of
+// the export
+var exportPlaceholders = map[byte]string{'Y': "2006", 'm': "01", 'd': "02", 'H': "15", 'M': "04", 'S': "05"}
+
+// exportPath replaces the %Y, %m, %d, %H, %M and %S placeholders of the export
+// file name template with the date t, %% is a literal %
+func exportPath(template string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] == '%' && i+1 < len(template) {
+			if layout, ok := exportPlaceholders[template[i+1]]; ok {
+				b.WriteString(t.Format(layout))
+				i++
+				continue
+			}
+			if template[i+1] == '%' {
+				b.WriteByte('%')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(template[i])
+	}
+	return b.String()
+}
+
+// pruneExports removes all but the newest -export.keep files written for the
+// template. Templates without placeholders name a single file anyway.
+func pruneExports(template string) {
+	if exportKeep <= 0 {
+		return
+	}
+	pattern := template
+	for placeholder := range exportPlaceholders {
+		pattern = strings.ReplaceAll(pattern, "%"+string(placeholder), "*")
+	}
+	if pattern == template {
+		return
+	}
+	files, err := filepath.Glob(strings.ReplaceAll(pattern, "%%", "%"))
+	if err != nil {
+		slog.Warn("Failed to list old exports", "file", template, "err", err)
+		return
+	}
+
+	// Oldest first, the placeholders don't need to be in date order
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	slices.SortFunc(files, func(a, b string) int {
+		return modTimes[a].Compare(modTimes[b])
+	})
+	for _, file := range files[:max(len(files)-exportKeep, 0)] {
+		if err := os.Remove(file); err != nil {
+			slog.Warn("Failed to remove old export", "file", file, "err", err)
+			continue
+		}
+		slog.Debug("Removed old export", "file", file)
+	}
+}
+
+// recordExport exports the integrity metrics of an export file after it was
+// written, so the air-gap relay can be monitored without checking mtimes. The
+// metrics are labeled with the file name template, not the expanded name.
+func recordExport(template, path string, err error) error {
+	if err == nil {
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			sum := sha256.Sum256(data)
+			ExportLastSuccessGauge.WithLabelValues(template).Set(float64(time.Now().Unix()))
+			ExportBytesGauge.WithLabelValues(template).Set(float64(len(data)))
+			ExportInfoGauge.DeletePartialMatch(prometheus.Labels{"file": template})
+			ExportInfoGauge.WithLabelValues(template, hex.EncodeToString(sum[:])).Set(1)
+			pruneExports(template)
+			return nil
+		}
+	}
+	ExportErrorsCounter.WithLabelValues(template).Inc()
+	return err
+}
+
+// stdoutExport is the export file name writing to stdout instead
+const stdoutExport = "-"
+
+// writeJSONExport saves subs as json to the file named by template, gzip
+// compressed with -export-gzip and encrypted with -export-encrypt. "-"
+// writes to stdout.
+func writeJSONExport(template string, subs []rhsm.Subscription) error {
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if exportGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	if exportEncrypt != nil {
+		if data, err = exportEncrypt(data); err != nil {
+			return err
+		}
+	} else if template == stdoutExport {
+		data = append(data, '\n')
+	}
+	if template == stdoutExport {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	path := exportPath(template, time.Now())
+	return recordExport(template, path, os.WriteFile(path, data, 0644))
+}
+
+// decodeExport decodes subscriptions written by writeJSONExport, gzip
+// compressed data is detected by its magic number
+func decodeExport(data []byte) ([]rhsm.Subscription, error) {
+	if looksEncrypted(data) {
+		return nil, errors.New("the file is encrypted, decrypt it with age -d or gpg -d before importing it")
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("gunzip failed: %w", err)
+		}
+	}
+
+	return decodeImportRecords(data)
+}
+
+// writeTextfileExport saves the subscription metrics in the Prometheus text
+// format, or the InfluxDB line protocol with -export-format=influx, to the
+// file named by template. "-" writes to stdout.
+func writeTextfileExport(template string) error {
+	if exportFormat == "influx" {
+		return writeInfluxExport(template)
+	}
+	if template == stdoutExport {
+		families, err := labeledGatherer(subscriptionsRegistry).Gather()
+		if err != nil {
+			return err
+		}
+		enc := expfmt.NewEncoder(os.Stdout, expfmt.NewFormat(expfmt.TypeTextPlain))
+		for _, mf := range families {
+			if err := enc.Encode(mf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	path := exportPath(template, time.Now())
+	return recordExport(template, path, prometheus.WriteToTextfile(path, labeledGatherer(subscriptionsRegistry)))
+}
+
+// runScheduledExport writes the configured exports from the data of the last
+// successful fetch, used with -export.schedule instead of exiting after the
+// first fetch
+func runScheduledExport(ctx context.Context) error {
+	if !ready.Load() {
+		return errors.New("no successful fetch yet")
+	}
+
+	var errs []error
+	if exportToFile != "" {
+		updateMu.Lock()
+		subs := lastSubscriptions
+		updateMu.Unlock()
+		errs = append(errs, writeJSONExport(exportToFile, subs))
+	}
+	if exportTextfile != "" {
+		updateMu.Lock()
+		err := writeTextfileExport(exportTextfile)
+		updateMu.Unlock()
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExportPath(t *testing.T) {
+	at := time.Date(2029, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "subs.json", want: "subs.json"},
+		{template: "subs-%Y-%m-%d.json", want: "subs-2029-03-04.json"},
+		{template: "/exports/%Y/%m/subs-%H%M%S.json", want: "/exports/2029/03/subs-050607.json"},
+		{template: "100%%.json", want: "100%.json"},
+		{template: "%%Y-%Y.json", want: "%Y-2029.json"},
+		{template: "subs-%x.json", want: "subs-%x.json"},
+		{template: "subs%", want: "subs%"},
+	}
+	for _, tt := range tests {
+		if got := exportPath(tt.template, at); got != tt.want {
+			t.Errorf("exportPath(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestPruneExports(t *testing.T) {
+	defer func(keep int) { exportKeep = keep }(exportKeep)
+
+	tests := []struct {
+		name     string
+		template string
+		keep     int
+		files    []string
+		want     []string
+	}{
+		{
+			name:     "keeps newest",
+			template: "subs-%Y%m%d.json",
+			keep:     2,
+			files:    []string{"subs-20290101.json", "subs-20290102.json", "subs-20290103.json", "other.json"},
+			want:     []string{"other.json", "subs-20290102.json", "subs-20290103.json"},
+		},
+		{
+			name:     "fewer than keep",
+			template: "subs-%Y%m%d.json",
+			keep:     5,
+			files:    []string{"subs-20290101.json", "subs-20290102.json"},
+			want:     []string{"subs-20290101.json", "subs-20290102.json"},
+		},
+		{
+			name:     "disabled",
+			template: "subs-%Y%m%d.json",
+			keep:     0,
+			files:    []string{"subs-20290101.json", "subs-20290102.json"},
+			want:     []string{"subs-20290101.json", "subs-20290102.json"},
+		},
+		{
+			name:     "no placeholders",
+			template: "subs.json",
+			keep:     1,
+			files:    []string{"subs.json", "subs-old.json"},
+			want:     []string{"subs-old.json", "subs.json"},
+		},
+		{
+			name:     "literal percent",
+			template: "100%%-%d.json",
+			keep:     1,
+			files:    []string{"100%-01.json", "100%-02.json"},
+			want:     []string{"100%-02.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// The files are written a day apart in the listed order
+			modTime := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+			for _, name := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("[]"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+				modTime = modTime.Add(24 * time.Hour)
+			}
+
+			exportKeep = tt.keep
+			pruneExports(filepath.Join(dir, tt.template))
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+var (
+	exportEncryptFlag listFlag
+
+	// exportEncrypt encrypts the -export file, nil without -export-encrypt
+	exportEncrypt func(data []byte) ([]byte, error)
+)
+
+// parseExportRecipients returns the encryption of the -export file for the
+// recipients. A recipient is an age public key (age1...), a file with age
+// public keys, one per line, or a file with a GPG key ring, armored or
+// binary. age and GPG recipients can't be mixed, there is a single
+// encrypted file.
+func parseExportRecipients(specs []string) (func(data []byte) ([]byte, error), error) {
+	var ageRecipients []age.Recipient
+	var gpgRecipients openpgp.EntityList
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, "age1") {
+			r, err := age.ParseX25519Recipient(spec)
+			if err != nil {
+				return nil, err
+			}
+			ageRecipients = append(ageRecipients, r)
+			continue
+		}
+
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimSpace(string(data))
+		switch {
+		case strings.HasPrefix(text, "-----BEGIN PGP PUBLIC KEY BLOCK-----"):
+			keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("invalid GPG key %s: %w", spec, err)
+			}
+			gpgRecipients = append(gpgRecipients, keyring...)
+		case strings.HasPrefix(text, "age1") || strings.HasPrefix(text, "#"):
+			recipients, err := age.ParseRecipients(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipients %s: %w", spec, err)
+			}
+			ageRecipients = append(ageRecipients, recipients...)
+		default:
+			keyring, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("unknown type of recipient %s, expected age public keys or a GPG key ring", spec)
+			}
+			gpgRecipients = append(gpgRecipients, keyring...)
+		}
+	}
+
+	switch {
+	case len(ageRecipients) > 0 && len(gpgRecipients) > 0:
+		return nil, errors.New("age and GPG recipients can't be combined")
+	case len(ageRecipients) > 0:
+		return func(data []byte) ([]byte, error) {
+			return encryptWith(data, func(w io.Writer) (io.WriteCloser, error) {
+				return age.Encrypt(w, ageRecipients...)
+			})
+		}, nil
+	case len(gpgRecipients) > 0:
+		for _, entity := range gpgRecipients {
+			if _, ok := entity.EncryptionKey(time.Now()); !ok {
+				return nil, fmt.Errorf("GPG key %X has no valid encryption key", entity.PrimaryKey.Fingerprint)
+			}
+		}
+		return func(data []byte) ([]byte, error) {
+			return encryptWith(data, func(w io.Writer) (io.WriteCloser, error) {
+				return openpgp.Encrypt(w, gpgRecipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+			})
+		}, nil
+	}
+	return nil, nil
+}
+
+// encryptWith encrypts data with the writer returned by encrypt
+func encryptWith(data []byte, encrypt func(w io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := encrypt(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the export: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt the export: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt the export: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ageHeader starts every file encrypted with age
+const ageHeader = "age-encryption.org/v1\n"
+
+// looksEncrypted reports whether data is an age or binary GPG encrypted file,
+// which has to be decrypted before it can be imported
+func looksEncrypted(data []byte) bool {
+	if bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(data, []byte("-----BEGIN AGE ENCRYPTED FILE-----")) || bytes.HasPrefix(data, []byte("-----BEGIN PGP MESSAGE-----")) {
+		return true
+	}
+	// A binary OpenPGP message starts with a public-key encrypted session
+	// key packet, tag 1, in the old or the new packet format
+	return len(data) > 0 && (data[0]&0xfc == 0x84 || data[0] == 0xc1)
+}
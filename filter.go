@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+var (
+	skuInclude   string
+	skuExclude   string
+	skuIncludeRe *regexp.Regexp
+	skuExcludeRe *regexp.Regexp
+	// statusExclude is the comma-separated list of -filter.status-exclude
+	statusExclude string
+	// expiredLookback drops subscriptions that ended longer ago, 0 keeps all
+	expiredLookback time.Duration
+)
+
+// compileFilters compiles -filter.sku-include and -filter.sku-exclude
+func compileFilters() (include, exclude *regexp.Regexp, err error) {
+	if skuInclude != "" {
+		if include, err = regexp.Compile(skuInclude); err != nil {
+			return nil, nil, fmt.Errorf("invalid -filter.sku-include: %w", err)
+		}
+	}
+	if skuExclude != "" {
+		if exclude, err = regexp.Compile(skuExclude); err != nil {
+			return nil, nil, fmt.Errorf("invalid -filter.sku-exclude: %w", err)
+		}
+	}
+	return include, exclude, nil
+}
+
+// filterMatches applies the filters to a subscription, statuses are the
+// excluded statuses
+func filterMatches(s rhsm.Subscription, statuses []string, now time.Time) bool {
+	if expiredLookback > 0 && !s.EndDate.IsZero() && s.EndDate.Before(now.Add(-expiredLookback)) {
+		return false
+	}
+	if skuIncludeRe != nil && !skuIncludeRe.MatchString(s.SKU) {
+		return false
+	}
+	if skuExcludeRe != nil && skuExcludeRe.MatchString(s.SKU) {
+		return false
+	}
+	return !slices.ContainsFunc(statuses, func(status string) bool { return strings.EqualFold(status, s.Status) })
+}
+
+// filterSubscriptions drops the subscriptions not matching the filters, so
+// they are neither exported as metrics nor written to exports
+func filterSubscriptions(subs []rhsm.Subscription) []rhsm.Subscription {
+	if skuIncludeRe == nil && skuExcludeRe == nil && statusExclude == "" && expiredLookback <= 0 {
+		return subs
+	}
+	statuses := splitList(statusExclude)
+	now := currentTime()
+	return slices.DeleteFunc(subs, func(s rhsm.Subscription) bool { return !filterMatches(s, statuses, now) })
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/graphite"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// graphiteTimeout bounds a push to -graphite.address
+const graphiteTimeout = 15 * time.Second
+
+var (
+	graphiteAddress string
+	graphitePrefix  string
+	graphiteTags    bool
+
+	GraphiteErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_graphite_errors_total",
+		Help: "Total number of failed pushes to the -graphite.address carbon endpoint.",
+	})
+)
+
+// pushGraphite sends the current metrics to the -graphite.address carbon
+// endpoint in the plaintext protocol. Without -graphite.tags the labels are
+// appended to the path, e.g. prefix.redhat_subscription_quantity.account.a.
+func pushGraphite(gatherer prometheus.Gatherer) error {
+	bridge, err := graphite.NewBridge(&graphite.Config{
+		URL:           graphiteAddress,
+		Prefix:        graphitePrefix,
+		UseTags:       graphiteTags,
+		Timeout:       graphiteTimeout,
+		Gatherer:      gatherer,
+		ErrorHandling: graphite.AbortOnError,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Debug("Pushing metrics to Graphite", "address", graphiteAddress)
+	return bridge.Push()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	historyDB        string
+	historyRetention time.Duration
+
+	// history is the store of -history.db, nil without it
+	history *historyStore
+
+	HistoryErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_subscription_history_errors_total",
+		Help: "Total number of fetches that failed to be recorded in -history.db.",
+	})
+)
+
+// historySchema creates the tables of the history database. Every fetch is a
+// row of fetches, the subscriptions are only stored again in a new snapshot
+// when they changed, so fetching often doesn't grow the database much.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS snapshots (
+	id INTEGER PRIMARY KEY,
+	hash TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS fetches (
+	fetched_at INTEGER NOT NULL,
+	snapshot_id INTEGER NOT NULL REFERENCES snapshots(id)
+);
+CREATE INDEX IF NOT EXISTS fetches_fetched_at ON fetches(fetched_at);
+CREATE TABLE IF NOT EXISTS subscriptions (
+	snapshot_id INTEGER NOT NULL REFERENCES snapshots(id),
+	account TEXT NOT NULL,
+	subscription_number TEXT NOT NULL,
+	subscription_name TEXT NOT NULL,
+	sku TEXT NOT NULL,
+	contract_number TEXT NOT NULL,
+	quantity TEXT NOT NULL,
+	status TEXT NOT NULL,
+	start_date INTEGER,
+	end_date INTEGER
+);
+CREATE INDEX IF NOT EXISTS subscriptions_snapshot_id ON subscriptions(snapshot_id);
+`
+
+// historyStore records the fetches in a SQLite database
+type historyStore struct {
+	db *sql.DB
+	// lastHash and lastSnapshot are those of the latest snapshot
+	lastHash     string
+	lastSnapshot int64
+}
+
+// openHistory opens or creates the history database at path
+func openHistory(path string) (*historyStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, the pragmas apply per connection
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;" + historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the schema of %s: %w", path, err)
+	}
+
+	h := &historyStore{db: db}
+	err = db.QueryRow("SELECT id, hash FROM snapshots ORDER BY id DESC LIMIT 1").Scan(&h.lastSnapshot, &h.lastHash)
+	if err != nil && err != sql.ErrNoRows {
+		db.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// close closes the database
+func (h *historyStore) close() error {
+	return h.db.Close()
+}
+
+// historyRows returns the merged subscriptions in a stable order, so equal
+// snapshots hash equally
+func historyRows(subs []rhsm.Subscription) []rhsm.Subscription {
+	rows, _ := collector.MergeDuplicates(subs)
+	rows = slices.Clone(rows)
+	slices.SortFunc(rows, func(a, b rhsm.Subscription) int {
+		return cmp.Or(cmp.Compare(a.Account, b.Account), cmp.Compare(a.SubscriptionNumber, b.SubscriptionNumber))
+	})
+	for i := range rows {
+		rows[i].Pools = nil
+	}
+	return rows
+}
+
+// unixOrNull returns the unix timestamp of t, nil for the zero time
+func unixOrNull(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// record stores a fetch of subs at t and removes the fetches older than
+// -history.retention
+func (h *historyStore) record(subs []rhsm.Subscription, t time.Time) error {
+	rows := historyRows(subs)
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshot := h.lastSnapshot
+	if hash != h.lastHash {
+		res, err := tx.Exec("INSERT INTO snapshots (hash, created_at) VALUES (?, ?)", hash, t.Unix())
+		if err != nil {
+			return err
+		}
+		if snapshot, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(`INSERT INTO subscriptions (snapshot_id, account, subscription_number, subscription_name, sku, contract_number, quantity, status, start_date, end_date)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, s := range rows {
+			if _, err := stmt.Exec(snapshot, s.Account, s.SubscriptionNumber, s.SubscriptionName, s.SKU, s.ContractNumber, s.Quantity, s.Status, unixOrNull(s.StartDate), unixOrNull(s.EndDate)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO fetches (fetched_at, snapshot_id) VALUES (?, ?)", t.Unix(), snapshot); err != nil {
+		return err
+	}
+
+	if historyRetention > 0 {
+		cutoff := t.Add(-historyRetention).Unix()
+		if _, err := tx.Exec("DELETE FROM fetches WHERE fetched_at < ?", cutoff); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM subscriptions WHERE snapshot_id NOT IN (SELECT snapshot_id FROM fetches)"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM snapshots WHERE id NOT IN (SELECT snapshot_id FROM fetches)"); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	h.lastHash = hash
+	h.lastSnapshot = snapshot
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsReadScope is the OAuth scope needed to download objects from GCS
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// fetchObject downloads an s3://bucket/key or gs://bucket/object import URL
+// with the standard credentials of the cloud, e.g. the AWS_* env vars,
+// shared config files or instance roles for S3 and the application default
+// credentials for GCS
+func fetchObject(ctx context.Context, client *http.Client, importURL *url.URL) ([]byte, error) {
+	bucket := importURL.Host
+	key := strings.TrimPrefix(importURL.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid import URL %q, expected %s://bucket/object", importURL.Redacted(), importURL.Scheme)
+	}
+
+	switch importURL.Scheme {
+	case "s3":
+		return fetchS3Object(ctx, client, bucket, key)
+	case "gs":
+		return fetchGCSObject(ctx, client, bucket, key)
+	}
+	return nil, fmt.Errorf("unsupported import URL scheme %q", importURL.Scheme)
+}
+
+// fetchS3Object downloads an object from S3 or, with AWS_ENDPOINT_URL or
+// AWS_ENDPOINT_URL_S3, from an S3 compatible store addressed path-style. The
+// object is downloaded with the proxy and TLS settings of the exporter, the
+// credentials are looked up with those of the AWS SDK.
+func fetchS3Object(ctx context.Context, client *http.Client, bucket, key string) ([]byte, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.HTTPClient = client
+		o.UsePathStyle = o.BaseEndpoint != nil
+	})
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get s3://%s/%s: %w", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+// fetchGCSObject downloads an object from GCS or, with STORAGE_EMULATOR_HOST,
+// from an emulator without credentials
+func fetchGCSObject(ctx context.Context, client *http.Client, bucket, object string) ([]byte, error) {
+	base := "https://storage.googleapis.com"
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		base = "http://" + strings.TrimPrefix(host, "http://")
+	} else {
+		ts, err := google.DefaultTokenSource(context.WithValue(ctx, oauth2.HTTPClient, client), gcsReadScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Google credentials: %w", err)
+		}
+		client = &http.Client{Transport: &oauth2.Transport{Source: ts, Base: client.Transport}, Timeout: client.Timeout}
+	}
+
+	objectURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", base, url.PathEscape(bucket), url.PathEscape(object))
+	req, err := http.NewRequestWithContext(ctx, "GET", objectURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	body, err := doWithRetry(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gs://%s/%s: %w", bucket, object, err)
+	}
+	return body, nil
+}
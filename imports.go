@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// listFlag collects a repeatable flag. A value may hold several
+// comma-separated items like the env var, flags replace the env var.
+// Repeated items are kept once.
+type listFlag struct {
+	// defaults are the items of the env var
+	defaults string
+	items    []string
+}
+
+func (f *listFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	if len(f.items) == 0 {
+		return f.defaults
+	}
+	return strings.Join(f.items, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	for _, item := range splitList(value) {
+		if !slices.Contains(f.items, item) {
+			f.items = append(f.items, item)
+		}
+	}
+	return nil
+}
+
+func (f *listFlag) reset() {
+	f.items = nil
+}
+
+// values returns the items of the flag
+func (f *listFlag) values() []string {
+	return splitList(f.String())
+}
+
+// headersFlag collects the repeatable -import-header "Name: value" flag.
+// Since header values may contain commas, RH_IMPORT_HEADERS holds one header
+// per line. Flags replace RH_IMPORT_HEADERS.
+type headersFlag struct {
+	// defaults are the headers of RH_IMPORT_HEADERS
+	defaults string
+	headers  []string
+}
+
+func (f *headersFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	if len(f.headers) == 0 {
+		return f.defaults
+	}
+	return strings.Join(f.headers, "\n")
+}
+
+func (f *headersFlag) Set(value string) error {
+	for _, h := range strings.Split(value, "\n") {
+		if h = strings.TrimSpace(h); h != "" && !slices.Contains(f.headers, h) {
+			f.headers = append(f.headers, h)
+		}
+	}
+	return nil
+}
+
+func (f *headersFlag) reset() {
+	f.headers = nil
+}
+
+var headerNameRe = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// parse returns the headers of the flag
+func (f *headersFlag) parse() (http.Header, error) {
+	headers := http.Header{}
+	for _, h := range strings.Split(f.String(), "\n") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !headerNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid header %q, must be Name: value", h)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// importOptions authenticate the requests of -import-url
+type importOptions struct {
+	username string
+	password string
+	// bearerToken is sent as is, bearerTokenFile is read for every request
+	// so a token rotated by e.g. an OIDC sidecar is picked up
+	bearerToken     string
+	bearerTokenFile string
+	// headers are added to every request, e.g. an API key
+	headers http.Header
+}
+
+var (
+	importBearerToken     string
+	importBearerTokenFile string
+	importHeadersFlag     headersFlag
+	importHeaders         http.Header
+)
+
+// currentImportOptions returns the import options of the current settings
+func currentImportOptions() importOptions {
+	return importOptions{
+		username:        importUsername,
+		password:        importPassword,
+		bearerToken:     importBearerToken,
+		bearerTokenFile: importBearerTokenFile,
+		headers:         importHeaders,
+	}
+}
+
+// apply adds the credentials to an import request
+func (o importOptions) apply(req *http.Request) error {
+	if o.username != "" && o.password != "" {
+		req.SetBasicAuth(o.username, o.password)
+	}
+	token := o.bearerToken
+	if o.bearerTokenFile != "" {
+		data, err := os.ReadFile(o.bearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read bearer token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, values := range o.headers {
+		req.Header[name] = values
+	}
+	return nil
+}
+
+// importSource is one -import-url
+type importSource struct {
+	// name is the value of the source label
+	name string
+	url  string
+}
+
+var (
+	importURLs listFlag
+	// importSources are the parsed -import-url, a single one without a
+	// name doesn't set the source of its subscriptions
+	importSources []importSource
+
+	importSourceNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+	ImportErrorsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_subscription_import_errors_total",
+		Help: "Total number of failed imports per source when several -import-url are configured.",
+	},
+		[]string{"source"})
+)
+
+// parseImportSources parses import URLs given as url or name=url. The name
+// defaults to the URL without credentials.
+func parseImportSources(specs []string) ([]importSource, error) {
+	var sources []importSource
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		source := importSource{url: spec}
+		if name, rawURL, ok := strings.Cut(spec, "="); ok && importSourceNameRe.MatchString(name) && strings.Contains(rawURL, "://") {
+			source = importSource{name: name, url: rawURL}
+		}
+		u, err := url.Parse(source.url)
+		if err != nil || u.Scheme == "" {
+			return nil, fmt.Errorf("invalid import URL %q", spec)
+		}
+		if source.name == "" && len(specs) > 1 {
+			source.name = u.Redacted()
+		}
+		if seen[source.name] {
+			return nil, fmt.Errorf("duplicate import source %q", source.name)
+		}
+		seen[source.name] = true
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// fetchImports fetches the subscriptions of all sources and sets their
+// source. A failed source keeps its subscriptions as fetched for the last
+// update, the fetch only fails if all sources fail.
+func fetchImports(ctx context.Context, client *http.Client, sources []importSource, opts importOptions) ([]rhsm.Subscription, error) {
+	var all []rhsm.Subscription
+	var errs []error
+	for _, source := range sources {
+		subs, err := FetchImportedSubscriptions(ctx, client, source.url, opts)
+		if err != nil {
+			if len(sources) == 1 {
+				return nil, err
+			}
+			slog.Error("Error importing subscriptions", "source", source.name, "err", err)
+			ImportErrorsCounter.WithLabelValues(source.name).Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", source.name, err))
+
+			updateMu.Lock()
+			for _, s := range lastFetched {
+				if s.Source == source.name {
+					all = append(all, s)
+				}
+			}
+			updateMu.Unlock()
+			continue
+		}
+		for i := range subs {
+			subs[i].Source = source.name
+		}
+		all = append(all, subs...)
+	}
+	if len(errs) == len(sources) {
+		return nil, errors.Join(errs...)
+	}
+	return all, nil
+}
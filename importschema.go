@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxLoggedInvalidRecords is the number of invalid records logged per
+// import, the rest is only counted
+const maxLoggedInvalidRecords = 10
+
+// Reasons an imported record is rejected
+const (
+	invalidRecordMalformed    = "malformed"
+	invalidRecordMissingField = "missing_field"
+	invalidRecordInvalidValue = "invalid_value"
+)
+
+var (
+	ImportInvalidRecordsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_subscription_import_invalid_records_total",
+		Help: "Total number of distinct imported records skipped because they don't match the subscription schema, by reason: malformed, missing_field or invalid_value. A record is counted once while it stays in the imports.",
+	},
+		[]string{"reason"})
+
+	invalidRecordsMu sync.Mutex
+	// invalidRecordsSeen maps the checksums of the invalid records to when
+	// they were last imported, so a record left in the import is counted
+	// once and not again every fetch
+	invalidRecordsSeen = map[[sha256.Size]byte]time.Time{}
+)
+
+func init() {
+	for _, reason := range []string{invalidRecordMalformed, invalidRecordMissingField, invalidRecordInvalidValue} {
+		ImportInvalidRecordsCounter.WithLabelValues(reason)
+	}
+}
+
+// subscriptionFields are the json fields of a subscription
+var subscriptionFields = jsonFields(reflect.TypeFor[rhsm.Subscription]())
+
+// jsonFields returns the json names of the fields of the struct t
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// invalidRecordError is the reason and cause an imported record is rejected
+type invalidRecordError struct {
+	reason string
+	err    error
+}
+
+func (e *invalidRecordError) Error() string {
+	return e.err.Error()
+}
+
+// validateRecord decodes a single imported record. Unknown fields are
+// returned instead of rejecting the record, exports of newer versions may
+// have fields this version doesn't know.
+func validateRecord(record json.RawMessage) (rhsm.Subscription, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil || fields == nil {
+		return rhsm.Subscription{}, nil, &invalidRecordError{invalidRecordMalformed, errors.New("not a JSON object")}
+	}
+	var unknown []string
+	for name := range fields {
+		if !slices.Contains(subscriptionFields, name) {
+			unknown = append(unknown, name)
+		}
+	}
+
+	var s rhsm.Subscription
+	if err := json.Unmarshal(record, &s); err != nil {
+		return s, unknown, &invalidRecordError{invalidRecordMalformed, err}
+	}
+	if strings.TrimSpace(s.SubscriptionNumber) == "" {
+		return s, unknown, &invalidRecordError{invalidRecordMissingField, errors.New("subscriptionNumber is missing")}
+	}
+	if !s.StartDate.IsZero() && !s.EndDate.IsZero() && s.EndDate.Before(s.StartDate) {
+		return s, unknown, &invalidRecordError{invalidRecordInvalidValue, fmt.Errorf("endDate %s is before startDate %s", s.EndDate.Format("2006-01-02"), s.StartDate.Format("2006-01-02"))}
+	}
+	for _, p := range s.Pools {
+		if (p.Quantity < 0 && p.Quantity != -1) || p.Consumed < 0 {
+			return s, unknown, &invalidRecordError{invalidRecordInvalidValue, fmt.Errorf("pool %s has a negative quantity or consumption", p.ID)}
+		}
+	}
+	return s, unknown, nil
+}
+
+// newInvalidRecord records an invalid record and reports whether it wasn't
+// imported within the last fetch intervals, i.e. it is new or came back
+func newInvalidRecord(reason string, record json.RawMessage, now time.Time) bool {
+	invalidRecordsMu.Lock()
+	defer invalidRecordsMu.Unlock()
+
+	// Records missing from a few fetches were removed from the import
+	forget := now.Add(-3 * max(time.Duration(fetchInterval), minFetchInterval))
+	maps.DeleteFunc(invalidRecordsSeen, func(_ [sha256.Size]byte, seen time.Time) bool { return seen.Before(forget) })
+
+	sum := sha256.Sum256(append([]byte(reason+"\x00"), record...))
+	_, seen := invalidRecordsSeen[sum]
+	invalidRecordsSeen[sum] = now
+	return !seen
+}
+
+// decodeImportRecords decodes the subscriptions of an import record by
+// record. Records that don't match the schema are logged, counted and
+// skipped instead of failing the import, which only fails if the file isn't
+// a list or no record is valid.
+func decodeImportRecords(data []byte) ([]rhsm.Subscription, error) {
+	var records []json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+
+	subs := make([]rhsm.Subscription, 0, len(records))
+	now := time.Now()
+	var invalid int
+	var unknownFields []string
+	var lastErr error
+	for i, record := range records {
+		s, unknown, err := validateRecord(record)
+		for _, name := range unknown {
+			if !slices.Contains(unknownFields, name) {
+				unknownFields = append(unknownFields, name)
+			}
+		}
+		var recordErr *invalidRecordError
+		if errors.As(err, &recordErr) {
+			invalid++
+			lastErr = fmt.Errorf("record %d: %w", i, err)
+			if !newInvalidRecord(recordErr.reason, record, now) {
+				continue
+			}
+			ImportInvalidRecordsCounter.WithLabelValues(recordErr.reason).Inc()
+			if invalid <= maxLoggedInvalidRecords {
+				slog.Warn("Skipping invalid imported record", "record", i, "subscription", s.SubscriptionNumber, "reason", recordErr.reason, "err", err)
+			}
+			continue
+		}
+		subs = append(subs, s)
+	}
+
+	if len(unknownFields) > 0 {
+		slices.Sort(unknownFields)
+		slog.Warn("Ignoring unknown fields of the imported records, the import may be written by a newer version", "fields", unknownFields)
+	}
+	if invalid > 0 {
+		slog.Warn("Skipped invalid imported records", "invalid", invalid, "valid", len(subs))
+		if len(subs) == 0 {
+			return nil, fmt.Errorf("all %d records are invalid: %w", invalid, lastErr)
+		}
+	}
+	return subs, nil
+}
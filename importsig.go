@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/blake2b"
+)
+
+var (
+	importSignatureKey    string
+	importSignatureSuffix string
+
+	// importVerifier checks the signatures of the imports, nil without
+	// -import-signature-key
+	importVerifier signatureVerifier
+
+	ImportSignatureFailuresCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_subscription_import_signature_failures_total",
+		Help: "Total number of imports rejected because their detached signature was missing or invalid.",
+	})
+)
+
+// signatureVerifier checks a detached signature of an import
+type signatureVerifier interface {
+	verify(data, signature []byte) error
+	// suffix is appended to the import URL or file to find the signature
+	suffix() string
+}
+
+// loadSignatureVerifier reads the public key at path. The type of the key
+// is detected from its content: a minisign public key, an armored or binary
+// GPG key ring, or a PEM public key (ECDSA, Ed25519 or RSA) as written by
+// cosign generate-key-pair or openssl.
+func loadSignatureVerifier(path string) (signatureVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(text, "-----BEGIN PGP PUBLIC KEY BLOCK-----"):
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPG key %s: %w", path, err)
+		}
+		return gpgVerifier{keyring}, nil
+	case strings.HasPrefix(text, "-----BEGIN"):
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM key %s", path)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+			return pemVerifier{key}, nil
+		}
+		return nil, fmt.Errorf("unsupported public key %s of type %T", path, key)
+	case strings.HasPrefix(text, "untrusted comment:") || !strings.ContainsAny(text, "\n\x00"):
+		v, err := parseMinisignKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minisign key %s: %w", path, err)
+		}
+		return v, nil
+	}
+	keyring, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unknown type of key %s, expected a minisign, GPG or PEM public key", path)
+	}
+	return gpgVerifier{keyring}, nil
+}
+
+// signatureSuffix returns the suffix of the signatures of the imports
+func signatureSuffix() string {
+	if importSignatureSuffix != "" {
+		return importSignatureSuffix
+	}
+	return importVerifier.suffix()
+}
+
+// verifyImport checks the signature of an import, it is a no-op without
+// -import-signature-key. name identifies the import in the error.
+func verifyImport(name string, data, signature []byte) error {
+	if importVerifier == nil {
+		return nil
+	}
+	if err := importVerifier.verify(data, signature); err != nil {
+		ImportSignatureFailuresCounter.Inc()
+		return fmt.Errorf("signature verification of %s failed: %w", name, err)
+	}
+	return nil
+}
+
+// signatureMissing counts an import rejected for its missing signature
+func signatureMissing(name string, err error) error {
+	ImportSignatureFailuresCounter.Inc()
+	return fmt.Errorf("failed to read the signature of %s: %w", name, err)
+}
+
+// minisignVerifier checks signatures of minisign -S
+type minisignVerifier struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+// parseMinisignKey parses a minisign public key file or its base64 line
+func parseMinisignKey(text string) (minisignVerifier, error) {
+	lines := strings.Split(text, "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return minisignVerifier{}, err
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return minisignVerifier{}, errors.New("unsupported key format")
+	}
+	v := minisignVerifier{key: ed25519.PublicKey(raw[10:])}
+	copy(v.keyID[:], raw[2:10])
+	return v, nil
+}
+
+func (minisignVerifier) suffix() string {
+	return ".minisig"
+}
+
+// verify checks the signature and the trusted comment of a minisign
+// signature file. Signatures of minisign 0.10 and later sign the BLAKE2b hash
+// of the data (ED), older ones the data itself (Ed).
+func (v minisignVerifier) verify(data, signature []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid minisign signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], v.keyID[:]) {
+		return fmt.Errorf("signed by key %X, expected %X", sig[2:10], v.keyID)
+	}
+	message := data
+	switch string(sig[:2]) {
+	case "ED":
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	case "Ed":
+	default:
+		return fmt.Errorf("unsupported minisign algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(v.key, message, sig[10:]) {
+		return errors.New("invalid signature")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return errors.New("invalid minisign trusted comment signature")
+	}
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+	signed := append(slices.Clone(sig[10:]), comment...)
+	if !ed25519.Verify(v.key, signed, global) {
+		return errors.New("invalid signature of the trusted comment")
+	}
+	return nil
+}
+
+// pemVerifier checks signatures of cosign sign-blob --key or openssl dgst
+// -sha256 -sign, base64 encoded or raw
+type pemVerifier struct {
+	key crypto.PublicKey
+}
+
+func (pemVerifier) suffix() string {
+	return ".sig"
+}
+
+func (v pemVerifier) verify(data, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		sig = signature
+	}
+	digest := sha256.Sum256(data)
+	var ok bool
+	switch key := v.key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// gpgVerifier checks signatures of gpg --detach-sign, armored or binary
+type gpgVerifier struct {
+	keyring openpgp.EntityList
+}
+
+func (gpgVerifier) suffix() string {
+	return ".sig"
+}
+
+func (v gpgVerifier) verify(data, signature []byte) error {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	}
+	return err
+}
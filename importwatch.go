@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// importWatchDebounce is the time to wait for more writes to the import file
+// before it is re-read, so a file written in chunks is read once complete
+const importWatchDebounce = 500 * time.Millisecond
+
+// watchImportFile triggers a fetch whenever -import-file or its signature is
+// written or replaced. The directory is watched instead of the file itself,
+// so files replaced by a rename (e.g. by rsync or an atomic write) are
+// noticed as well.
+func watchImportFile(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		name := filepath.Clean(path)
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				changed := filepath.Clean(event.Name)
+				if (changed == name || importVerifier != nil && changed == name+signatureSuffix()) && event.Has(fsnotify.Write|fsnotify.Create) {
+					debounce = time.After(importWatchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Error watching import file", "file", path, "err", err)
+			case <-debounce:
+				debounce = nil
+				slog.Info("Import file changed, reading it again", "file", path)
+				requestRefresh()
+			}
+		}
+	}()
+	return nil
+}
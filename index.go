@@ -0,0 +1,69 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/common/version"
+)
+
+var indexPage = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Red Hat Subscription Exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+th, td { padding: 0.2em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Red Hat Subscription Exporter</h1>
+<ul>
+<li><a href="{{.TelemetryPath}}">Metrics</a></li>
+<li><a href="/subscriptions">Subscriptions</a></li>
+<li><a href="/api/v1/subscriptions">JSON API</a></li>
+<li><a href="/healthz">Health</a> and <a href="/readyz">readiness</a></li>
+<li><a href="/status">Scheduled tasks</a></li>
+</ul>
+<table>
+<tr><th>Version</th><td>{{.Version}} (revision {{.Revision}}{{if .BuildDate}}, built {{.BuildDate}}{{end}}, {{.GoVersion}})</td></tr>
+<tr><th>Last fetch</th><td>{{.Fetched}}</td></tr>
+<tr><th>Subscriptions</th><td>{{.Count}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// indexHandler serves the landing page at / with links to the endpoints and
+// the version and last fetch of the exporter
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	updateMu.Lock()
+	count := len(lastSubscriptions)
+	fetched := "never"
+	if !lastDataTime.IsZero() {
+		fetched = lastDataTime.UTC().Format(time.RFC3339) + ", " + time.Since(lastDataTime).Round(time.Second).String() + " ago"
+	}
+	updateMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := indexPage.Execute(w, map[string]interface{}{
+		"TelemetryPath": telemetryPath,
+		"Version":       version.Version,
+		"Revision":      version.GetRevision(),
+		"BuildDate":     version.BuildDate,
+		"GoVersion":     runtime.Version(),
+		"Fetched":       fetched,
+		"Count":         count,
+	})
+	if err != nil {
+		slog.Error("Error rendering index page", "err", err)
+	}
+}
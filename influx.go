@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	exportFormat string
+
+	influxURL    string
+	influxOrg    string
+	influxBucket string
+	influxToken  string
+
+	InfluxErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_influx_errors_total",
+		Help: "Total number of failed writes to the -influx.url InfluxDB endpoint.",
+	})
+)
+
+var (
+	// influxMeasurementReplacer escapes a measurement of the line protocol
+	influxMeasurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	// influxTagReplacer escapes a tag key or value of the line protocol
+	influxTagReplacer = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// influxLines formats series in the InfluxDB line protocol, a measurement
+// per metric with the labels as tags and the sample as the value field.
+// Empty labels are left out, the line protocol has no empty tag values.
+func influxLines(series []remoteWriteSeries, timestamp time.Time) []byte {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	for _, s := range series {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		var name string
+		var tags strings.Builder
+		for _, l := range s.Labels {
+			switch {
+			case l.Name == "__name__":
+				name = l.Value
+			case l.Value != "":
+				tags.WriteString("," + influxTagReplacer.Replace(l.Name) + "=" + influxTagReplacer.Replace(l.Value))
+			}
+		}
+		buf.WriteString(influxMeasurementReplacer.Replace(name))
+		buf.WriteString(tags.String())
+		buf.WriteString(" value=" + strconv.FormatFloat(s.Value, 'g', -1, 64) + " " + ts + "\n")
+	}
+	return buf.Bytes()
+}
+
+// gatherInfluxLines returns the current subscription metrics in the line
+// protocol with second precision
+func gatherInfluxLines(gatherer prometheus.Gatherer) ([]byte, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	return influxLines(seriesFromFamilies(families, nil), time.Now()), nil
+}
+
+// writeInfluxExport saves the subscription metrics in the line protocol to
+// the file named by template, e.g. for the file input of Telegraf. "-"
+// writes to stdout.
+func writeInfluxExport(template string) error {
+	data, err := gatherInfluxLines(labeledGatherer(subscriptionsRegistry))
+	if err != nil {
+		return err
+	}
+	if template == stdoutExport {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	path := exportPath(template, time.Now())
+	return recordExport(template, path, os.WriteFile(path, data, 0644))
+}
+
+// pushInflux writes the current metrics to the bucket of the -influx.url
+// InfluxDB v2 endpoint
+func pushInflux(ctx context.Context, client *http.Client, gatherer prometheus.Gatherer) error {
+	data, err := gatherInfluxLines(gatherer)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(influxURL, "/") + "/api/v2/write")
+	if err != nil {
+		return fmt.Errorf("invalid -influx.url: %w", err)
+	}
+	u.RawQuery = url.Values{"org": {influxOrg}, "bucket": {influxBucket}, "precision": {"s"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if influxToken != "" {
+		req.Header.Set("Authorization", "Token "+influxToken)
+	}
+
+	_, err = rhsm.DoOnce(client, req)
+	return err
+}
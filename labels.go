@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// labelsFlag collects the repeatable -labels key=value flag. A value may hold
+// several comma-separated pairs like RH_LABELS, flags replace RH_LABELS.
+type labelsFlag struct {
+	// defaults are the pairs of RH_LABELS
+	defaults string
+	pairs    []string
+}
+
+func (f *labelsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	if len(f.pairs) == 0 {
+		return f.defaults
+	}
+	return strings.Join(f.pairs, ",")
+}
+
+func (f *labelsFlag) Set(value string) error {
+	f.pairs = append(f.pairs, splitList(value)...)
+	return nil
+}
+
+func (f *labelsFlag) reset() {
+	f.pairs = nil
+}
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parse returns the labels of the flag
+func (f *labelsFlag) parse() (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range splitList(f.String()) {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label %q, must be name=value", pair)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+var (
+	staticLabelsFlag labelsFlag
+	// staticLabels are added to every exported series
+	staticLabels map[string]string
+)
+
+// anonymizedLabels are the labels whose values -anonymize-labels replaces
+var anonymizedLabels = []string{"contractNumber", "subscriptionNumber"}
+
+var (
+	anonymizeLabels bool
+	anonymizeSalt   string
+)
+
+// anonymize returns a stable hash of value keyed with -anonymize-labels.salt,
+// so the series of a subscription stay joinable without revealing its number.
+// Without the secret salt the few possible numbers could be brute-forced.
+func anonymize(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(anonymizeSalt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// anonymizeSubscriptions returns a copy of subs with the contract and
+// subscription numbers replaced by their hashes with -anonymize-labels, for
+// the push backends building their payloads from the subscriptions
+func anonymizeSubscriptions(subs []rhsm.Subscription) []rhsm.Subscription {
+	if !anonymizeLabels {
+		return subs
+	}
+	anonymized := slices.Clone(subs)
+	for i := range anonymized {
+		anonymized[i].SubscriptionNumber = anonymize(anonymized[i].SubscriptionNumber)
+		anonymized[i].ContractNumber = anonymize(anonymized[i].ContractNumber)
+	}
+	return anonymized
+}
+
+// labeledGatherer adds the -labels to every series of g. Series already
+// having one of the labels keep their own value, like with Prometheus
+// external labels. With -anonymize-labels the contract and subscription
+// numbers are replaced by their hashes.
+func labeledGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if len(staticLabels) == 0 && !anonymizeLabels {
+		return g
+	}
+	names := slices.Sorted(maps.Keys(staticLabels))
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		for _, mf := range families {
+			for _, m := range mf.Metric {
+				if anonymizeLabels {
+					for _, l := range m.Label {
+						if slices.Contains(anonymizedLabels, l.GetName()) {
+							value := anonymize(l.GetValue())
+							l.Value = &value
+						}
+					}
+				}
+				for _, name := range names {
+					value := staticLabels[name]
+					if slices.ContainsFunc(m.Label, func(l *dto.LabelPair) bool { return l.GetName() == name }) {
+						continue
+					}
+					m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+				}
+				slices.SortFunc(m.Label, func(a, b *dto.LabelPair) int { return strings.Compare(a.GetName(), b.GetName()) })
+			}
+		}
+		return families, err
+	})
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// leaderAddressAnnotation of the lease is the -leader.advertise-url of the
+// holder, the followers read the subscriptions from it
+const leaderAddressAnnotation = "redhat-subscription-exporter/advertise-url"
+
+var (
+	leaderElection      bool
+	leaderLeaseName     string
+	leaderNamespace     string
+	leaderIdentity      string
+	leaderLeaseDuration time.Duration
+	leaderAdvertiseURL  string
+	// leaderUsername, leaderPassword and leaderCAFile are the credentials
+	// and the CA of the -web.config.file of the leader
+	leaderUsername           string
+	leaderPassword           string
+	leaderCAFile             string
+	leaderInsecureSkipVerify bool
+
+	// leading is true while this replica holds the lease
+	leading atomic.Bool
+	// leaderURL is the advertised URL of the current leader
+	leaderURL atomic.Value
+
+	LeaderGauge = promauto.With(exporterRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "redhat_exporter_leader",
+		Help: "Whether this replica holds the -leader.lease-name lease and fetches from the API (1) or reads the subscriptions from the leader (0).",
+	})
+	LeaderTransitionsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_leader_transitions_total",
+		Help: "Total number of times this replica acquired or lost the lease.",
+	})
+)
+
+// following reports whether the subscriptions are read from the leader
+// instead of the API
+func following() bool {
+	return leaderElection && !leading.Load()
+}
+
+// lease is the subset of a coordination.k8s.io/v1 Lease the election uses
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string     `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int        `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          *microTime `json:"acquireTime,omitempty"`
+		RenewTime            *microTime `json:"renewTime,omitempty"`
+		LeaseTransitions     int        `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+// microTime is a Kubernetes MicroTime, RFC 3339 with microseconds
+type microTime struct{ time.Time }
+
+func (t microTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+}
+
+func (t *microTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	t.Time = parsed
+	return err
+}
+
+// errLeaseConflict is returned when another replica updated the lease first
+var errLeaseConflict = errors.New("the lease was updated concurrently")
+
+// leaseClient reads and writes the lease with the in-cluster credentials of
+// the pod's service account
+type leaseClient struct {
+	client *http.Client
+	url    string
+}
+
+// newLeaseClient returns a client for the lease of -leader.lease-name in
+// -leader.namespace
+func newLeaseClient() (*leaseClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("leader election requires running in Kubernetes, KUBERNETES_SERVICE_HOST is not set")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s/ca.crt", serviceAccountDir)
+	}
+	return &leaseClient{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			Timeout:   10 * time.Second,
+		},
+		url: fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", net.JoinHostPort(host, port), leaderNamespace),
+	}, nil
+}
+
+// do sends a request to the Kubernetes API and decodes the lease of the
+// response into out. The token is re-read every time, Kubernetes rotates it.
+func (c *leaseClient) do(ctx context.Context, method, url string, in *lease, out *lease) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return resp.StatusCode, errLeaseConflict
+	}
+	if resp.StatusCode >= 300 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &status)
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, status.Message)
+	}
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// elector holds the lease of this replica. A lease counts as expired after
+// its duration passed without a renewal observed on the local clock, so the
+// clocks of the replicas don't need to agree.
+type elector struct {
+	leases *leaseClient
+
+	observedVersion string
+	observedAt      time.Time
+}
+
+// tryAcquire creates, renews or takes over the lease and updates leading
+func (e *elector) tryAcquire(ctx context.Context, now time.Time) error {
+	var current lease
+	status, err := e.leases.do(ctx, "GET", e.leases.url+"/"+leaderLeaseName, nil, &current)
+	if status == http.StatusNotFound {
+		l := e.claim(nil, now)
+		if _, err := e.leases.do(ctx, "POST", e.leases.url, l, &current); err != nil {
+			return e.failed(err)
+		}
+		return e.won(&current, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.Metadata.ResourceVersion != e.observedVersion {
+		e.observedVersion = current.Metadata.ResourceVersion
+		e.observedAt = now
+	}
+	holder := current.Spec.HolderIdentity
+	leaseDuration := time.Duration(current.Spec.LeaseDurationSeconds) * time.Second
+	if holder != "" && holder != leaderIdentity && now.Sub(e.observedAt) < leaseDuration {
+		if u := current.Metadata.Annotations[leaderAddressAnnotation]; u != "" {
+			leaderURL.Store(u)
+		}
+		e.lost()
+		return nil
+	}
+
+	l := e.claim(&current, now)
+	if _, err := e.leases.do(ctx, "PUT", e.leases.url+"/"+leaderLeaseName, l, &current); err != nil {
+		return e.failed(err)
+	}
+	return e.won(&current, now)
+}
+
+// claim returns the lease held by this replica, based on the current one
+func (e *elector) claim(current *lease, now time.Time) *lease {
+	l := &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+	if current != nil {
+		*l = *current
+	}
+	l.Metadata.Name = leaderLeaseName
+	l.Metadata.Namespace = leaderNamespace
+	annotations := map[string]string{}
+	for k, v := range l.Metadata.Annotations {
+		annotations[k] = v
+	}
+	annotations[leaderAddressAnnotation] = leaderAdvertiseURL
+	l.Metadata.Annotations = annotations
+	if l.Spec.HolderIdentity != leaderIdentity {
+		if l.Spec.HolderIdentity != "" {
+			l.Spec.LeaseTransitions++
+		}
+		l.Spec.HolderIdentity = leaderIdentity
+		l.Spec.AcquireTime = &microTime{now}
+	}
+	l.Spec.LeaseDurationSeconds = int(leaderLeaseDuration.Seconds())
+	l.Spec.RenewTime = &microTime{now}
+	return l
+}
+
+// won records that this replica holds the lease
+func (e *elector) won(l *lease, now time.Time) error {
+	e.observedVersion = l.Metadata.ResourceVersion
+	e.observedAt = now
+	leaderURL.Store(leaderAdvertiseURL)
+	if !leading.Swap(true) {
+		slog.Info("Acquired the leader lease, fetching from the API", "lease", leaderLeaseName, "identity", leaderIdentity)
+		LeaderTransitionsCounter.Inc()
+		LeaderGauge.Set(1)
+	}
+	return nil
+}
+
+// lost records that this replica doesn't hold the lease
+func (e *elector) lost() {
+	if leading.Swap(false) {
+		slog.Warn("Lost the leader lease, reading the subscriptions from the leader", "lease", leaderLeaseName, "identity", leaderIdentity)
+		LeaderTransitionsCounter.Inc()
+		LeaderGauge.Set(0)
+	}
+}
+
+// failed handles an error writing the lease. A conflict means another
+// replica won the race and isn't an error, other errors keep the role until
+// the lease expires.
+func (e *elector) failed(err error) error {
+	if errors.Is(err, errLeaseConflict) {
+		e.lost()
+		return nil
+	}
+	return err
+}
+
+// release gives up the lease on shutdown, so a follower takes over without
+// waiting for it to expire
+func (e *elector) release(ctx context.Context) error {
+	if !leading.Load() {
+		return nil
+	}
+	var current lease
+	if _, err := e.leases.do(ctx, "GET", e.leases.url+"/"+leaderLeaseName, nil, &current); err != nil {
+		return err
+	}
+	if current.Spec.HolderIdentity != leaderIdentity {
+		return nil
+	}
+	current.Spec.HolderIdentity = ""
+	current.Spec.LeaseDurationSeconds = 1
+	current.Spec.RenewTime = &microTime{time.Now()}
+	_, err := e.leases.do(ctx, "PUT", e.leases.url+"/"+leaderLeaseName, &current, nil)
+	return err
+}
+
+// startLeaderElection tries to acquire the lease once, so the first fetch
+// already knows its role, and keeps renewing or retrying it every third of
+// -leader.lease-duration until ctx is cancelled. A leader that can't renew
+// the lease in time stops fetching from the API. The returned function waits
+// until the lease is released after ctx is cancelled.
+func startLeaderElection(ctx context.Context) (func(), error) {
+	leases, err := newLeaseClient()
+	if err != nil {
+		return nil, err
+	}
+	e := &elector{leases: leases}
+	if err := e.tryAcquire(ctx, time.Now()); err != nil {
+		slog.Error("Error acquiring the leader lease", "lease", leaderLeaseName, "err", err)
+	}
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		ticker := time.NewTicker(leaderLeaseDuration / 3)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := e.release(releaseCtx); err != nil {
+					slog.Error("Error releasing the leader lease", "lease", leaderLeaseName, "err", err)
+				}
+				cancel()
+				return
+			case now := <-ticker.C:
+				if err := e.tryAcquire(ctx, now); err != nil {
+					slog.Error("Error renewing the leader lease", "lease", leaderLeaseName, "err", err)
+					if leading.Load() && now.Sub(renewed) >= leaderLeaseDuration*2/3 {
+						e.lost()
+					}
+					continue
+				}
+				if leading.Load() {
+					renewed = now
+				}
+			}
+		}
+	}()
+	return func() { <-released }, nil
+}
+
+// newLeaderClient returns the client the followers read from the leader
+// with, trusting -leader.ca-file in addition to the system roots. The leader
+// is another replica, so neither the -proxy.* nor the -tls.* options of the
+// API apply.
+func newLeaderClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: leaderInsecureSkipVerify}
+	if leaderCAFile != "" {
+		pem, err := os.ReadFile(leaderCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -leader.ca-file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in -leader.ca-file %s", leaderCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{Timeout: httpDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	return &http.Client{Transport: transport, Timeout: httpTimeout}, nil
+}
+
+// fetchFromLeader reads the subscriptions the leader exported from its JSON
+// API, they are filtered and relabeled already
+func fetchFromLeader(ctx context.Context, client *http.Client) ([]rhsm.Subscription, error) {
+	u, _ := leaderURL.Load().(string)
+	if u == "" {
+		return nil, errors.New("the leader advertises no -leader.advertise-url")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(u, "/")+"/api/v1/subscriptions", nil)
+	if err != nil {
+		return nil, err
+	}
+	if leaderUsername != "" {
+		req.SetBasicAuth(leaderUsername, leaderPassword)
+	}
+	body, err := doWithRetry(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Subscriptions []rhsm.Subscription `json:"subscriptions"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decode of the subscriptions of the leader failed: %w", err)
+	}
+	return resp.Subscriptions, nil
+}
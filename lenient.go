@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var fetchLenient bool
+
+var PayloadAnomaliesCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "redhat_subscription_payload_anomalies_total",
+	Help: "Total number of unexpected values tolerated while decoding API responses with -fetch.lenient.",
+},
+	[]string{"kind"})
+
+// The anomaly counters are initialized so they are exported before the
+// first anomaly
+func init() {
+	for _, kind := range rhsm.AnomalyKinds {
+		PayloadAnomaliesCounter.WithLabelValues(kind)
+	}
+}
+
+// countAnomaly counts a value tolerated by the lenient decoder
+func countAnomaly(kind string) {
+	PayloadAnomaliesCounter.WithLabelValues(kind).Inc()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	enableLifecycle bool
+
+	// quitRequests is closed by the first request to /-/quit
+	quitRequests = make(chan struct{})
+	quitOnce     sync.Once
+	// draining is set once the exporter shuts down, /readyz fails from then
+	draining atomic.Bool
+)
+
+// quitHandler shuts the exporter down on POST or PUT /-/quit, like the
+// lifecycle API of Prometheus it is only served with -web.enable-lifecycle.
+// In-flight requests are completed and the fetch loop is stopped before the
+// process exits.
+func quitHandler(w http.ResponseWriter, r *http.Request) {
+	if !enableLifecycle {
+		http.Error(w, "Lifecycle API is not enabled.", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	quitOnce.Do(func() {
+		slog.Info("Quit requested via /-/quit", "remote", r.RemoteAddr)
+		close(quitRequests)
+	})
+	fmt.Fprintln(w, "Requesting termination... Goodbye!")
+}
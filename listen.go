@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// socketMode is the octal file mode of -web.socket-mode
+var socketMode string
+
+// parseSocketMode parses the octal -web.socket-mode
+func parseSocketMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid -web.socket-mode %q, must be an octal file mode like 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenAndServe serves server on address with the TLS and auth settings of
+// -web.config.file. unix:///path/to.sock listens on a unix socket with the
+// permissions of -web.socket-mode, so only local agents can scrape. With
+// activated the sockets passed by systemd are used instead of address.
+func listenAndServe(server *http.Server, address string, activated bool) error {
+	listenAddresses := []string{address}
+	flags := &web.FlagConfig{
+		WebListenAddresses: &listenAddresses,
+		WebSystemdSocket:   &activated,
+		WebConfigFile:      &webConfigFile,
+	}
+	path, ok := strings.CutPrefix(address, "unix://")
+	if activated || !ok {
+		return web.ListenAndServe(server, flags, slog.Default())
+	}
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	slog.Info("Listening on", "socket", path, "mode", socketMode)
+	return web.Serve(listener, server, flags, slog.Default())
+}
+
+// listenUnix listens on the unix socket at path, replacing a stale socket
+// left behind by a previous process
+func listenUnix(path string) (net.Listener, error) {
+	mode, err := parseSocketMode(socketMode)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set the mode of %s: %w", path, err)
+	}
+	return listener, nil
+}
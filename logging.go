@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+var (
+	logLevel  string
+	logFormat string
+	// logOutput is where log messages are written, the tui shows them in
+	// the dashboard instead
+	logOutput io.Writer = os.Stderr
+)
+
+// setupLogging configures the default slog logger from -log.level and
+// -log.format
+func setupLogging() error {
+	logger, err := newLogger()
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// newLogger returns the logger of -log.level and -log.format
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid -log.level %q, must be debug, info, warn or error", logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch logFormat {
+	case "text":
+		handler = slog.NewTextHandler(logOutput, opts)
+	case "json":
+		handler = slog.NewJSONHandler(logOutput, opts)
+	default:
+		return nil, fmt.Errorf("invalid -log.format %q, must be text or json", logFormat)
+	}
+	return slog.New(handler), nil
+}
@@ -2,238 +2,609 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/entitlement"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 )
 
 const (
-	DefaultTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
-	DefaultApiURL   = "https://api.access.redhat.com/management/v1/subscriptions"
+	DefaultTokenURL = rhsm.DefaultTokenURL
+	DefaultApiURL   = rhsm.DefaultAPIURL
 )
 
 var (
-	exportToFile          string
-	importUrl             string
-	importUsername        string
-	importPassword        string
-	SubscriptionInfoGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "redhat_subscription_info",
-		Help: "Contains info about subscriptions as labels.",
+	exportToFile         string
+	exportTextfile       string
+	importFile           string
+	importUsername       string
+	importPassword       string
+	metricsCompat        string
+	infoLabelList        string
+	unlimitedQuantity    float64
+	emptyResponse        string
+	fetchConcurrency     int
+	pageSize             int
+	fetchInterval        intervalFlag
+	fetchJitter          float64
+	fetchConditional     bool
+	staleCycles          int
+	listenAddress        string
+	fiscalYearStart      int
+	attributesMetric     bool
+	renewalWindow        time.Duration
+	telemetryPath        string
+	webConfigFile        string
+	noCostSKUList        string
+	noCostSKUs           []string
+	includeNoCost        bool
+	capacityPoolTypeList string
+	capacityPoolTypes    []string
+	countingModeList     string
+	countingModes        map[string]string
+	productFamilyList    string
+	productFamilies      map[string]string
+	nowOverride          string
+	fixedNow             time.Time
+	FetchErrorsCounter   = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_subscription_fetch_errors_total",
+		Help: "Total number of failed subscription fetch cycles.",
+	})
+	EmptyResponseCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_subscription_empty_response_total",
+		Help: "Total number of suspicious empty responses after a non-empty one.",
+	})
+	RemoteWriteErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_remote_write_errors_total",
+		Help: "Total number of failed pushes to the remote_write endpoint.",
+	})
+	WatchdogRestartsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_watchdog_restarts_total",
+		Help: "Total number of fetch loop restarts by the watchdog.",
+	})
+	RateLimitedCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_subscription_rate_limited_total",
+		Help: "Total number of API responses with HTTP 429 Too Many Requests.",
+	})
+	NotModifiedCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_subscription_fetch_not_modified_total",
+		Help: "Total number of subscription pages the API reported unchanged, reused from the last fetch.",
+	})
+	AccountFetchErrorsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_subscription_account_fetch_errors_total",
+		Help: "Total number of failed fetches per account when multiple accounts are configured.",
 	},
-		[]string{"contractNumber", "subscriptionNumber", "subscriptionName", "status", "sku"})
-	SubscriptionQuantityGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "redhat_subscription_quantity",
-		Help: "Total number of subscriptions.",
-	},
-		[]string{"subscriptionNumber"})
-	SubscriptionStartGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "redhat_subscription_start",
-		Help: "Unix timestamp of subscription start date.",
-	},
-		[]string{"subscriptionNumber"})
-	SubscriptionEndGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "redhat_subscription_end",
-		Help: "Unix timestamp of subscription end date.",
-	},
-		[]string{"subscriptionNumber"})
+		[]string{"account"})
 )
 
-// Subscription represents one subscription entry
-type Subscription struct {
-	ContractNumber     string    `json:"contractNumber"`
-	EndDate            time.Time `json:"endDate"`
-	Quantity           string    `json:"quantity"`
-	SKU                string    `json:"sku"`
-	StartDate          time.Time `json:"startDate"`
-	Status             string    `json:"status"`
-	SubscriptionName   string    `json:"subscriptionName"`
-	SubscriptionNumber string    `json:"subscriptionNumber"`
-	Pools              []struct {
-		Consumed int    `json:"consumed"`
-		ID       string `json:"id"`
-		Quantity int    `json:"quantity"`
-		Type     string `json:"type"`
-	} `json:"pools"`
+// defaultSubscriptionMetrics are the metrics of the fetch loop, created by
+// the first applySettings because the info labels can't change later
+var defaultSubscriptionMetrics *collector.Metrics
+
+// collectorOptions returns the collector options of the current settings.
+// accounts always get aggregates, nil means -accounts.
+func collectorOptions(accounts []string) collector.Options {
+	if accounts == nil {
+		accounts = splitList(accountList)
+		if len(accounts) == 0 && discoveryURL == "" {
+			accounts = []string{""}
+		}
+	}
+	return collector.Options{
+		Compat:            metricsCompat,
+		InfoLabels:        infoLabels(),
+		StaleCycles:       staleCycles,
+		FiscalYearStart:   fiscalYearStart,
+		RenewalWindow:     renewalWindow,
+		NoCostSKUs:        noCostSKUs,
+		IncludeNoCost:     includeNoCost,
+		UnlimitedQuantity: unlimitedQuantity,
+		CapacityPoolTypes: capacityPoolTypes,
+		CountingModes:     countingModes,
+		ProductFamilies:   productFamilies,
+		Accounts:          accounts,
+		SCAAccounts:       scaAccounts(),
+		SCAConsumption:    scaConsumption,
+		Attributes:        attributesMetric,
+		Now:               currentTime,
+	}
 }
 
-// Response structure for the API
-type subscriptionsResponse struct {
-	Body       []Subscription `json:"body"`
-	Pagination struct {
-		Count  int `json:"count"`
-		Limit  int `json:"limit"`
-		Offset int `json:"offset"`
-	} `json:"pagination"`
+// responseCaches keep the pages of the last fetch per account for
+// -fetch.conditional, the accounts request the same URLs with their own token
+var (
+	responseCaches   = map[string]*rhsm.ResponseCache{}
+	responseCachesMu sync.Mutex
+)
+
+// responseCache returns the response cache of the account
+func responseCache(account string) *rhsm.ResponseCache {
+	responseCachesMu.Lock()
+	defer responseCachesMu.Unlock()
+	cache, ok := responseCaches[account]
+	if !ok {
+		cache = rhsm.NewResponseCache()
+		responseCaches[account] = cache
+	}
+	return cache
 }
 
-// errorResponse is the error payload
-type errorResponse struct {
-	Error struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-	} `json:"error"`
+// apiClient returns an API client fetching from url for the account with the
+// current settings
+func apiClient(client *http.Client, url, account string) *rhsm.Client {
+	c := &rhsm.Client{
+		HTTPClient:  client,
+		URL:         url,
+		PageSize:    pageSize,
+		Concurrency: fetchConcurrency,
+		Retry:       retryPolicy(),
+		Lenient:     fetchLenient,
+	}
+	c.OnPaginationMismatch = countPaginationMismatch
+	if fetchLenient {
+		c.OnAnomaly = countAnomaly
+	}
+	if fetchConditional {
+		c.Cache = responseCache(account)
+		c.OnNotModified = NotModifiedCounter.Inc
+	}
+	return c
 }
 
-// FetchAllSubscriptions fetches all subscriptions
-func FetchAllSubscriptions(client *http.Client, baseURL string) ([]Subscription, error) {
-	limit := 50
-	offset := 0
-	var allSubs []Subscription
+// FetchImportedSubscriptions fetches subscriptions from a remote json file,
+// optionally gzip compressed. s3:// and gs:// URLs are downloaded from the object storage
+func FetchImportedSubscriptions(ctx context.Context, client *http.Client, jsonUrl string, opts importOptions) ([]rhsm.Subscription, error) {
+	u, err := url.Parse(jsonUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid import URL: %w", err)
+	}
 
-	for {
-		url := fmt.Sprintf("%s?limit=%d&offset=%d", baseURL, limit, offset)
-		resp, err := client.Get(url)
+	body, err := fetchImportBody(ctx, client, u, opts)
+	if err != nil {
+		return nil, err
+	}
+	if importVerifier != nil {
+		sigURL := *u
+		sigURL.Path += signatureSuffix()
+		sigURL.RawPath = ""
+		signature, err := fetchImportBody(ctx, client, &sigURL, opts)
 		if err != nil {
-			return nil, fmt.Errorf("request failed: %w", err)
+			return nil, signatureMissing(u.Redacted(), err)
 		}
-
-		// Check HTTP status code
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			resp.Body.Close()
-			return nil, fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+		if err := verifyImport(u.Redacted(), body, signature); err != nil {
+			return nil, err
 		}
+	}
+
+	subs, err := decodeExport(body)
+	if err != nil {
+		return nil, fmt.Errorf("decode failed: %w", err)
+	}
 
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
+	return subs, nil
+}
+
+// fetchImportBody downloads an import from S3, GCS or a web server
+func fetchImportBody(ctx context.Context, client *http.Client, u *url.URL, opts importOptions) ([]byte, error) {
+	if u.Scheme == "s3" || u.Scheme == "gs" {
+		return fetchObject(ctx, client, u)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := opts.apply(req); err != nil {
+		return nil, err
+	}
+	return doWithRetry(client, req)
+}
+
+// ReadImportFile reads subscriptions from a json file as written by -export,
+// optionally gzip compressed
+func ReadImportFile(path string) ([]rhsm.Subscription, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if importVerifier != nil {
+		signature, err := os.ReadFile(path + signatureSuffix())
 		if err != nil {
-			return nil, fmt.Errorf("failed to read response body: %w", err)
+			return nil, signatureMissing(path, err)
 		}
-
-		var errResp errorResponse
-		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error.Message != "" {
-			return nil, fmt.Errorf("API error %d: %s", errResp.Error.Code, errResp.Error.Message)
+		if err := verifyImport(path, data, signature); err != nil {
+			return nil, err
 		}
+	}
 
-		var result subscriptionsResponse
-		if err := json.Unmarshal(bodyBytes, &result); err != nil {
-			return nil, fmt.Errorf("decode failed: %w", err)
-		}
+	subs, err := decodeExport(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode of %s failed: %w", path, err)
+	}
+
+	return subs, nil
+}
 
-		allSubs = append(allSubs, result.Body...)
+// metricsLoop runs the fetch loop in the background and restarts it when the
+// watchdog finds it stuck or the config is reloaded. The settings are read on
+// every (re)start.
+func metricsLoop(ctx context.Context, done chan error) {
+	lastSuccess.CompareAndSwap(0, time.Now().UnixNano())
+	go func() {
+		// resumeAt delays the first fetch of a restarted loop
+		var resumeAt time.Time
+		for {
+			loopCtx, cancel := context.WithCancel(ctx)
+			result := make(chan error, 1)
+			interval := time.Duration(fetchInterval)
+			accounts, err := configuredAccounts()
+			if err != nil {
+				cancel()
+				done <- err
+				return
+			}
+			tokenUrl := tokenURL()
+			apiUrl := apiURL()
+			lastHeartbeat.Store(time.Now().UnixNano())
+			go func(resumeAt time.Time) {
+				if wait := time.Until(resumeAt); wait > 0 {
+					lastHeartbeat.Store(resumeAt.UnixNano())
+					select {
+					case <-time.After(wait):
+					case <-loopCtx.Done():
+						result <- loopCtx.Err()
+						return
+					}
+				}
+				result <- fetchLoop(loopCtx, accounts, tokenUrl, apiUrl, oneShotExport(), importSources, currentImportOptions(), interval)
+			}(resumeAt)
+			resumeAt = time.Time{}
 
-		if len(result.Body) < limit {
-			break
+			select {
+			case err := <-result:
+				cancel()
+				done <- err
+				return
+			case <-watchdog(loopCtx, watchdogTimeout(interval)):
+				// The stuck loop is abandoned, cancelling its context tears down its client
+				slog.Warn("Fetch loop made no progress, restarting it", "timeout", watchdogTimeout(interval))
+				WatchdogRestartsCounter.Inc()
+				cancel()
+			case req := <-reloadRequests:
+				cancel()
+				select {
+				case <-result:
+				case <-time.After(30 * time.Second):
+					slog.Warn("Fetch loop didn't stop in time, abandoning it")
+				}
+				err := req.apply()
+				if err != nil {
+					// Nothing changed, so the next fetch is due as before
+					resumeAt = time.Unix(0, lastFetchStart.Load()).Add(interval)
+				}
+				req.resp <- err
+			}
 		}
-		offset += limit
+	}()
+}
+
+// oneShotExport returns the json export file when the process should exit
+// after the first fetch, scheduled exports are written by the scheduler
+func oneShotExport() string {
+	if !exportOnce() {
+		return ""
 	}
+	return exportToFile
+}
+
+// lastSubscriptions is the snapshot of the last update and lastDataTime the
+// time of the fetch it is from, guarded by updateMu. lastFetched are the same
+// subscriptions as fetched, before they were sanitized, filtered and
+// relabeled, a failing account or import source keeps them.
+var (
+	lastSubscriptions []rhsm.Subscription
+	lastFetched       []rhsm.Subscription
+	lastDataTime      time.Time
+)
+
+// updateMu serializes metric updates, so an abandoned fetch loop can't race
+// with its replacement
+var updateMu sync.Mutex
 
-	return allSubs, nil
+// prepareSubscriptions sanitizes, filters and relabels fetched subscriptions
+// in place before they are exported
+func prepareSubscriptions(subs []rhsm.Subscription) []rhsm.Subscription {
+	sanitizeSubscriptions(subs)
+	subs = filterSubscriptions(subs)
+	relabelSubscriptions(subs)
+	return subs
 }
 
-func metricsLoop(token, tokenUrl, apiUrl, export, jsonUrl, jsonUser, jsonPass string, interval int64, done chan error) {
+// fetchLoop fetches subscriptions every interval until ctx is cancelled. In
+// the one-shot export modes it returns after the first successful fetch.
+func fetchLoop(ctx context.Context, accounts []account, tokenUrl, apiUrl, export string, imports []importSource, importOpts importOptions, interval time.Duration) error {
 	var client *http.Client
+	imported := len(imports) > 0 || importFile != ""
 
-	if jsonUrl == "" {
-		ctx := context.Background()
+	transport, err := newAPITransport()
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	tokenTransport, err := newTokenTransport()
+	if err != nil {
+		return err
+	}
+	defer tokenTransport.CloseIdleConnections()
+
+	var tokenSources []*failoverTokenSource
+	if !imported && fetchSource == "rhsm" {
+		for i, a := range accounts {
+			// The token source stops refreshing once ctx is cancelled
+			ts := newFailoverTokenSource(withTokenTransport(ctx, tokenTransport), a, append([]string{tokenUrl}, tokenURLFallbacks...))
+			tokenSources = append(tokenSources, ts)
 
-		conf := &oauth2.Config{
-			ClientID: "rhsm-api",
-			Endpoint: oauth2.Endpoint{
-				TokenURL: tokenUrl,
-			},
+			// Create an HTTP client that injects the Bearer token automatically
+			accounts[i].client = &http.Client{Transport: &oauth2.Transport{Source: ts, Base: transport}, Timeout: httpTimeout}
+		}
+	}
+	if imported || fetchSource != "rhsm" {
+		client = &http.Client{Transport: transport, Timeout: httpTimeout}
+	}
+	var leaderClient *http.Client
+	if leaderElection {
+		if leaderClient, err = newLeaderClient(); err != nil {
+			return err
 		}
+	}
+	// The permissions of the optional collectors are probed once this
+	// replica fetches from the API, followers don't call it
+	probed := false
 
-		ts := conf.TokenSource(ctx, &oauth2.Token{RefreshToken: token})
+	for {
+		cycleStart := time.Now()
+		lastHeartbeat.Store(cycleStart.UnixNano())
+		lastFetchStart.Store(cycleStart.UnixNano())
 
-		// Create an HTTP client that injects the Bearer token automatically
-		client = oauth2.NewClient(ctx, ts)
-	} else {
-		client = &http.Client{}
-	}
+		var subs []rhsm.Subscription
+		// updated is set once the metrics were updated from subs
+		updated := false
+		follower := following()
+		cycleCtx, cycleSpan := tracer.Start(ctx, "fetch cycle")
+		for _, ts := range tokenSources {
+			ts.setCycle(cycleCtx)
+		}
+		err := runCollector("subscriptions", func() error {
+			fetchCtx, cancel := withFetchTimeout(cycleCtx)
+			defer cancel()
 
-	go func() {
-		for {
-			var subs []Subscription
 			var err error
-
-			if jsonUrl == "" {
-				subs, err = FetchAllSubscriptions(client, apiUrl)
-				if err != nil {
-					log.Printf("Error fetching subscriptions: %v", err)
-					time.Sleep(time.Duration(interval) * time.Second)
-					continue
+			switch {
+			case follower:
+				subs, err = fetchFromLeader(fetchCtx, leaderClient)
+			case importFile != "":
+				subs, err = ReadImportFile(importFile)
+			case len(imports) > 0:
+				subs, err = fetchImports(fetchCtx, client, imports, importOpts)
+			case fetchSource == "candlepin":
+				subs, err = candlepinClient(client).FetchAll(fetchCtx)
+			case fetchSource == "entitlement-certs":
+				subs, err = entitlement.ReadDir(entitlementDir, currentTime())
+			default:
+				subs, err = fetchAccounts(fetchCtx, accounts, apiUrl)
+			}
+			if err != nil {
+				return err
+			}
+			// The rules change the subscriptions in place, so the fetched
+			// ones are kept apart
+			fetchedSubs := subs
+			if !follower {
+				fetchedSubs = slices.Clone(subs)
+				subs = prepareSubscriptions(subs)
+			}
+			if scaDetect && !imported && !follower && fetchSource != "entitlement-certs" {
+				detectSCA(fetchCtx, accounts, client, apiUrl)
+			}
+			if export == "" {
+				updateMu.Lock()
+				defer updateMu.Unlock()
+				if ctx.Err() != nil {
+					return ctx.Err()
 				}
-			} else {
-				req, err := http.NewRequest("GET", jsonUrl, nil)
-				if err != nil {
-					log.Printf("Error creating request: %v", err)
-					time.Sleep(time.Duration(interval) * time.Second)
-					continue
+				if len(subs) == 0 && len(lastSubscriptions) > 0 && emptyResponse == "keep" {
+					slog.Warn("Received no subscriptions, keeping previous data", "previous", len(lastSubscriptions))
+					EmptyResponseCounter.Inc()
+					return nil
 				}
-
-				if jsonUser != "" && jsonPass != "" {
-					req.SetBasicAuth(jsonUser, jsonPass)
+				_, updateSpan := tracer.Start(cycleCtx, "update metrics", trace.WithAttributes(attribute.Int("subscriptions", len(subs))))
+				defer updateSpan.End()
+				defaultSubscriptionMetrics.SetOptions(collectorOptions(nil))
+				defaultSubscriptionMetrics.Update(subs)
+				runSelfCheck(subs)
+				writeAudit(recordChanges(lastSubscriptions, subs, !ready.Load()), cycleStart)
+				lastSubscriptions = subs
+				lastFetched = fetchedSubs
+				lastDataTime = cycleStart
+				dataTimestampMs.Store(cycleStart.UnixMilli())
+				DataTimestampGauge.Set(float64(cycleStart.Unix()))
+				ready.Store(true)
+				fetched.Store(true)
+				lastSuccess.Store(time.Now().UnixNano())
+				if stateFile != "" {
+					if err := saveState(stateFile, subs, fetchedSubs, cycleStart); err != nil {
+						slog.Error("Error writing state file", "file", stateFile, "err", err)
+					}
 				}
-				resp, err := client.Do(req)
-				if err != nil {
-					log.Printf("Error making request: %v", err)
-					time.Sleep(time.Duration(interval) * time.Second)
-					continue
+				if history != nil {
+					if err := history.record(subs, cycleStart); err != nil {
+						slog.Error("Error recording fetch in history database", "file", historyDB, "err", err)
+						HistoryErrorsCounter.Inc()
+					} else if err := updateTrends(history, subs, cycleStart); err != nil {
+						slog.Error("Error reading trends from history database", "file", historyDB, "err", err)
+						HistoryErrorsCounter.Inc()
+					}
 				}
+				slog.Info("Fetched subscriptions", "count", len(subs), "duration", time.Since(cycleStart))
+				updated = true
+			}
+			return nil
+		})
+		if updated {
+			// A slow push backend must not hold updateMu, which /metrics needs
+			pushMetrics(cycleCtx, &http.Client{Transport: transport.Transport, Timeout: httpTimeout}, subs)
+		}
+		endSpan(cycleSpan, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Keep the last good metrics and retry on the next interval, or
+			// the circuit interval once it is open
+			open, wasOpen := circuit.failure()
+			if wasOpen {
+				slog.Debug("Error fetching subscriptions", "err", err, "duration", time.Since(cycleStart))
+			} else {
+				slog.Error("Error fetching subscriptions", "err", err, "duration", time.Since(cycleStart))
+			}
+			FetchErrorsCounter.Inc()
+			if fetchOnce {
+				return err
+			}
+			if err := waitNextFetch(ctx, retryInterval(interval, open), cycleStart, err); err != nil {
+				return err
+			}
+			continue
+		}
+		circuit.success()
 
-				if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-					resp.Body.Close()
-					log.Printf("HTTP error: %d %s", resp.StatusCode, resp.Status)
-					time.Sleep(time.Duration(interval) * time.Second)
-					continue
-				}
+		if fetchOnce {
+			return nil
+		}
 
-				body, err := io.ReadAll(resp.Body)
-				resp.Body.Close()
-				if err != nil {
-					log.Printf("Error reading response body: %v", err)
-					time.Sleep(time.Duration(interval) * time.Second)
-					continue
-				}
-				if err := json.Unmarshal(body, &subs); err != nil {
-					log.Printf("Error unmarshaling JSON: %v", err)
-					time.Sleep(time.Duration(interval) * time.Second)
-					continue
-				}
+		if export == "" && !imported && !follower && fetchSource == "rhsm" {
+			if !probed {
+				// Permissions are probed with the first account
+				probeOptionalCollectors(ctx, accounts[0].client, apiUrl)
+				probed = true
 			}
+			runOptionalCollectors(cycleCtx, accounts[0], apiUrl)
+		}
 
-			if export != "" {
-				data, err := json.MarshalIndent(subs, "", "  ")
-				if err != nil {
-					done <- err
-					return
-				}
-				err = os.WriteFile(export, data, 0644)
-				if err != nil {
-					done <- err
-					return
-				}
-				done <- nil
-				return
-			}
+		if export != "" {
+			return writeJSONExport(export, subs)
+		}
 
-			for _, s := range subs {
-				quantity, err := strconv.ParseFloat(s.Quantity, 64)
-				if err != nil {
-					log.Printf("Error parsing quantity for subscription %s: %v", s.SubscriptionNumber, err)
-					continue
-				}
-				SubscriptionInfoGauge.With(prometheus.Labels{"contractNumber": s.ContractNumber, "subscriptionNumber": s.SubscriptionNumber, "subscriptionName": s.SubscriptionName, "status": s.Status, "sku": s.SKU}).Set(1)
-				SubscriptionQuantityGauge.With(prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber}).Set(quantity)
-				SubscriptionStartGauge.With(prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber}).Set(float64(s.StartDate.Unix()))
-				SubscriptionEndGauge.With(prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber}).Set(float64(s.EndDate.Unix()))
-			}
+		if exportTextfile != "" && exportOnce() {
+			return writeTextfileExport(exportTextfile)
+		}
 
-			time.Sleep(time.Duration(interval) * time.Second)
+		if err := waitNextFetch(ctx, interval, cycleStart, nil); err != nil {
+			return err
 		}
-	}()
+	}
+}
+
+// waitNextFetch sleeps until the next fetch according to -fetch.schedule or
+// the interval, plus a random delay of up to -fetch.jitter percent of the
+// interval. Failed fetches are retried after the interval. The heartbeat
+// is moved to the wake-up time, so the watchdog doesn't mistake a long wait
+// for a stuck loop. A scrape of expired metrics, see -cache.ttl, ends the
+// wait early.
+func waitNextFetch(ctx context.Context, interval time.Duration, cycleStart time.Time, fetchErr error) error {
+	wait := interval
+	if fetchSchedule != nil && fetchErr == nil {
+		wait = time.Until(fetchSchedule.Next(time.Now()))
+	}
+	if fetchJitter > 0 {
+		wait += time.Duration(rand.Float64() * fetchJitter / 100 * float64(interval))
+	}
+	next := time.Now().Add(wait)
+	tasks.track("fetch", fetchScheduleSpec, next, cycleStart, fetchErr)
+	lastHeartbeat.Store(next.UnixNano())
+	return sleepUntilRefresh(ctx, wait)
+}
+
+// currentTime returns the time used for derived metrics, which is fixed via
+// -now-override to verify alert behavior for future dates
+func currentTime() time.Time {
+	if !fixedNow.IsZero() {
+		return fixedNow
+	}
+	return time.Now()
+}
+
+// parseNowOverride parses the RFC3339 time of -now-override, the zero time
+// if it is empty
+func parseNowOverride(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseCountingModes parses a comma-separated list of SKU=mode pairs
+func parseCountingModes(s string) (map[string]string, error) {
+	modes := map[string]string{}
+	for _, pair := range splitList(s) {
+		sku, mode, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid counting mode %q, expected SKU=mode", pair)
+		}
+		if _, ok := collector.CountingModeDivisors[mode]; !ok {
+			return nil, fmt.Errorf("unknown counting mode %q for SKU %s, must be instance or socket-pair", mode, sku)
+		}
+		modes[strings.TrimSpace(sku)] = mode
+	}
+	return modes, nil
+}
+
+// parseProductFamilies parses a comma-separated list of SKU=family pairs
+func parseProductFamilies(s string) (map[string]string, error) {
+	families := map[string]string{}
+	for _, pair := range splitList(s) {
+		sku, family, ok := strings.Cut(pair, "=")
+		sku, family = strings.TrimSpace(sku), strings.TrimSpace(family)
+		if !ok || sku == "" || family == "" {
+			return nil, fmt.Errorf("invalid product family %q, expected SKU=family", pair)
+		}
+		families[sku] = family
+	}
+	return families, nil
+}
+
+// splitList splits a comma-separated list and drops empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
 }
 
 func getEnv(key, fallback string) string {
@@ -243,6 +614,24 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getSecretEnv returns the value of key or, following the *_FILE convention
+// for mounted secrets, the trimmed content of the file given in key_FILE
+func getSecretEnv(key string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("Error reading secret file", "var", key+"_FILE", "err", err)
+			return ""
+		}
+		watchSecretFile(key, path)
+		return strings.TrimSpace(string(data))
+	}
+	return ""
+}
+
 func getEnvInt(key string, fallback int64) int64 {
 	if val := os.Getenv(key); val != "" {
 		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
@@ -252,33 +641,787 @@ func getEnvInt(key string, fallback int64) int64 {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// minFetchInterval is the shortest -fetch.interval accepted, to not hammer the API
+const minFetchInterval = 10 * time.Second
+
+// intervalFlag is a duration flag that also accepts a plain number of
+// seconds, the format RH_FETCH_INTERVAL used before it became a duration
+type intervalFlag time.Duration
+
+func (d intervalFlag) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *intervalFlag) Set(s string) error {
+	v, err := parseInterval(s)
+	if err != nil {
+		return err
+	}
+	*d = intervalFlag(v)
+	return nil
+}
+
+// parseInterval parses a duration like "5m" or a number of seconds
+func parseInterval(s string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q, expected a duration like 5m or a number of seconds", s)
+	}
+	return d, nil
+}
+
+func getEnvInterval(key string, fallback time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := parseInterval(val); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
 func init() {
+	configFile = configFileFromArgs(os.Args[1:])
+	if configFile != "" {
+		if err := loadConfigFile(configFile); err != nil {
+			slog.Error("Error loading config file", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	flag.BoolVar(&showVersion, "version", false, "Print version information and exit")
+	flag.StringVar(&configFile, "config", configFile, "Path to a YAML config file, flags and env vars take precedence")
 	flag.StringVar(&exportToFile, "export", os.Getenv("RH_EXPORT_FILE"), "Export json to given file")
-	flag.StringVar(&importUrl, "import-url", os.Getenv("RH_IMPORT_URL"), "Import data from remote json file")
+	flag.BoolVar(&exportGzip, "export-gzip", getEnv("RH_EXPORT_GZIP", "") == "true", "Compress the -export json file with gzip, e.g. for a file named subs.json.gz")
+	exportEncryptFlag.defaults = getEnv("RH_EXPORT_ENCRYPT", "")
+	flag.Var(&exportEncryptFlag, "export-encrypt", "Encrypt the -export json file to this age public key or file with age or GPG public keys, repeatable")
+	flag.StringVar(&exportTextfile, "export-textfile", os.Getenv("RH_EXPORT_TEXTFILE"), "Write metrics in Prometheus text format to given file")
+	flag.StringVar(&exportFormat, "export-format", getEnv("RH_EXPORT_FORMAT", "prometheus"), "Format of the -export-textfile metrics: prometheus or influx for the InfluxDB line protocol")
+	flag.StringVar(&fetchSource, "source", getEnv("RH_SOURCE", "rhsm"), "Where to fetch subscriptions from: rhsm (the Red Hat API), candlepin (e.g. Satellite) or entitlement-certs (the certificates of this host)")
+	flag.StringVar(&candlepinURL, "candlepin.url", getEnv("RH_CANDLEPIN_URL", ""), "Base URL of the Candlepin API, e.g. https://satellite.example.com/rhsm")
+	flag.StringVar(&candlepinOwner, "candlepin.owner", getEnv("RH_CANDLEPIN_OWNER", ""), "Candlepin owner key (Satellite organization label) whose pools are exported")
+	flag.StringVar(&candlepinUsername, "candlepin.username", getEnv("RH_CANDLEPIN_USERNAME", ""), "Username for basic auth against -candlepin.url")
+	flag.StringVar(&candlepinPassword, "candlepin.password", getSecretEnv("RH_CANDLEPIN_PASSWORD"), "Password for basic auth against -candlepin.url")
+	flag.StringVar(&entitlementDir, "entitlement.dir", getEnv("RH_ENTITLEMENT_DIR", entitlement.DefaultDir), "Directory of the entitlement certificates read with -source entitlement-certs")
+	importURLs.defaults = os.Getenv("RH_IMPORT_URL")
+	flag.Var(&importURLs, "import-url", "Import data from remote json file, repeatable to merge several files given as name=url")
+	flag.StringVar(&importFile, "import-file", os.Getenv("RH_IMPORT_FILE"), "Import data from a local json file, e.g. written by -export")
 	flag.StringVar(&importUsername, "import-username", os.Getenv("RH_IMPORT_USERNAME"), "Username for -import-url")
-	flag.StringVar(&importPassword, "import-password", os.Getenv("RH_IMPORT_PASSWORD"), "Password for -import-url")
+	flag.StringVar(&importPassword, "import-password", getSecretEnv("RH_IMPORT_PASSWORD"), "Password for -import-url")
+	flag.StringVar(&importBearerToken, "import-bearer-token", os.Getenv("RH_IMPORT_BEARER_TOKEN"), "Bearer token for -import-url")
+	importHeadersFlag.defaults = os.Getenv("RH_IMPORT_HEADERS")
+	flag.Var(&importHeadersFlag, "import-header", "Header \"Name: value\" sent with the -import-url requests, e.g. an API key, repeatable")
+	flag.StringVar(&importBearerTokenFile, "import-bearer-token-file", getEnv("RH_IMPORT_BEARER_TOKEN_FILE", ""), "File with the bearer token for -import-url, re-read for every request")
+	flag.StringVar(&importSignatureKey, "import-signature-key", getEnv("RH_IMPORT_SIGNATURE_KEY", ""), "Public key file (minisign, GPG or PEM) the imports must be signed with, the detached signature is read next to the import")
+	flag.StringVar(&importSignatureSuffix, "import-signature-suffix", getEnv("RH_IMPORT_SIGNATURE_SUFFIX", ""), "Suffix of the detached signature of the imports, default .minisig for minisign keys and .sig otherwise")
+	flag.StringVar(&metricsCompat, "metrics.compat", getEnv("RH_METRICS_COMPAT", "legacy"), "Metric names to expose: legacy, both or new")
+	flag.StringVar(&relabelConfig, "relabel", getEnv("RH_RELABEL", ""), "JSON list of relabeling rules applied to the subscriptions before export, usually set with the relabel key of the config file")
+	staticLabelsFlag.defaults = getEnv("RH_LABELS", "")
+	flag.Var(&staticLabelsFlag, "labels", "Label name=value added to every exported series, repeatable")
+	flag.Float64Var(&unlimitedQuantity, "quantity.unlimited", getEnvFloat("RH_QUANTITY_UNLIMITED", -1), "Quantity exported for subscriptions with an Unlimited quantity, e.g. -1 or +Inf")
+	flag.StringVar(&infoLabelList, "metrics.info-labels", getEnv("RH_METRICS_INFO_LABELS", ""), "Comma-separated subscription fields exported as labels of redhat_subscription_info, default account,contractNumber,subscriptionName,status,sku")
+	flag.IntVar(&sanitizeMaxLength, "sanitize.max-length", int(getEnvInt("RH_SANITIZE_MAX_LENGTH", 0)), "Truncate label values of the subscriptions, e.g. long names, to this many characters. 0 keeps them")
+	flag.BoolVar(&anonymizeLabels, "anonymize-labels", getEnv("RH_ANONYMIZE_LABELS", "") == "true", "Replace the contractNumber and subscriptionNumber label values with stable hashes, e.g. for external observability platforms. The JSON API, the subscriptions page and the exports keep the real numbers")
+	flag.StringVar(&anonymizeSalt, "anonymize-labels.salt", getSecretEnv("RH_ANONYMIZE_SALT"), "Secret key of the -anonymize-labels hashes")
+	flag.BoolVar(&sampleTimestamps, "metrics.timestamps", getEnv("RH_METRICS_TIMESTAMPS", "") == "true", "Set the time of the fetch the subscriptions are from as the timestamp of their samples on /metrics")
+	flag.BoolVar(&attributesMetric, "metrics.attributes", getEnv("RH_METRICS_ATTRIBUTES", "") == "true", "Export the service level, usage and system purpose role of the subscriptions as redhat_subscription_attributes")
+	flag.IntVar(&circuitFailures, "circuit.failures", int(getEnvInt("RH_CIRCUIT_FAILURES", 0)), "Retry only every -circuit.interval after this many consecutive failed fetches, until one succeeds. 0 disables the circuit breaker")
+	flag.DurationVar(&circuitInterval, "circuit.interval", getEnvDuration("RH_CIRCUIT_INTERVAL", time.Hour), "Time between the fetches while the circuit is open")
+	flag.IntVar(&retryMaxAttempts, "retry.max-attempts", int(getEnvInt("RH_RETRY_MAX_ATTEMPTS", 5)), "Maximum attempts per API request")
+	flag.DurationVar(&retryBackoff, "retry.backoff", getEnvDuration("RH_RETRY_BACKOFF", time.Second), "Initial backoff between retries, doubled on each attempt")
+	flag.DurationVar(&retryMaxBackoff, "retry.max-backoff", getEnvDuration("RH_RETRY_MAX_BACKOFF", 30*time.Second), "Maximum backoff between retries")
+	flag.DurationVar(&retryMaxRetryAfter, "retry.max-retry-after", getEnvDuration("RH_RETRY_MAX_RETRY_AFTER", 5*time.Minute), "Maximum wait for the Retry-After of a rate-limited (HTTP 429) API request")
+	flag.IntVar(&retryMaxRateLimited, "retry.max-rate-limited", int(getEnvInt("RH_RETRY_MAX_RATE_LIMITED", rhsm.DefaultMaxRateLimited)), "Consecutive rate-limited (HTTP 429) responses after which an API request fails")
+	flag.BoolVar(&scaDetect, "sca.detect", getEnv("RH_SCA_DETECT", "true") == "true", "Detect whether the organization is in Simple Content Access mode every fetch")
+	flag.StringVar(&scaConsumption, "sca.consumption", getEnv("RH_SCA_CONSUMPTION", "keep"), "How to export the consumption of pools in Simple Content Access mode: keep or drop")
+	flag.StringVar(&emptyResponse, "fetch.empty-response", getEnv("RH_EMPTY_RESPONSE", "keep"), "What to do when a fetch suddenly returns no subscriptions: keep or trust")
+	flag.IntVar(&fetchConcurrency, "fetch.concurrency", int(getEnvInt("RH_FETCH_CONCURRENCY", 4)), "Maximum number of pages fetched in parallel")
+	flag.BoolVar(&fetchConditional, "fetch.conditional", getEnv("RH_FETCH_CONDITIONAL", "true") == "true", "Request the pages with the ETag and Last-Modified of the last fetch and reuse unchanged pages")
+	flag.BoolVar(&fetchLenient, "fetch.lenient", getEnv("RH_FETCH_LENIENT", "") == "true", "Tolerate unknown fields and enum values, nulls and mismatching types in API responses instead of failing the fetch")
+	flag.IntVar(&pageSize, "fetch.page-size", int(getEnvInt("RH_PAGE_SIZE", 50)), "Number of subscriptions requested per API page")
+	flag.IntVar(&staleCycles, "metrics.stale-cycles", int(getEnvInt("RH_STALE_CYCLES", 3)), "Number of fetch cycles a vanished subscription is kept with stale=\"true\" before it is dropped")
+	flag.BoolVar(&pprofEnabled, "debug.pprof", getEnv("RH_DEBUG_PPROF", "") == "true", "Serve the Go runtime profiles of net/http/pprof under /debug/pprof/")
+	flag.StringVar(&pprofAddress, "debug.pprof-address", getEnv("RH_DEBUG_PPROF_ADDRESS", ""), "Serve the profiles of -debug.pprof on this separate address instead of -web.listen-address, e.g. localhost:6060")
+	flag.StringVar(&listenAddress, "web.listen-address", getEnv("RH_LISTEN_ADDRESS", ":2112"), "Address to listen on for HTTP requests, or unix:///path/to.sock for a unix socket")
+	flag.BoolVar(&systemdSocket, "web.systemd-socket", getEnv("RH_SYSTEMD_SOCKET", "") == "true", "Serve on the sockets passed by systemd socket activation instead of -web.listen-address")
+	flag.BoolVar(&leaderElection, "leader.election", getEnv("RH_LEADER_ELECTION", "") == "true", "Elect a leader among the replicas with a Kubernetes Lease, only the leader fetches from the API")
+	flag.StringVar(&leaderLeaseName, "leader.lease-name", getEnv("RH_LEADER_LEASE_NAME", "redhat-subscription-exporter"), "Name of the Lease of -leader.election")
+	flag.StringVar(&leaderNamespace, "leader.namespace", getEnv("RH_LEADER_NAMESPACE", ""), "Namespace of the Lease, defaults to the namespace of the pod")
+	flag.StringVar(&leaderIdentity, "leader.identity", getEnv("RH_LEADER_IDENTITY", ""), "Identity of this replica in the Lease, defaults to the hostname (the pod name)")
+	flag.DurationVar(&leaderLeaseDuration, "leader.lease-duration", getEnvDuration("RH_LEADER_LEASE_DURATION", 15*time.Second), "Time after which the followers take over the Lease of a leader that stopped renewing it")
+	flag.StringVar(&leaderAdvertiseURL, "leader.advertise-url", getEnv("RH_LEADER_ADVERTISE_URL", ""), "URL the followers read the subscriptions of this replica from while it is the leader, e.g. http://$(POD_IP):9111")
+	flag.StringVar(&leaderUsername, "leader.username", getEnv("RH_LEADER_USERNAME", ""), "Basic auth username the followers read from the leader with, for a -web.config.file requiring basic auth")
+	flag.StringVar(&leaderPassword, "leader.password", getSecretEnv("RH_LEADER_PASSWORD"), "Basic auth password of -leader.username")
+	flag.StringVar(&leaderCAFile, "leader.ca-file", getEnv("RH_LEADER_CA_FILE", ""), "CA certificate the followers verify the TLS certificate of the -leader.advertise-url with")
+	flag.BoolVar(&leaderInsecureSkipVerify, "leader.insecure-skip-verify", getEnv("RH_LEADER_INSECURE_SKIP_VERIFY", "") == "true", "Don't verify the TLS certificate of the -leader.advertise-url, e.g. a certificate without the pod IP")
+	flag.BoolVar(&disableExporterMetrics, "web.disable-exporter-metrics", getEnv("RH_WEB_DISABLE_EXPORTER_METRICS", "") == "true", "Don't export the go_* and process_* metrics of the exporter")
+	flag.BoolVar(&enableLifecycle, "web.enable-lifecycle", getEnv("RH_WEB_ENABLE_LIFECYCLE", "") == "true", "Shut the exporter down on POST /-/quit and reload the config on POST /-/reload")
+	flag.StringVar(&socketMode, "web.socket-mode", getEnv("RH_SOCKET_MODE", "0660"), "Octal file mode of the unix socket of -web.listen-address")
+	flag.StringVar(&telemetryPath, "web.telemetry-path", getEnv("RH_TELEMETRY_PATH", "/metrics"), "Path under which to expose metrics")
+	flag.IntVar(&fiscalYearStart, "metrics.fiscal-year-start", int(getEnvInt("RH_FISCAL_YEAR_START", 1)), "Month (1-12) in which the fiscal year starts, used for renewal quarters")
+	flag.DurationVar(&renewalWindow, "metrics.renewal-window", getEnvDuration("RH_RENEWAL_WINDOW", collector.DefaultRenewalWindow), "Time before the end date in which a subscription is in its renewal window")
+	flag.StringVar(&webConfigFile, "web.config.file", getEnv("RH_WEB_CONFIG_FILE", ""), "Path to a web configuration file enabling TLS or basic auth")
+	flag.StringVar(&noCostSKUList, "no-cost.skus", getEnv("RH_NO_COST_SKUS", strings.Join(collector.DefaultNoCostSKUs, ",")), "Comma-separated list of SKUs of no-cost subscriptions")
+	flag.BoolVar(&includeNoCost, "no-cost.include-in-aggregates", getEnv("RH_NO_COST_INCLUDE_IN_AGGREGATES", "") == "true", "Include no-cost subscriptions in aggregated metrics")
+	flag.StringVar(&capacityPoolTypeList, "capacity.pool-types", getEnv("RH_CAPACITY_POOL_TYPES", strings.Join(collector.DefaultCapacityPoolTypes, ",")), "Comma-separated list of pool types counted in redhat_capacity_total")
+	flag.StringVar(&productFamilyList, "product-families", getEnv("RH_PRODUCT_FAMILIES", ""), "Comma-separated list of SKU=family pairs overriding the product_family label, SKU may be a prefix ending in *")
+	flag.StringVar(&countingModeList, "counting.modes", getEnv("RH_COUNTING_MODES", ""), "Comma-separated list of SKU=mode pairs, mode is instance or socket-pair")
+	flag.StringVar(&remoteWriteURL, "remote-write.url", getEnv("RH_REMOTE_WRITE_URL", ""), "Push subscription metrics to this remote_write endpoint after each fetch")
+	flag.StringVar(&remoteWriteUsername, "remote-write.username", getEnv("RH_REMOTE_WRITE_USERNAME", ""), "Username for -remote-write.url")
+	flag.StringVar(&remoteWritePassword, "remote-write.password", getSecretEnv("RH_REMOTE_WRITE_PASSWORD"), "Password for -remote-write.url")
+	flag.StringVar(&remoteWriteBearerToken, "remote-write.bearer-token", getSecretEnv("RH_REMOTE_WRITE_BEARER_TOKEN"), "Bearer token for -remote-write.url")
+	flag.StringVar(&graphiteAddress, "graphite.address", getEnv("RH_GRAPHITE_ADDRESS", ""), "Push subscription metrics to this Graphite carbon plaintext endpoint (host:port) after each fetch")
+	flag.StringVar(&graphitePrefix, "graphite.prefix", getEnv("RH_GRAPHITE_PREFIX", ""), "Prefix of the metric paths pushed to -graphite.address, e.g. monitoring.redhat")
+	flag.BoolVar(&graphiteTags, "graphite.tags", getEnv("RH_GRAPHITE_TAGS", "") == "true", "Push the labels as Graphite tags instead of appending them to the metric path")
+	flag.StringVar(&cloudWatchNamespace, "cloudwatch.namespace", getEnv("RH_CLOUDWATCH_NAMESPACE", ""), "Publish aggregates of the subscriptions to this CloudWatch namespace after each fetch, e.g. RedHat/Subscriptions")
+	flag.StringVar(&zabbixServer, "zabbix.server", getEnv("RH_ZABBIX_SERVER", ""), "Push the items of the subscriptions to this Zabbix server or proxy (host:port) with the sender protocol after each fetch")
+	flag.StringVar(&zabbixHost, "zabbix.host", getEnv("RH_ZABBIX_HOST", ""), "Host in Zabbix with the trapper items of -zabbix.server")
+	flag.StringVar(&statsdAddress, "statsd.address", getEnv("RH_STATSD_ADDRESS", ""), "Send the subscription metrics as gauges to this StatsD or DogStatsD endpoint (host:port, UDP) after each fetch")
+	flag.StringVar(&statsdPrefix, "statsd.prefix", getEnv("RH_STATSD_PREFIX", ""), "Prefix of the metric names sent to -statsd.address")
+	flag.StringVar(&influxURL, "influx.url", getEnv("RH_INFLUX_URL", ""), "Write the subscription metrics to this InfluxDB v2 endpoint after each fetch, e.g. http://influxdb:8086")
+	flag.StringVar(&influxOrg, "influx.org", getEnv("RH_INFLUX_ORG", ""), "Organization of -influx.url")
+	flag.StringVar(&influxBucket, "influx.bucket", getEnv("RH_INFLUX_BUCKET", ""), "Bucket of -influx.url the metrics are written to")
+	flag.StringVar(&influxToken, "influx.token", getSecretEnv("RH_INFLUX_TOKEN"), "API token for -influx.url")
+	flag.StringVar(&statsdTagFormat, "statsd.tag-format", getEnv("RH_STATSD_TAG_FORMAT", "dogstatsd"), "How the labels are sent to -statsd.address: dogstatsd tags, or none to append their values to the metric name")
+	flag.BoolVar(&collectSystems, "collector.systems", getEnv("RH_COLLECTOR_SYSTEMS", "") == "true", "Fetch the registered systems and export their counts by type and entitlement status")
+	flag.BoolVar(&collectPools, "collector.pools", getEnv("RH_COLLECTOR_POOLS", "") == "true", "Fetch the pools of the account and export their quantity, consumed and exported entitlements")
+	flag.BoolVar(&collectSystemEntitlements, "collector.system-entitlements", getEnv("RH_COLLECTOR_SYSTEM_ENTITLEMENTS", "") == "true", "Fetch the entitlements of every registered system of organizations not in Simple Content Access mode, one request per system")
+	flag.BoolVar(&collectAllocations, "collector.allocations", getEnv("RH_COLLECTOR_ALLOCATIONS", "") == "true", "Fetch the subscription allocations (manifests) and export their entitlement counts and modification times")
+	flag.BoolVar(&collectErrata, "collector.errata", getEnv("RH_COLLECTOR_ERRATA", "") == "true", "Fetch the errata applicable to systems of the account and export their counts by type and severity")
+	flag.BoolVar(&otlpEnabled, "otlp.enabled", getEnv("RH_OTLP_ENABLED", "") == "true", "Export subscription metrics via OTLP, configured by the OTEL_EXPORTER_OTLP_* env vars")
+	flag.BoolVar(&otlpTraces, "otlp.traces", getEnv("RH_OTLP_TRACES", "") == "true", "Export spans of the fetch cycles, token refreshes and API requests via OTLP, configured by the OTEL_EXPORTER_OTLP_* env vars")
+	flag.Int64Var(&healthStaleMultiple, "health.stale-multiple", getEnvInt("RH_HEALTH_STALE_MULTIPLE", 0), "Report unhealthy on /healthz when the last successful fetch is older than this many fetch intervals, 0 disables it")
+	flag.Int64Var(&watchdogMultiple, "watchdog.multiple", getEnvInt("RH_WATCHDOG_MULTIPLE", 10), "Restart the fetch loop when it made no progress for this many fetch intervals, at least -fetch.timeout plus an interval, 0 disables the watchdog")
+	flag.StringVar(&environmentName, "environment", getEnv("RH_ENVIRONMENT", "production"), "Red Hat environment whose SSO and API are used unless RH_TOKEN_URL or RH_API_URL are set: production or stage")
+	flag.StringVar(&tokenURLFallbackList, "api.token-url-fallbacks", getEnv("RH_TOKEN_URL_FALLBACKS", ""), "Comma-separated list of token URLs tried when the token URL fails")
+	flag.StringVar(&apiClientID, "api.offline-client-id", getEnv("RH_OFFLINE_CLIENT_ID", rhsm.DefaultClientID), "OAuth client the offline tokens are issued for and exchanged with")
+	flag.StringVar(&apiScopesList, "api.scopes", getEnv("RH_API_SCOPES", ""), "Comma-separated OAuth scopes requested with the access tokens, none by default")
+	flag.DurationVar(&tokenRefreshBefore, "api.token-refresh-before", getEnvDuration("RH_TOKEN_REFRESH_BEFORE", time.Minute), "Refresh the access token this long before it expires, the cached token is used if all token URLs fail")
+	flag.StringVar(&tlsCAFile, "tls.ca-file", getEnv("RH_TLS_CA_FILE", ""), "CA bundle trusted in addition to the system roots for the token, API and import requests")
+	flag.StringVar(&tlsCertFile, "tls.cert-file", getEnv("RH_TLS_CERT_FILE", ""), "Client certificate for the token, API and import requests")
+	flag.StringVar(&tlsKeyFile, "tls.key-file", getEnv("RH_TLS_KEY_FILE", ""), "Key of -tls.cert-file")
+	flag.BoolVar(&tlsInsecureSkipVerify, "tls.insecure-skip-verify", getEnv("RH_TLS_INSECURE_SKIP_VERIFY", "") == "true", "Disable verification of the API server certificates")
+	flag.StringVar(&logLevel, "log.level", getEnv("RH_LOG_LEVEL", "info"), "Only log messages with the given severity or above: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log.format", getEnv("RH_LOG_FORMAT", "text"), "Output format of log messages: text or json")
+	flag.IntVar(&checkWarningDays, "check.warning-days", int(getEnvInt("RH_CHECK_WARNING_DAYS", 60)), "check warns about subscriptions ending within this many days, 0 disables it")
+	flag.IntVar(&checkCriticalDays, "check.critical-days", int(getEnvInt("RH_CHECK_CRITICAL_DAYS", 30)), "check is critical for subscriptions ending within this many days, 0 disables it")
+	flag.Float64Var(&checkWarningUsage, "check.warning-usage", getEnvFloat("RH_CHECK_WARNING_USAGE", 0), "check warns about subscriptions with at least this percentage of their entitlements consumed, 0 disables it")
+	flag.Float64Var(&checkCriticalUsage, "check.critical-usage", getEnvFloat("RH_CHECK_CRITICAL_USAGE", 0), "check is critical for subscriptions with at least this percentage of their entitlements consumed, 0 disables it")
+	flag.DurationVar(&tuiRefreshInterval, "tui.refresh-interval", getEnvDuration("RH_TUI_REFRESH_INTERVAL", time.Second), "How often the tui dashboard is redrawn")
+	flag.StringVar(&rulesFormat, "rules.format", getEnv("RH_RULES_FORMAT", "prometheus"), "Format of the rules command: prometheus for a rules file or prometheusrule for a PrometheusRule resource")
+	flag.StringVar(&rulesNamespace, "rules.namespace", getEnv("RH_RULES_NAMESPACE", ""), "Kubernetes namespace of the PrometheusRule printed by the rules command")
+	flag.StringVar(&rulesJob, "rules.job", getEnv("RH_RULES_JOB", "redhat-subscription-exporter"), "Prometheus job scraping the exporter, alerted on by the rules command when its targets are down")
+	flag.IntVar(&tuiRows, "tui.rows", int(getEnvInt("RH_TUI_ROWS", 10)), "Number of subscriptions and pools listed by the tui dashboard")
+	flag.StringVar(&mockServerAddress, "mock-server", getEnv("RH_MOCK_SERVER", ""), "Instead of exporting, serve a mock token endpoint and subscriptions API on this address for integration tests")
+	flag.StringVar(&mockDataset, "mock.dataset", getEnv("RH_MOCK_DATASET", "default"), "Dataset served by -mock-server: default, small, large, expiring, empty or a json file")
+	flag.DurationVar(&mockLatency, "mock.latency", getEnvDuration("RH_MOCK_LATENCY", 0), "Delay of every subscriptions response of -mock-server")
+	flag.Float64Var(&mockErrorRate, "mock.error-rate", getEnvFloat("RH_MOCK_ERROR_RATE", 0), "Fraction (0-1) of subscriptions requests answered with HTTP 503 by -mock-server")
+	flag.Float64Var(&mockRateLimitRate, "mock.rate-limit-rate", getEnvFloat("RH_MOCK_RATE_LIMIT_RATE", 0), "Fraction (0-1) of subscriptions requests answered with HTTP 429 by -mock-server")
+	flag.StringVar(&mockOfflineToken, "mock.offline-token", getSecretEnv("RH_MOCK_OFFLINE_TOKEN"), "Only accept this offline token or client secret at the -mock-server token endpoint, any is accepted by default")
+	flag.BoolVar(&mockSCA, "mock.sca", getEnv("RH_MOCK_SCA", "true") == "true", "Whether the organization of -mock-server is in Simple Content Access mode")
+	flag.DurationVar(&mockTokenTTL, "mock.token-ttl", getEnvDuration("RH_MOCK_TOKEN_TTL", 15*time.Minute), "Lifetime of the access tokens issued by -mock-server")
+	fetchInterval = intervalFlag(getEnvInterval("RH_FETCH_INTERVAL", 30*time.Second))
+	flag.Var(&fetchInterval, "fetch.interval", "Time between fetches, e.g. 5m or 1h, a plain number is read as seconds")
+	flag.Float64Var(&fetchJitter, "fetch.jitter", getEnvFloat("RH_FETCH_JITTER", 0), "Delay each fetch by a random time of up to this percentage of -fetch.interval, so replicas don't fetch in sync")
+	flag.DurationVar(&cacheTTL, "cache.ttl", getEnvDuration("RH_CACHE_TTL", 0), "Fetch again when /metrics is scraped and the last fetch is older than this, 0 disables it")
+	flag.StringVar(&auditFile, "audit.file", getEnv("RH_AUDIT_FILE", ""), "Append a json line to this file for every change of the subscriptions between two fetches, - writes to stdout")
+	flag.StringVar(&historyDB, "history.db", getEnv("RH_HISTORY_DB", ""), "Record every fetch in this SQLite database")
+	flag.DurationVar(&historyRetention, "history.retention", getEnvDuration("RH_HISTORY_RETENTION", 0), "Remove fetches older than this from -history.db, 0 keeps all")
+	flag.DurationVar(&historyTrendWindow, "history.trend-window", getEnvDuration("RH_HISTORY_TREND_WINDOW", 30*24*time.Hour), "Time span of the trends derived from -history.db")
+	flag.StringVar(&stateFile, "state.file", getEnv("RH_STATE_FILE", ""), "Save the subscriptions of every successful fetch to this file and export them on startup until the first fetch succeeds")
+	flag.StringVar(&fetchScheduleSpec, "fetch.schedule", getEnv("RH_FETCH_SCHEDULE", ""), "Cron expression for the fetches instead of the fixed interval, e.g. \"0 3 * * *\"")
+	flag.IntVar(&exportKeep, "export.keep", int(getEnvInt("RH_EXPORT_KEEP", 0)), "Keep only the newest n files of -export and -export-textfile names with date placeholders, 0 keeps all")
+	flag.StringVar(&exportScheduleSpec, "export.schedule", getEnv("RH_EXPORT_SCHEDULE", ""), "Cron expression to keep running and write -export and -export-textfile on this schedule")
+	flag.StringVar(&notifySlackURL, "notify.slack-url", getSecretEnv("RH_NOTIFY_SLACK_URL"), "Slack incoming webhook URL to post the subscriptions expiring within -notify.days to")
+	flag.StringVar(&notifyTeamsURL, "notify.teams-url", getSecretEnv("RH_NOTIFY_TEAMS_URL"), "Microsoft Teams incoming webhook URL to post the subscriptions expiring within -notify.days to")
+	flag.StringVar(&notifySMTPAddress, "notify.smtp.address", getEnv("RH_NOTIFY_SMTP_ADDRESS", ""), "SMTP server host:port to mail a digest of the subscriptions expiring within -notify.days")
+	flag.StringVar(&notifySMTPUsername, "notify.smtp.username", getEnv("RH_NOTIFY_SMTP_USERNAME", ""), "Username for -notify.smtp.address")
+	flag.StringVar(&notifySMTPPassword, "notify.smtp.password", getSecretEnv("RH_NOTIFY_SMTP_PASSWORD"), "Password for -notify.smtp.address")
+	flag.StringVar(&notifySMTPFrom, "notify.smtp.from", getEnv("RH_NOTIFY_SMTP_FROM", ""), "Sender address of the digest")
+	notifyEmailTo.defaults = os.Getenv("RH_NOTIFY_EMAIL_TO")
+	flag.Var(&notifyEmailTo, "notify.email-to", "Recipient of the digest, repeatable or comma-separated")
+	flag.IntVar(&notifyDays, "notify.days", int(getEnvInt("RH_NOTIFY_DAYS", 30)), "Notify about subscriptions ending within this many days")
+	flag.StringVar(&notifyScheduleSpec, "notify.schedule", getEnv("RH_NOTIFY_SCHEDULE", "0 9 * * 1"), "Cron expression for sending the notifications")
+	flag.DurationVar(&httpTimeout, "http.timeout", getEnvDuration("RH_HTTP_TIMEOUT", 30*time.Second), "Timeout of a single token, API, import or notification request, 0 disables it")
+	flag.DurationVar(&httpDialTimeout, "http.dial-timeout", getEnvDuration("RH_HTTP_DIAL_TIMEOUT", 10*time.Second), "Timeout for establishing a connection")
+	flag.DurationVar(&fetchTimeout, "fetch.timeout", getEnvDuration("RH_FETCH_TIMEOUT", 10*time.Minute), "Deadline of a whole fetch cycle including retries, 0 disables it")
+	flag.StringVar(&userAgentSuffix, "http.user-agent-suffix", getEnv("RH_USER_AGENT_SUFFIX", ""), "Appended to the User-Agent header of the token, API and import requests, e.g. a contact address")
+	flag.StringVar(&proxyURL, "proxy.url", getEnv("RH_PROXY_URL", ""), "HTTP, HTTPS or SOCKS5 proxy for the token, API and import requests, defaults to the proxy env vars")
+	flag.StringVar(&proxyTokenURL, "proxy.token-url", getEnv("RH_PROXY_TOKEN_URL", ""), "Proxy for the SSO token requests, defaults to -proxy.url")
+	flag.StringVar(&noProxy, "proxy.no-proxy", getEnv("RH_NO_PROXY", ""), "Comma-separated hosts, domains and CIDRs not sent through -proxy.url")
+	flag.StringVar(&selfcheckAllowNaNList, "selfcheck.allow-nan", getEnv("RH_SELFCHECK_ALLOW_NAN", ""), "Comma-separated list of metric names allowed to be NaN by the selfcheck")
+	flag.StringVar(&accountList, "accounts", getEnv("RH_ACCOUNTS", ""), "Comma-separated list of account names, the offline token of each is read from RH_OFFLINE_TOKEN_<NAME>")
+	flag.StringVar(&discoveryURL, "accounts.discovery-url", getEnv("RH_ACCOUNTS_DISCOVERY_URL", ""), "Endpoint listing the customer accounts accessible with a partner token, each is fetched automatically")
+	flag.StringVar(&discoveryParam, "accounts.discovery-param", getEnv("RH_ACCOUNTS_DISCOVERY_PARAM", "accountNumber"), "Query parameter selecting a discovered account in the API requests")
+	flag.StringVar(&skuInclude, "filter.sku-include", getEnv("RH_FILTER_SKU_INCLUDE", ""), "Regular expression the SKU of an exported subscription must match")
+	flag.StringVar(&skuExclude, "filter.sku-exclude", getEnv("RH_FILTER_SKU_EXCLUDE", ""), "Regular expression excluding subscriptions by SKU")
+	flag.StringVar(&statusExclude, "filter.status-exclude", getEnv("RH_FILTER_STATUS_EXCLUDE", ""), "Comma-separated statuses of subscriptions not to export, e.g. Expired,Terminated")
+	flag.DurationVar(&expiredLookback, "filter.expired-lookback", getEnvDuration("RH_FILTER_EXPIRED_LOOKBACK", 0), "Don't export subscriptions that ended longer ago than this, e.g. 17520h for two years. 0 exports all")
+	flag.StringVar(&discoveryInclude, "accounts.include", getEnv("RH_ACCOUNTS_INCLUDE", ""), "Regular expression a discovered account number or name must match")
+	flag.StringVar(&discoveryExclude, "accounts.exclude", getEnv("RH_ACCOUNTS_EXCLUDE", ""), "Regular expression excluding discovered accounts by number or name")
+	flag.StringVar(&refreshBearerToken, "refresh.bearer-token", getSecretEnv("RH_REFRESH_BEARER_TOKEN"), "Bearer token required by POST /-/refresh, which triggers a fetch. Without it the endpoint is open to anyone reaching the exporter")
+	flag.DurationVar(&refreshMinInterval, "refresh.min-interval", getEnvDuration("RH_REFRESH_MIN_INTERVAL", time.Minute), "Minimum time between two fetches triggered by POST /-/refresh")
+	flag.BoolVar(&secretsWatch, "secrets.watch", getEnv("RH_SECRETS_WATCH", "true") == "true", "Rebuild the API clients when a secret file of the *_FILE convention changes, e.g. a rotated Kubernetes secret")
+	flag.StringVar(&vaultAddress, "vault.address", getEnv("RH_VAULT_ADDR", os.Getenv("VAULT_ADDR")), "Read the offline token from this Vault server instead of RH_OFFLINE_TOKEN")
+	flag.StringVar(&vaultPath, "vault.path", getEnv("RH_VAULT_PATH", ""), "KV path of the secret holding the offline token, e.g. secret/data/redhat for KV v2")
+	flag.StringVar(&vaultKey, "vault.key", getEnv("RH_VAULT_KEY", "offline_token"), "Key of the offline token in the Vault secret")
+	flag.StringVar(&vaultAuth, "vault.auth", getEnv("RH_VAULT_AUTH", "kubernetes"), "Vault auth method: kubernetes, approle or token")
+	flag.StringVar(&vaultMount, "vault.auth-mount", getEnv("RH_VAULT_AUTH_MOUNT", ""), "Mount path of the Vault auth method, defaults to the method name")
+	flag.StringVar(&vaultRole, "vault.role", getEnv("RH_VAULT_ROLE", ""), "Vault role for the kubernetes auth method")
+	flag.StringVar(&vaultJWTFile, "vault.jwt-file", getEnv("RH_VAULT_JWT_FILE", "/var/run/secrets/kubernetes.io/serviceaccount/token"), "Service account token for the kubernetes auth method")
+	flag.StringVar(&vaultApproleRoleID, "vault.approle.role-id", getEnv("RH_VAULT_ROLE_ID", ""), "Role ID for the approle auth method")
+	flag.StringVar(&vaultApproleSecret, "vault.approle.secret-id", getSecretEnv("RH_VAULT_SECRET_ID"), "Secret ID for the approle auth method")
+	flag.DurationVar(&vaultRefreshEvery, "vault.refresh-interval", getEnvDuration("RH_VAULT_REFRESH_INTERVAL", 5*time.Minute), "How often the offline token is re-read from Vault")
+	flag.StringVar(&vaultCAFile, "vault.ca-file", getEnv("RH_VAULT_CACERT", os.Getenv("VAULT_CACERT")), "CA certificate the Vault server certificate is verified with, in addition to the system roots")
+	flag.StringVar(&vaultNamespace, "vault.namespace", getEnv("RH_VAULT_NAMESPACE", os.Getenv("VAULT_NAMESPACE")), "Vault Enterprise namespace of the auth method and the secret")
+	flag.DurationVar(&tokenInactivityWindow, "api.token-inactivity-window", getEnvDuration("RH_TOKEN_INACTIVITY_WINDOW", 30*24*time.Hour), "Time after which Red Hat expires an unused offline token")
+	flag.Float64Var(&apiRateLimit, "api.rate-limit", getEnvFloat("RH_API_RATE_LIMIT", 0), "Maximum API requests per second, shared by the pages of a fetch and the optional collectors, 0 disables the limit")
+	flag.IntVar(&apiRateBurst, "api.rate-burst", int(getEnvInt("RH_API_RATE_BURST", 5)), "Requests sent at once before -api.rate-limit applies")
+	flag.BoolVar(&verifyToken, "api.verify-token", getEnv("RH_VERIFY_TOKEN", "true") == "true", "Exchange the offline token on startup and exit with a diagnostic if SSO rejects it")
+	flag.DurationVar(&tokenInactivityWarn, "api.token-inactivity-warn", getEnvDuration("RH_TOKEN_INACTIVITY_WARN", 5*24*time.Hour), "Warn when the offline token expires within this time unless it is used")
+	flag.StringVar(&nowOverride, "now-override", getEnv("RH_NOW_OVERRIDE", ""), "Testing only: fixed RFC3339 time used as now for all derived metrics")
+}
+
+// parseFlags parses the command line into the flags defined by init and
+// selects the subcommand
+func parseFlags() {
 	flag.Parse()
+	if err := parseCommand(); err != nil {
+		slog.Error("Invalid command", "err", err)
+		os.Exit(2)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		cliFlags[f.Name] = true
+	})
+}
+
+// applySettings validates the flag values and derives the parsed settings,
+// it runs on startup and on every config reload. The settings are only
+// published once all are valid, a reload calls it under settingsMu.
+func applySettings() error {
+	logger, err := newLogger()
+	if err != nil {
+		return err
+	}
+
+	switch rulesFormat {
+	case "prometheus", "prometheusrule":
+	default:
+		return fmt.Errorf("invalid -rules.format %q, must be prometheus or prometheusrule", rulesFormat)
+	}
+
+	switch exportFormat {
+	case "prometheus", "influx":
+	default:
+		return fmt.Errorf("invalid -export-format %q, must be prometheus or influx", exportFormat)
+	}
+	if influxURL != "" && (influxOrg == "" || influxBucket == "") {
+		return errors.New("-influx.url requires -influx.org and -influx.bucket")
+	}
+	if zabbixServer != "" && zabbixHost == "" {
+		return errors.New("-zabbix.server requires -zabbix.host")
+	}
+	switch statsdTagFormat {
+	case "dogstatsd", "none":
+	default:
+		return fmt.Errorf("invalid -statsd.tag-format %q, must be dogstatsd or none", statsdTagFormat)
+	}
+	switch metricsCompat {
+	case "legacy", "both", "new":
+	default:
+		return fmt.Errorf("invalid -metrics.compat %q, must be one of legacy, both or new", metricsCompat)
+	}
+	if time.Duration(fetchInterval) < minFetchInterval {
+		return fmt.Errorf("invalid -fetch.interval %s, must be at least %s", fetchInterval, minFetchInterval)
+	}
+	sources, err := parseImportSources(importURLs.values())
+	if err != nil {
+		return fmt.Errorf("invalid -import-url: %w", err)
+	}
+	headers, err := importHeadersFlag.parse()
+	if err != nil {
+		return fmt.Errorf("invalid -import-header: %w", err)
+	}
+	if importBearerToken != "" && importBearerTokenFile != "" {
+		return errors.New("-import-bearer-token and -import-bearer-token-file can't be combined")
+	}
+	if (importBearerToken != "" || importBearerTokenFile != "") && importUsername != "" {
+		return errors.New("-import-username and a bearer token for -import-url can't be combined")
+	}
+	if importFile != "" && len(sources) > 0 {
+		return errors.New("-import-file and -import-url can't be combined")
+	}
+	var verifier signatureVerifier
+	if importSignatureKey != "" {
+		if verifier, err = loadSignatureVerifier(importSignatureKey); err != nil {
+			return fmt.Errorf("invalid -import-signature-key: %w", err)
+		}
+	}
+	if exportToFile == stdoutExport && exportTextfile == stdoutExport {
+		return errors.New("only one of -export and -export-textfile can write to stdout")
+	}
+	encrypt, err := parseExportRecipients(exportEncryptFlag.values())
+	if err != nil {
+		return fmt.Errorf("invalid -export-encrypt: %w", err)
+	}
+	if exportKeep < 0 {
+		return fmt.Errorf("invalid -export.keep %d, must not be negative", exportKeep)
+	}
+	if fetchJitter < 0 || fetchJitter > 100 {
+		return fmt.Errorf("invalid -fetch.jitter %v, must be between 0 and 100", fetchJitter)
+	}
+	if unlimitedQuantity == 0 || math.IsNaN(unlimitedQuantity) {
+		return fmt.Errorf("invalid -quantity.unlimited %v, must not be 0 or NaN", unlimitedQuantity)
+	}
+	rules, err := parseRelabelRules(relabelConfig)
+	if err != nil {
+		return fmt.Errorf("invalid -relabel: %w", err)
+	}
+	labels, err := staticLabelsFlag.parse()
+	if err != nil {
+		return fmt.Errorf("invalid -labels: %w", err)
+	}
+	for _, name := range splitList(infoLabelList) {
+		if _, ok := collector.InfoLabelFields[name]; !ok {
+			return fmt.Errorf("invalid -metrics.info-labels field %q, must be one of %s", name, strings.Join(slices.Sorted(maps.Keys(collector.InfoLabelFields)), ", "))
+		}
+	}
+
+	if _, err := configuredAccounts(); err != nil {
+		return err
+	}
+
+	if _, err := newAPITransport(); err != nil {
+		return err
+	}
+	if _, err := newTokenTransport(); err != nil {
+		return err
+	}
+
+	skuInclude, skuExclude, err := compileFilters()
+	if err != nil {
+		return err
+	}
+	discoveryInclude, discoveryExclude, err := compileDiscoveryPatterns()
+	if err != nil {
+		return err
+	}
+
+	schedule, err := parseSchedule("-fetch.schedule", fetchScheduleSpec)
+	if err != nil {
+		return err
+	}
+	if _, err := parseSchedule("-export.schedule", exportScheduleSpec); err != nil {
+		return err
+	}
+	if err := validateCommand(); err != nil {
+		return err
+	}
+	if notifyEnabled() {
+		if notifyScheduleSpec == "" {
+			return errors.New("-notify.schedule is required for notifications")
+		}
+		if _, err := parseSchedule("-notify.schedule", notifyScheduleSpec); err != nil {
+			return err
+		}
+		if notifyDays <= 0 {
+			return errors.New("-notify.days must be positive")
+		}
+	}
+	if notifySMTPAddress != "" {
+		if notifySMTPFrom == "" || len(notifyEmailTo.values()) == 0 {
+			return errors.New("-notify.smtp.address requires -notify.smtp.from and -notify.email-to")
+		}
+		if _, _, err := net.SplitHostPort(notifySMTPAddress); err != nil {
+			return fmt.Errorf("invalid -notify.smtp.address: %w", err)
+		}
+	}
+
+	if vaultAddress != "" && vaultPath == "" {
+		return errors.New("-vault.address requires -vault.path")
+	}
+
+	if mockErrorRate < 0 || mockErrorRate > 1 || mockRateLimitRate < 0 || mockRateLimitRate > 1 {
+		return fmt.Errorf("invalid -mock.error-rate or -mock.rate-limit-rate, must be between 0 and 1")
+	}
+	switch fetchSource {
+	case "rhsm":
+	case "candlepin":
+		if candlepinURL == "" || candlepinOwner == "" {
+			return fmt.Errorf("-source candlepin needs -candlepin.url and -candlepin.owner")
+		}
+	case "entitlement-certs":
+	default:
+		return fmt.Errorf("invalid -source %q, must be rhsm, candlepin or entitlement-certs", fetchSource)
+	}
+	if scaConsumption != "keep" && scaConsumption != "drop" {
+		return fmt.Errorf("invalid -sca.consumption %q, must be keep or drop", scaConsumption)
+	}
+	if emptyResponse != "keep" && emptyResponse != "trust" {
+		return fmt.Errorf("invalid -fetch.empty-response %q, must be keep or trust", emptyResponse)
+	}
+
+	if sanitizeMaxLength < 0 || sanitizeMaxLength == 1 {
+		return fmt.Errorf("invalid -sanitize.max-length %d, must be 0 or at least 2", sanitizeMaxLength)
+	}
+
+	if anonymizeLabels && anonymizeSalt == "" {
+		return errors.New("-anonymize-labels requires -anonymize-labels.salt, the hashes of the numbers could be reversed without it")
+	}
+
+	if circuitFailures < 0 {
+		return fmt.Errorf("invalid -circuit.failures %d, must not be negative", circuitFailures)
+	}
+
+	if apiRateLimit < 0 || apiRateBurst < 1 {
+		return fmt.Errorf("invalid -api.rate-limit %g or -api.rate-burst %d, must not be negative and at least 1", apiRateLimit, apiRateBurst)
+	}
+
+	if pageSize <= 0 {
+		return fmt.Errorf("invalid -fetch.page-size %d, must be greater than 0", pageSize)
+	}
+
+	if _, err := parseSocketMode(socketMode); err != nil {
+		return err
+	}
+
+	namespace, identity := leaderNamespace, leaderIdentity
+	if leaderElection {
+		if leaderLeaseDuration < 3*time.Second {
+			return fmt.Errorf("invalid -leader.lease-duration %s, must be at least 3s", leaderLeaseDuration)
+		}
+		if namespace == "" {
+			data, err := os.ReadFile(serviceAccountDir + "/namespace")
+			if err != nil {
+				return fmt.Errorf("-leader.namespace is not set and the namespace of the pod is unknown: %w", err)
+			}
+			namespace = strings.TrimSpace(string(data))
+		}
+		if identity == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("-leader.identity is not set: %w", err)
+			}
+			identity = hostname
+		}
+	}
+
+	if fiscalYearStart < 1 || fiscalYearStart > 12 {
+		return fmt.Errorf("invalid -metrics.fiscal-year-start %d, must be a month between 1 and 12", fiscalYearStart)
+	}
+
+	if renewalWindow <= 0 {
+		return fmt.Errorf("invalid -metrics.renewal-window %s, must be positive", renewalWindow)
+	}
+
+	now, err := parseNowOverride(nowOverride)
+	if err != nil {
+		return fmt.Errorf("invalid -now-override: %w", err)
+	}
+
+	modes, err := parseCountingModes(countingModeList)
+	if err != nil {
+		return fmt.Errorf("invalid -counting.modes: %w", err)
+	}
+	families, err := parseProductFamilies(productFamilyList)
+	if err != nil {
+		return fmt.Errorf("invalid -product-families: %w", err)
+	}
+	if err := validateEnvironment(); err != nil {
+		return err
+	}
+	if apiClientID == "" {
+		return errors.New("-api.offline-client-id must not be empty")
+	}
+
+	// Everything is valid, publish the settings
+	slog.SetDefault(logger)
+	importSources = sources
+	importHeaders = headers
+	importVerifier = verifier
+	exportEncrypt = encrypt
+	relabelRules = rules
+	staticLabels = labels
+	skuIncludeRe, skuExcludeRe = skuInclude, skuExclude
+	discoveryIncludeRe, discoveryExcludeRe = discoveryInclude, discoveryExclude
+	fetchSchedule = schedule
+	setAPIRateLimit(apiRateLimit, apiRateBurst)
+	leaderNamespace, leaderIdentity = namespace, identity
+	fixedNow = now
+	countingModes = modes
+	productFamilies = families
+	tokenURLFallbacks = splitList(tokenURLFallbackList)
+	apiScopes = splitList(apiScopesList)
+	noCostSKUs = splitList(noCostSKUList)
+	selfcheckAllowNaN = splitList(selfcheckAllowNaNList)
+	capacityPoolTypes = splitList(capacityPoolTypeList)
+	if defaultSubscriptionMetrics == nil {
+		defaultSubscriptionMetrics = collector.New(subscriptionsRegistry, collectorOptions(nil))
+	} else {
+		defaultSubscriptionMetrics.SetOptions(collectorOptions(nil))
+	}
+	return nil
+}
+
+// infoLabels returns the fields of -metrics.info-labels, nil for the default
+// labels
+func infoLabels() []string {
+	if infoLabelList == "" {
+		// Merged imports are told apart by their source
+		if len(importSources) > 1 {
+			return append(slices.Clone(collector.DefaultInfoLabels), "source")
+		}
+		return nil
+	}
+	return splitList(infoLabelList)
 }
 
 func main() {
-	token := getEnv("RH_OFFLINE_TOKEN", "")
-	if token == "" {
-		fmt.Println("Please set RH_OFFLINE_TOKEN.")
+	parseFlags()
+	if showVersion {
+		fmt.Println(version.Print("redhat-subscription-exporter"))
+		os.Exit(0)
+	}
+
+	if err := applySettings(); err != nil {
+		slog.Error("Invalid settings", "err", err)
+		os.Exit(1)
+	}
+	if code, ok := runServiceCommand(); ok {
+		os.Exit(code)
+	}
+	if command == "dashboard" {
+		os.Exit(runDashboard())
+	}
+	if command == "rules" {
+		os.Exit(runRules())
+	}
+	registerRuntimeCollectors()
+	slog.Info("Starting redhat-subscription-exporter", "version", version.Info(), "build_context", version.BuildContext())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, serviceDone := startService(ctx)
+	defer serviceDone()
+
+	if mockServerAddress != "" {
+		if err := runMockServer(ctx); err != nil {
+			slog.Error("Mock server failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	token := getSecretEnv("RH_OFFLINE_TOKEN")
+	serviceAccount := os.Getenv("RH_CLIENT_ID") != "" && getSecretEnv("RH_CLIENT_SECRET") != ""
+	if token == "" && !serviceAccount && vaultAddress == "" && accountList == "" && len(importSources) == 0 && importFile == "" && fetchSource == "rhsm" {
+		slog.Error("Please set RH_OFFLINE_TOKEN, RH_OFFLINE_TOKEN_FILE, RH_CLIENT_ID and RH_CLIENT_SECRET or -vault.address")
 		os.Exit(1)
 	}
 
-	done := make(chan error)
-	metricsLoop(token, getEnv("RH_TOKEN_URL", DefaultTokenURL), getEnv("RH_API_URL", DefaultApiURL), exportToFile, importUrl, importUsername, importPassword, getEnvInt("RH_FETCH_INTERVAL", 30), done)
+	if vaultAddress != "" {
+		vc, err := newVaultClient(ctx)
+		if err != nil {
+			slog.Error("Failed to get offline token from vault", "err", err)
+			os.Exit(1)
+		}
+		vaultSource = vc
+		go vc.run(ctx)
+	}
+
+	if command == "" && exportOnce() {
+		slog.Warn("Exporting once without a command is deprecated, use the export command")
+	}
+
+	if command == "check" {
+		os.Exit(runCheck(ctx))
+	}
+
+	if command == "validate" {
+		os.Exit(runValidate(ctx))
+	}
+
+	// With -leader.election only the leader calls SSO, it finds a rejected
+	// token on its first fetch
+	if verifyToken && fetchSource == "rhsm" && len(importSources) == 0 && importFile == "" && !leaderElection {
+		if err := verifyTokens(ctx); err != nil {
+			slog.Error("The token was rejected, not starting", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if command == "tui" {
+		if err := runTUI(ctx); err != nil {
+			slog.Error("Dashboard failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if importFile != "" && oneShotExport() == "" {
+		if err := watchImportFile(ctx, importFile); err != nil {
+			slog.Error("Failed to watch import file, it is only read every fetch interval", "file", importFile, "err", err)
+		}
+	}
+
+	if secretsWatch && !exportOnce() {
+		if err := watchSecrets(ctx); err != nil {
+			slog.Error("Failed to watch secret files, rotated secrets need a restart", "err", err)
+		}
+	}
+
+	if historyDB != "" {
+		h, err := openHistory(historyDB)
+		if err != nil {
+			slog.Error("Failed to open history database", "file", historyDB, "err", err)
+			os.Exit(1)
+		}
+		defer h.close()
+		history = h
+	}
 
-	if exportToFile != "" {
+	if stateFile != "" {
+		if err := loadState(stateFile); err != nil {
+			slog.Error("Failed to restore state file", "file", stateFile, "err", err)
+		}
+	}
+
+	// Tracing starts before the fetch loop, so the spans of the first cycle
+	// are exported too
+	shutdownTracing := func(context.Context) error { return nil }
+	if otlpTraces {
+		var err error
+		if shutdownTracing, err = startTracing(ctx); err != nil {
+			slog.Error("Failed to start OTLP trace exporter", "err", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				slog.Error("Error shutting down OTLP trace exporter", "err", err)
+			}
+		}()
+	}
+
+	if leaderElection && !exportOnce() {
+		waitReleased, err := startLeaderElection(ctx)
+		if err != nil {
+			slog.Error("Failed to start leader election", "err", err)
+			os.Exit(1)
+		}
+		defer waitReleased()
+	}
+
+	done := make(chan error, 1)
+	metricsLoop(ctx, done)
+
+	if exportOnce() {
 		err := <-done
+		// os.Exit skips the deferred shutdown, the spans are flushed first
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Error shutting down OTLP trace exporter", "err", err)
+		}
 		if err != nil {
-			log.Fatal(err)
+			slog.Error("Export failed", "err", err)
+			os.Exit(1)
 		}
 		os.Exit(0)
 	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	fmt.Println("Listening on :2112")
-	http.ListenAndServe(":2112", nil)
+	if otlpEnabled {
+		shutdownOTLP, err := startOTLP(ctx)
+		if err != nil {
+			slog.Error("Failed to start OTLP exporter", "err", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := shutdownOTLP(context.Background()); err != nil {
+				slog.Error("Error shutting down OTLP exporter", "err", err)
+			}
+		}()
+	}
+
+	// A reload takes the write lock, so its handler must not hold the read
+	// lock, and the probe releases it during its fetch
+	http.Handle("/", withSettings(http.HandlerFunc(indexHandler)))
+	http.Handle(telemetryPath, withSettings(metricsHandler()))
+	http.Handle("/healthz", withSettings(http.HandlerFunc(healthzHandler)))
+	http.HandleFunc("/readyz", readyzHandler)
+	http.Handle("/assets/", assetsHandler())
+	http.HandleFunc("/-/reload", reloadHandler)
+	http.Handle("/-/refresh", withSettings(http.HandlerFunc(refreshHandler)))
+	http.HandleFunc("/-/quit", quitHandler)
+	http.Handle("/api/v1/search", withSettings(http.HandlerFunc(searchHandler)))
+	http.Handle("/api/v1/subscriptions", withSettings(http.HandlerFunc(subscriptionsHandler)))
+	http.Handle("/subscriptions", withSettings(http.HandlerFunc(subscriptionsPageHandler)))
+	http.HandleFunc("/probe", probeHandler)
+	http.Handle("/status", withSettings(http.HandlerFunc(statusHandler)))
+
+	if exportScheduleSpec != "" && (exportToFile != "" || exportTextfile != "") {
+		schedule, _ := parseSchedule("-export.schedule", exportScheduleSpec)
+		tasks.add("export", exportScheduleSpec, schedule, withSettingsTask(runScheduledExport))
+	}
+	if notifyEnabled() {
+		schedule, _ := parseSchedule("-notify.schedule", notifyScheduleSpec)
+		tasks.add("notify", notifyScheduleSpec, schedule, withSettingsTask(runNotify))
+	}
+	go tasks.run(ctx)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := requestReload(ctx); err != nil {
+				slog.Error("Error reloading config", "err", err)
+			}
+		}
+	}()
+
+	if pprofEnabled && pprofAddress != "" {
+		go servePprof(ctx)
+	}
+
+	server := &http.Server{Handler: withPprof(http.DefaultServeMux)}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- listenAndServe(server, listenAddress, systemdSocket)
+	}()
+	go systemdNotify(ctx)
+
+	select {
+	case err := <-serverErr:
+		slog.Error("HTTP server failed", "err", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	case <-quitRequests:
+		stop()
+	}
+
+	draining.Store(true)
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down HTTP server", "err", err)
+	}
+	<-done
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseNowOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "unset", value: ""},
+		{name: "utc", value: "2030-01-02T03:04:05Z", want: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "offset", value: "2030-01-02T03:04:05+02:00", want: time.Date(2030, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{name: "date only", value: "2030-01-02", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNowOverride(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNowOverride(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseNowOverride(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNowOverride checks that the derived metrics and the expiry digest are
+// computed for the -now-override time
+func TestNowOverride(t *testing.T) {
+	defer func(now time.Time) { fixedNow = now }(fixedNow)
+
+	subs := []rhsm.Subscription{
+		{SubscriptionNumber: "100", Quantity: "1", StartDate: time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2030, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{SubscriptionNumber: "200", Quantity: "1", StartDate: time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{SubscriptionNumber: "300", Quantity: "1", StartDate: time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2029, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	tests := []struct {
+		override     string
+		daysTo100    float64
+		active300    float64
+		wantExpiring []string
+	}{
+		{override: "2030-01-01T00:00:00Z", daysTo100: 10, active300: 0, wantExpiring: []string{"100"}},
+		{override: "2029-11-25T12:00:00Z", daysTo100: 46, active300: 1, wantExpiring: []string{"300"}},
+		{override: "2030-05-15T00:00:00Z", daysTo100: -124, active300: 0, wantExpiring: []string{"200"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.override, func(t *testing.T) {
+			now, err := parseNowOverride(tt.override)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fixedNow = now
+			if got := currentTime(); !got.Equal(now) {
+				t.Fatalf("currentTime() = %s, want %s", got, now)
+			}
+
+			m := collector.New(prometheus.NewRegistry(), collector.Options{Now: currentTime})
+			m.Update(subs)
+			if got := testutil.ToFloat64(m.SubscriptionDaysRemainingGauge.WithLabelValues("100")); got != tt.daysTo100 {
+				t.Errorf("days remaining of 100 = %v, want %v", got, tt.daysTo100)
+			}
+			if got := testutil.ToFloat64(m.SubscriptionActiveGauge.WithLabelValues("300")); got != tt.active300 {
+				t.Errorf("active of 300 = %v, want %v", got, tt.active300)
+			}
+
+			var expiring []string
+			for _, s := range expiringSubscriptions(subs, 30, currentTime()) {
+				expiring = append(expiring, s.SubscriptionNumber)
+			}
+			if !slices.Equal(expiring, tt.wantExpiring) {
+				t.Errorf("expiring = %v, want %v", expiring, tt.wantExpiring)
+			}
+		})
+	}
+}
+
+// TestApplySettingsInvalid checks that invalid settings don't change any of
+// the derived settings, even those validated before the invalid one
+func TestApplySettingsInvalid(t *testing.T) {
+	defer func(config string, size int, rules []relabelRule) {
+		relabelConfig, pageSize, relabelRules = config, size, rules
+	}(relabelConfig, pageSize, relabelRules)
+
+	relabelRules = nil
+	relabelConfig = `[{"field": "account", "action": "uppercase"}]`
+	pageSize = 0
+	if err := applySettings(); err == nil || !strings.Contains(err.Error(), "-fetch.page-size") {
+		t.Fatalf("applySettings() error = %v, want the invalid -fetch.page-size", err)
+	}
+	if relabelRules != nil {
+		t.Errorf("relabel rules = %+v, want the invalid settings discarded", relabelRules)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+var (
+	mockServerAddress string
+	mockDataset       string
+	mockLatency       time.Duration
+	mockErrorRate     float64
+	mockRateLimitRate float64
+	mockOfflineToken  string
+	mockSCA           bool
+	mockTokenTTL      time.Duration
+)
+
+const (
+	mockTokenPath         = "/auth/realms/redhat-external/protocol/openid-connect/token"
+	mockSubscriptionsPath = "/management/v1/subscriptions"
+	mockSystemsPath       = "/management/v1/systems"
+	mockEntitlementsPath  = "/management/v1/systems/{uuid}/entitlements"
+	mockAllocationsPath   = "/management/v1/allocations"
+	mockPoolsPath         = "/management/v1/pools"
+	mockErrataPath        = "/management/v1/errata"
+	mockOrganizationPath  = "/management/v1/organization"
+)
+
+// mockProducts are the SKUs of the canned datasets
+var mockProducts = []struct {
+	sku, name, serviceLevel, role string
+	pools                         int
+}{
+	{"RH00004", "Red Hat Enterprise Linux Server, Standard (Physical or Virtual Nodes)", "Standard", "Red Hat Enterprise Linux Server", 1},
+	{"RH00006", "Red Hat Enterprise Linux for Virtual Datacenters, Standard", "Standard", "Red Hat Enterprise Linux Server", 2},
+	{"MCT2741", "Red Hat OpenShift Container Platform, Premium (2 Cores or 4 vCPUs)", "Premium", "", 1},
+	{"MCT3718", "Red Hat Ansible Automation Platform, Standard (100 Managed Nodes)", "Standard", "", 1},
+	{"RH00798", "Red Hat Developer Subscription for Individuals", "Self-Support", "Red Hat Enterprise Linux Workstation", 1},
+}
+
+// mockDatasets are the canned datasets selectable with -mock.dataset
+var mockDatasets = map[string]func(now time.Time) []rhsm.Subscription{
+	"default":  func(now time.Time) []rhsm.Subscription { return mockSubscriptions(now, 100, -365, 730) },
+	"small":    func(now time.Time) []rhsm.Subscription { return mockSubscriptions(now, 10, -30, 365) },
+	"large":    func(now time.Time) []rhsm.Subscription { return mockSubscriptions(now, 5000, -365, 1095) },
+	"expiring": func(now time.Time) []rhsm.Subscription { return mockSubscriptions(now, 20, 0, 60) },
+	"empty":    func(now time.Time) []rhsm.Subscription { return nil },
+}
+
+// mockSubscriptions generates n subscriptions ending between minDays and
+// maxDays from now. The same n always yields the same subscriptions.
+func mockSubscriptions(now time.Time, n, minDays, maxDays int) []rhsm.Subscription {
+	rng := mathrand.New(mathrand.NewPCG(uint64(n), 42))
+	today := now.UTC().Truncate(24 * time.Hour)
+	subs := make([]rhsm.Subscription, 0, n)
+	for i := range n {
+		product := mockProducts[i%len(mockProducts)]
+		end := today.AddDate(0, 0, minDays+rng.IntN(maxDays-minDays+1))
+		start := end.AddDate(-1, 0, 0)
+		if i%11 == 0 {
+			// Some renewals that haven't started yet
+			start = today.AddDate(0, 0, 1+rng.IntN(30))
+			end = start.AddDate(1, 0, 0)
+		}
+		quantity := 1 + rng.IntN(100)
+		usage := "Production"
+		if i%4 == 3 {
+			usage = "Development/Test"
+		}
+		s := rhsm.Subscription{
+			ContractNumber:     strconv.Itoa(10000000 + i/3),
+			SubscriptionNumber: strconv.Itoa(20000000 + i),
+			SubscriptionName:   product.name,
+			SKU:                product.sku,
+			Status:             rhsm.Status(start, end, now),
+			Quantity:           strconv.Itoa(quantity),
+			StartDate:          start,
+			EndDate:            end,
+			ServiceLevel:       product.serviceLevel,
+			Usage:              usage,
+			Role:               product.role,
+		}
+		for p := range product.pools {
+			poolType := "NORMAL"
+			if p > 0 {
+				poolType = "ENTITLEMENT_DERIVED"
+			}
+			s.Pools = append(s.Pools, rhsm.Pool{
+				ID:       fmt.Sprintf("8a85f9%06x%02d", i, p),
+				Type:     poolType,
+				Quantity: quantity,
+				Consumed: rng.IntN(quantity + 1),
+			})
+		}
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// mockSystems generates a registered system per subscription of the dataset
+func mockSystems(subs []rhsm.Subscription, now time.Time) []rhsm.System {
+	types := []string{"Physical", "Virtual", "Virtual", "Hypervisor"}
+	statuses := []string{"valid", "valid", "valid", "partial", "invalid"}
+	systems := make([]rhsm.System, 0, len(subs))
+	for i := range subs {
+		uuid := fmt.Sprintf("5f2b1c4e-0000-4000-8000-%012x", i)
+		systems = append(systems, rhsm.System{
+			UUID:              uuid,
+			Name:              fmt.Sprintf("host%04d.example.com", i),
+			Type:              types[i%len(types)],
+			EntitlementStatus: statuses[i%len(statuses)],
+			EntitlementCount:  1 + i%3,
+			LastCheckin:       now.UTC().Add(-time.Duration(i%48) * time.Hour).Truncate(time.Second),
+			Href:              "/systems/" + uuid,
+		})
+	}
+	return systems
+}
+
+// mockPools lists the pools of the subscriptions of the dataset, derived
+// pools have some of their entitlements exported to a manifest
+func mockPools(subs []rhsm.Subscription) []rhsm.AccountPool {
+	var pools []rhsm.AccountPool
+	for _, s := range subs {
+		for _, p := range s.Pools {
+			exported := 0
+			if p.Type != "NORMAL" {
+				exported = (p.Quantity - p.Consumed) / 2
+			}
+			pools = append(pools, rhsm.AccountPool{
+				ID:                 p.ID,
+				SubscriptionNumber: s.SubscriptionNumber,
+				SubscriptionName:   s.SubscriptionName,
+				SKU:                s.SKU,
+				Type:               p.Type,
+				Quantity:           p.Quantity,
+				Consumed:           p.Consumed,
+				Exported:           exported,
+				StartDate:          s.StartDate,
+				EndDate:            s.EndDate,
+			})
+		}
+	}
+	return pools
+}
+
+// mockAllocations generates a Satellite manifest per started ten subscriptions
+// of the dataset
+func mockAllocations(subs []rhsm.Subscription, now time.Time) []rhsm.Allocation {
+	var allocations []rhsm.Allocation
+	for i := 0; i < len(subs); i += 10 {
+		n := i / 10
+		created := now.UTC().AddDate(0, -1-n, 0).Truncate(time.Second)
+		allocations = append(allocations, rhsm.Allocation{
+			UUID:                fmt.Sprintf("9c4d2e7a-0000-4000-8000-%012x", n),
+			Name:                fmt.Sprintf("satellite-%02d", n),
+			Type:                "Satellite",
+			Version:             "6.15",
+			EntitlementQuantity: 5 * (n%4 + 1),
+			CreatedDate:         created,
+			LastModified:        created.AddDate(0, 0, 7*n),
+			SimpleContentAccess: "enabled",
+		})
+	}
+	return allocations
+}
+
+// mockErrata are the errata applicable to the mock systems
+var mockErrata = []rhsm.Erratum{
+	{AdvisoryID: "RHSA-2026:0101", Synopsis: "Important: kernel security update", Type: "Security Advisory", Severity: "Important", Issued: "2026-01-08", AffectedSystemsCount: 12},
+	{AdvisoryID: "RHSA-2026:0154", Synopsis: "Critical: openssl security update", Type: "Security Advisory", Severity: "Critical", Issued: "2026-01-15", AffectedSystemsCount: 7},
+	{AdvisoryID: "RHSA-2026:0230", Synopsis: "Moderate: curl security update", Type: "Security Advisory", Severity: "Moderate", Issued: "2026-02-02", AffectedSystemsCount: 3},
+	{AdvisoryID: "RHBA-2026:0117", Synopsis: "systemd bug fix update", Type: "Bug Fix Advisory", Issued: "2026-01-10", AffectedSystemsCount: 20},
+	{AdvisoryID: "RHBA-2026:0188", Synopsis: "NetworkManager bug fix update", Type: "Bug Fix Advisory", Issued: "2026-01-22", AffectedSystemsCount: 4},
+	{AdvisoryID: "RHEA-2026:0142", Synopsis: "podman enhancement update", Type: "Product Enhancement Advisory", Issued: "2026-01-14", AffectedSystemsCount: 9},
+}
+
+// loadMockDataset returns the canned dataset of the given name, or reads a
+// json file with either a list of subscriptions or a subscriptions response
+func loadMockDataset(name string, now time.Time) ([]rhsm.Subscription, error) {
+	if gen, ok := mockDatasets[name]; ok {
+		return gen(now), nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown -mock.dataset %q, must be default, small, large, expiring, empty or a json file: %w", name, err)
+	}
+	var subs []rhsm.Subscription
+	if err := json.Unmarshal(data, &subs); err == nil {
+		return subs, nil
+	}
+	var resp rhsm.Page
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return resp.Body, nil
+}
+
+// mockServer imitates the Red Hat SSO token endpoint and the subscriptions
+// API, so deployments can be tested end to end without the real APIs
+type mockServer struct {
+	subs        []rhsm.Subscription
+	systems     []rhsm.System
+	allocations []rhsm.Allocation
+	pools       []rhsm.AccountPool
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// token exchanges an offline token or service account credentials for an
+// access token. With -mock.offline-token only that token (or client secret)
+// is accepted.
+func (m *mockServer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		mockOAuthError(w, "invalid_request", err.Error())
+		return
+	}
+
+	var credential string
+	switch r.PostForm.Get("grant_type") {
+	case "refresh_token":
+		credential = r.PostForm.Get("refresh_token")
+	case "client_credentials":
+		credential = r.PostForm.Get("client_secret")
+		if _, secret, ok := r.BasicAuth(); ok {
+			credential = secret
+		}
+	default:
+		mockOAuthError(w, "unsupported_grant_type", "grant type must be refresh_token or client_credentials")
+		return
+	}
+	if credential == "" || (mockOfflineToken != "" && credential != mockOfflineToken) {
+		mockOAuthError(w, "invalid_grant", "Invalid refresh token")
+		return
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	m.mu.Lock()
+	m.tokens[token] = time.Now().Add(mockTokenTTL)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(mockTokenTTL.Seconds()),
+	})
+}
+
+// mockOAuthError writes an OAuth2 error response
+func mockOAuthError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// authorized reports whether the request carries a valid access token
+func (m *mockServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expiry, ok := m.tokens[token]
+	if ok && time.Now().After(expiry) {
+		delete(m.tokens, token)
+		return false
+	}
+	return ok
+}
+
+// available reports whether a request may be served, after -mock.latency and
+// failing -mock.error-rate of the requests
+func (m *mockServer) available(w http.ResponseWriter, r *http.Request) bool {
+	if err := sleepContext(r.Context(), mockLatency); err != nil {
+		return false
+	}
+	if !m.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if mathrand.Float64() < mockRateLimitRate {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	if mathrand.Float64() < mockErrorRate {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// subscriptions serves a page of the dataset
+func (m *mockServer) subscriptions(w http.ResponseWriter, r *http.Request) {
+	if m.available(w, r) {
+		writeMockPage(w, r, m.subs)
+	}
+}
+
+// systemsList serves a page of the registered systems
+func (m *mockServer) systemsList(w http.ResponseWriter, r *http.Request) {
+	if m.available(w, r) {
+		writeMockPage(w, r, m.systems)
+	}
+}
+
+// systemEntitlements serves the entitlement a mock system consumes from the
+// first pool of its subscription
+func (m *mockServer) systemEntitlements(w http.ResponseWriter, r *http.Request) {
+	if !m.available(w, r) {
+		return
+	}
+	entitlements := []rhsm.SystemEntitlement{}
+	if i := slices.IndexFunc(m.systems, func(s rhsm.System) bool { return s.UUID == r.PathValue("uuid") }); i >= 0 && i < len(m.subs) && len(m.subs[i].Pools) > 0 {
+		s := m.subs[i]
+		entitlements = append(entitlements, rhsm.SystemEntitlement{
+			ID:                 fmt.Sprintf("ff8080%08x", i),
+			PoolID:             s.Pools[0].ID,
+			SubscriptionNumber: s.SubscriptionNumber,
+			SKU:                s.SKU,
+			Quantity:           m.systems[i].EntitlementCount,
+		})
+	}
+	writeMockPage(w, r, entitlements)
+}
+
+// allocationsList serves a page of the subscription allocations
+func (m *mockServer) allocationsList(w http.ResponseWriter, r *http.Request) {
+	if m.available(w, r) {
+		writeMockPage(w, r, m.allocations)
+	}
+}
+
+// poolsList serves a page of the pools of the account
+func (m *mockServer) poolsList(w http.ResponseWriter, r *http.Request) {
+	if m.available(w, r) {
+		writeMockPage(w, r, m.pools)
+	}
+}
+
+// errataList serves a page of the applicable errata
+func (m *mockServer) errataList(w http.ResponseWriter, r *http.Request) {
+	if m.available(w, r) {
+		writeMockPage(w, r, mockErrata)
+	}
+}
+
+// organization serves the organization, in Simple Content Access mode (the
+// default for Red Hat organizations) unless -mock.sca is false
+func (m *mockServer) organization(w http.ResponseWriter, r *http.Request) {
+	if !m.available(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"body": rhsm.Organization{
+		ID:                         "12345678",
+		SimpleContentAccess:        mockSCAMode(),
+		SimpleContentAccessCapable: true,
+	}})
+}
+
+// mockSCAMode returns the content access mode of the mock organization
+func mockSCAMode() string {
+	if mockSCA {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// writeMockPage writes the page of items selected by the limit and offset
+// parameters in the pagination envelope of the API
+func writeMockPage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	page := []T{}
+	if offset < len(items) {
+		page = items[offset:min(offset+limit, len(items))]
+	}
+
+	resp := map[string]any{
+		"body":       page,
+		"pagination": rhsm.Pagination{Count: len(items), Limit: limit, Offset: offset},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runMockServer serves the mock token endpoint and subscriptions API on
+// -mock-server until ctx is cancelled. TLS and basic auth are configured
+// with -web.config.file like for the exporter itself.
+func runMockServer(ctx context.Context) error {
+	now := currentTime()
+	subs, err := loadMockDataset(mockDataset, now)
+	if err != nil {
+		return err
+	}
+	m := &mockServer{
+		subs:        subs,
+		systems:     mockSystems(subs, now),
+		allocations: mockAllocations(subs, now),
+		pools:       mockPools(subs),
+		tokens:      map[string]time.Time{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(mockTokenPath, m.token)
+	mux.HandleFunc(mockSubscriptionsPath, m.subscriptions)
+	mux.HandleFunc(mockSystemsPath, m.systemsList)
+	mux.HandleFunc(mockEntitlementsPath, m.systemEntitlements)
+	mux.HandleFunc(mockAllocationsPath, m.allocationsList)
+	mux.HandleFunc(mockPoolsPath, m.poolsList)
+	mux.HandleFunc(mockErrataPath, m.errataList)
+	mux.HandleFunc(mockOrganizationPath, m.organization)
+	mux.HandleFunc("/healthz", healthzHandler)
+
+	server := &http.Server{Handler: mux}
+	systemdSocket := false
+	listenAddresses := []string{mockServerAddress}
+	flags := &web.FlagConfig{
+		WebListenAddresses: &listenAddresses,
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &webConfigFile,
+	}
+
+	slog.Info("Serving mock Red Hat APIs", "address", mockServerAddress, "dataset", mockDataset, "subscriptions", len(subs), "token_path", mockTokenPath, "api_path", mockSubscriptionsPath)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- web.ListenAndServe(server, flags, slog.Default())
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
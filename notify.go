@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	notifySlackURL     string
+	notifyTeamsURL     string
+	notifyDays         int
+	notifyScheduleSpec string
+
+	NotificationsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_exporter_notifications_total",
+		Help: "Total number of notifications about expiring subscriptions sent.",
+	},
+		[]string{"notifier"})
+	NotificationErrorsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_exporter_notification_errors_total",
+		Help: "Total number of notifications about expiring subscriptions that failed to send.",
+	},
+		[]string{"notifier"})
+)
+
+// notifier sends the expiring subscriptions to a channel
+type notifier struct {
+	name    string
+	enabled func() bool
+	send    func(ctx context.Context, expiring []expiringSubscription) error
+}
+
+// notifiers are the channels the expiring subscriptions are sent to on
+// -notify.schedule
+var notifiers = []notifier{
+	{name: "slack", enabled: func() bool { return notifySlackURL != "" }, send: sendSlack},
+	{name: "teams", enabled: func() bool { return notifyTeamsURL != "" }, send: sendTeams},
+}
+
+// notifyEnabled reports whether any notifier is configured
+func notifyEnabled() bool {
+	return slices.ContainsFunc(notifiers, func(n notifier) bool { return n.enabled() })
+}
+
+// expiringSubscription is a subscription ending within -notify.days
+type expiringSubscription struct {
+	rhsm.Subscription
+	DaysRemaining int
+	// Remaining is the quantity left to consume, e.g. "12" or "Unlimited"
+	Remaining string
+}
+
+// expiringSubscriptions returns the subscriptions of subs that didn't expire
+// yet but end within days of now, the ones ending first first
+func expiringSubscriptions(subs []rhsm.Subscription, days int, now time.Time) []expiringSubscription {
+	var expiring []expiringSubscription
+	for _, s := range subs {
+		if s.EndDate.IsZero() || !s.EndDate.After(now) {
+			continue
+		}
+		remaining := int(math.Floor(s.EndDate.Sub(now).Hours() / 24))
+		if remaining > days {
+			continue
+		}
+		expiring = append(expiring, expiringSubscription{Subscription: s, DaysRemaining: remaining, Remaining: remainingQuantity(s)})
+	}
+	slices.SortStableFunc(expiring, func(a, b expiringSubscription) int {
+		return a.EndDate.Compare(b.EndDate)
+	})
+	return expiring
+}
+
+// remainingQuantity returns the unconsumed quantity of the pools of s, or the
+// quantity of s if it has no pools
+func remainingQuantity(s rhsm.Subscription) string {
+	if len(s.Pools) == 0 {
+		return s.Quantity
+	}
+	remaining := 0
+	for _, p := range s.Pools {
+		if p.Quantity < 0 {
+			return "Unlimited"
+		}
+		remaining += max(p.Quantity-p.Consumed, 0)
+	}
+	return strconv.Itoa(remaining)
+}
+
+// notificationTitle is the one-line summary of a notification
+func notificationTitle(expiring []expiringSubscription) string {
+	return fmt.Sprintf("%d Red Hat subscription(s) expiring within %d days", len(expiring), notifyDays)
+}
+
+// notificationLine describes one expiring subscription
+func notificationLine(s expiringSubscription) string {
+	return fmt.Sprintf("ends %s (%d days), %s remaining", s.EndDate.Format("2006-01-02"), s.DaysRemaining, s.Remaining)
+}
+
+// subscriptionDisplayName names a subscription in notifications
+func subscriptionDisplayName(s expiringSubscription) string {
+	name := fmt.Sprintf("%s (%s, #%s)", s.SubscriptionName, s.SKU, s.SubscriptionNumber)
+	if s.Account != "" {
+		name += " [" + s.Account + "]"
+	}
+	return name
+}
+
+// runNotify sends the subscriptions of the last fetch expiring within
+// -notify.days to all configured notifiers. Nothing is sent if none expire.
+func runNotify(ctx context.Context) error {
+	if !ready.Load() {
+		return errors.New("no successful fetch yet")
+	}
+
+	updateMu.Lock()
+	expiring := expiringSubscriptions(lastSubscriptions, notifyDays, currentTime())
+	updateMu.Unlock()
+	if len(expiring) == 0 {
+		slog.Debug("No subscriptions expiring, skipping notifications", "days", notifyDays)
+		return nil
+	}
+
+	var errs []error
+	for _, n := range notifiers {
+		if !n.enabled() {
+			continue
+		}
+		if err := n.send(ctx, expiring); err != nil {
+			NotificationErrorsCounter.WithLabelValues(n.name).Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", n.name, err))
+			continue
+		}
+		NotificationsCounter.WithLabelValues(n.name).Inc()
+		slog.Info("Sent notification", "notifier", n.name, "expiring", len(expiring))
+	}
+	return errors.Join(errs...)
+}
+
+// postJSON posts payload as json to an incoming webhook
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The default client has no timeout, a hanging webhook would block the
+	// notifications forever
+	_, err = rhsm.DoOnce(&http.Client{Timeout: httpTimeout}, req)
+	return err
+}
+
+// sendSlack posts the expiring subscriptions to a Slack incoming webhook
+func sendSlack(ctx context.Context, expiring []expiringSubscription) error {
+	title := notificationTitle(expiring)
+	var lines []string
+	for _, s := range expiring {
+		lines = append(lines, fmt.Sprintf("• *%s*: %s", slackEscape(subscriptionDisplayName(s)), notificationLine(s)))
+	}
+	return postJSON(ctx, notifySlackURL, map[string]interface{}{
+		"text": title,
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "header",
+				"text": map[string]string{"type": "plain_text", "text": title},
+			},
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": strings.Join(lines, "\n")},
+			},
+		},
+	})
+}
+
+// slackEscape escapes the control characters of Slack mrkdwn
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// sendTeams posts the expiring subscriptions as an Adaptive Card to a
+// Microsoft Teams incoming webhook
+func sendTeams(ctx context.Context, expiring []expiringSubscription) error {
+	var facts []map[string]string
+	for _, s := range expiring {
+		facts = append(facts, map[string]string{"title": subscriptionDisplayName(s), "value": notificationLine(s)})
+	}
+	return postJSON(ctx, notifyTeamsURL, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body": []interface{}{
+						map[string]interface{}{"type": "TextBlock", "text": notificationTitle(expiring), "weight": "Bolder", "size": "Medium", "wrap": true},
+						map[string]interface{}{"type": "FactSet", "facts": facts},
+					},
+				},
+			},
+		},
+	})
+}
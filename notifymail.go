@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/version"
+)
+
+var (
+	notifySMTPAddress  string
+	notifySMTPUsername string
+	notifySMTPPassword string
+	notifySMTPFrom     string
+	notifyEmailTo      listFlag
+)
+
+func init() {
+	notifiers = append(notifiers, notifier{
+		name:    "email",
+		enabled: func() bool { return notifySMTPAddress != "" },
+		send:    sendEmail,
+	})
+}
+
+// sendEmail mails the expiring subscriptions as a plain text digest to
+// -notify.email-to. The connection is upgraded via STARTTLS if the server
+// supports it.
+func sendEmail(ctx context.Context, expiring []expiringSubscription) error {
+	var auth smtp.Auth
+	if notifySMTPUsername != "" {
+		host, _, err := net.SplitHostPort(notifySMTPAddress)
+		if err != nil {
+			return fmt.Errorf("invalid -notify.smtp.address: %w", err)
+		}
+		auth = smtp.PlainAuth("", notifySMTPUsername, notifySMTPPassword, host)
+	}
+
+	to := notifyEmailTo.values()
+	if err := smtp.SendMail(notifySMTPAddress, auth, notifySMTPFrom, to, digestMessage(expiring, to, currentTime())); err != nil {
+		return fmt.Errorf("failed to send mail via %s: %w", notifySMTPAddress, err)
+	}
+	return nil
+}
+
+// digestMessage renders the mail with a table of the expiring subscriptions
+func digestMessage(expiring []expiringSubscription, to []string, now time.Time) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s:\n\n", notificationTitle(expiring))
+	tw := tabwriter.NewWriter(&body, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "END\tDAYS\tREMAINING\tSKU\tNUMBER\tACCOUNT\tNAME")
+	for _, s := range expiring {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.EndDate.Format("2006-01-02"), s.DaysRemaining, s.Remaining, s.SKU, s.SubscriptionNumber, s.Account, s.SubscriptionName)
+	}
+	tw.Flush()
+	fmt.Fprintf(&body, "\nSent by redhat-subscription-exporter %s.\n", version.Version)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", notifySMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notificationTitle(expiring)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return msg.Bytes()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var CollectorDisabledGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+	Name: "redhat_collector_disabled",
+	Help: "Set to 1 with the reason when an enabled optional collector was disabled because the token can't access its endpoint.",
+},
+	[]string{"collector", "reason"})
+
+// optionalCollector fetches an additional API endpoint when enabled. Its
+// endpoint is probed once when the fetch loop starts and the collector is
+// disabled if the token can't access it, instead of failing every cycle.
+type optionalCollector struct {
+	name string
+	// path is probed relative to the API base URL, e.g. "systems"
+	path    string
+	enabled *bool
+	// registry holds the metric families of the collector, selectable with
+	// ?collect[]=<name>
+	registry *prometheus.Registry
+	// collect fetches the endpoint and updates the metrics
+	collect func(ctx context.Context, client *rhsm.Client) error
+	// disabled is set by the permission probe
+	disabled atomic.Bool
+}
+
+// optionalCollectors are registered by the optional collectors
+var optionalCollectors []*optionalCollector
+
+// active reports whether the collector is enabled and was not disabled by
+// the permission probe
+func (c *optionalCollector) active() bool {
+	return *c.enabled && !c.disabled.Load()
+}
+
+// runOptionalCollectors runs the active optional collectors after the
+// subscriptions were fetched. A failing collector keeps its last metrics and
+// doesn't fail the fetch cycle.
+func runOptionalCollectors(ctx context.Context, a account, apiUrl string) {
+	for _, c := range optionalCollectors {
+		if !c.active() {
+			continue
+		}
+		// Every collector has its own -fetch.timeout
+		lastHeartbeat.Store(time.Now().UnixNano())
+		collectCtx, span := tracer.Start(ctx, "collect "+c.name)
+		err := runCollector(c.name, func() error {
+			fetchCtx, cancel := withFetchTimeout(collectCtx)
+			defer cancel()
+			return c.collect(fetchCtx, apiClient(a.client, apiUrl, a.Name))
+		})
+		endSpan(span, err)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Error running collector", "collector", c.name, "err", err)
+		}
+	}
+}
+
+// apiBaseURL derives the API base URL from the subscriptions URL
+func apiBaseURL(apiUrl string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(apiUrl, "/"), "/subscriptions")
+}
+
+// probeOptionalCollectors requests one item of the endpoint of every enabled
+// optional collector and disables those the token is not allowed or able to
+// access. Transient failures leave the collector enabled.
+func probeOptionalCollectors(ctx context.Context, client *http.Client, apiUrl string) {
+	for _, c := range optionalCollectors {
+		CollectorDisabledGauge.DeletePartialMatch(prometheus.Labels{"collector": c.name})
+		c.disabled.Store(false)
+		if !*c.enabled {
+			continue
+		}
+
+		url := fmt.Sprintf("%s/%s?limit=1", apiBaseURL(apiUrl), c.path)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			continue
+		}
+		_, err = rhsm.DoOnce(client, req)
+
+		var statusErr *rhsm.StatusError
+		if !errors.As(err, &statusErr) {
+			continue
+		}
+		reason := ""
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized:
+			reason = "unauthorized"
+		case http.StatusForbidden:
+			reason = "forbidden"
+		case http.StatusNotFound:
+			reason = "not_found"
+		}
+		if reason == "" {
+			continue
+		}
+
+		slog.Warn("Disabling collector, the token can't access its endpoint", "collector", c.name, "url", url, "reason", reason, "err", err)
+		c.disabled.Store(true)
+		CollectorDisabledGauge.WithLabelValues(c.name, reason).Set(1)
+	}
+}
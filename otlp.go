@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	otelprom "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+var otlpEnabled bool
+
+// startOTLP periodically exports the subscription metrics to an OpenTelemetry
+// collector. Endpoint, protocol, headers and interval are configured via the
+// standard OTEL_EXPORTER_OTLP_* and OTEL_METRIC_EXPORT_* env vars.
+func startOTLP(ctx context.Context) (func(context.Context) error, error) {
+	protocol := otlpProtocol("METRICS")
+
+	var exporter sdkmetric.Exporter
+	var err error
+	switch protocol {
+	case "grpc":
+		exporter, err = otlpmetricgrpc.New(ctx)
+	case "", "http/protobuf":
+		exporter, err = otlpmetrichttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, must be grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := otlpResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := otelprom.NewMetricProducer(otelprom.WithGatherer(labeledGatherer(subscriptionsRegistry)))
+	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithProducer(producer))
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+
+	return provider.Shutdown, nil
+}
+
+// otlpProtocol returns the OTLP protocol of a signal (METRICS or TRACES) from
+// OTEL_EXPORTER_OTLP_<signal>_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL
+func otlpProtocol(signal string) string {
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_" + signal + "_PROTOCOL"); protocol != "" {
+		return protocol
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+}
+
+// otlpResource describes the exporter, OTEL_RESOURCE_ATTRIBUTES and
+// OTEL_SERVICE_NAME are applied on top
+func otlpResource(ctx context.Context) (*resource.Resource, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "redhat-subscription-exporter")),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP resource: %w", err)
+	}
+	return res, nil
+}
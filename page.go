@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// pageCriticalDays and pageWarningDays are the days until the end of a
+	// subscription from which it is highlighted on the subscriptions page
+	pageCriticalDays = 30
+	pageWarningDays  = 90
+)
+
+// pageRow is a subscription on the subscriptions page
+type pageRow struct {
+	Number, Name, SKU, Contract, Account, Status, Quantity string
+	Start, End, DaysRemaining                              string
+	// Class is expired, critical, warning or empty
+	Class string
+	// days is the number of days remaining, math.MaxInt without an end date
+	days int
+}
+
+var subscriptionsPage = template.Must(template.New("subscriptions").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Red Hat Subscriptions</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #eee; }
+td.num { text-align: right; }
+tr.expired { background: #f4b6b6; }
+tr.critical { background: #f9d6a5; }
+tr.warning { background: #fbf0b0; }
+</style>
+</head>
+<body>
+<h1>Red Hat Subscriptions</h1>
+<p>{{len .Rows}} subscriptions, fetched {{.Fetched}}. Highlighted: expired, ending within {{.CriticalDays}} and {{.WarningDays}} days.</p>
+<table>
+<tr><th>Number</th><th>Name</th><th>SKU</th><th>Contract</th><th>Account</th><th>Status</th><th>Quantity</th><th>Start</th><th>End</th><th>Days remaining</th></tr>
+{{range .Rows}}<tr class="{{.Class}}"><td>{{.Number}}</td><td>{{.Name}}</td><td>{{.SKU}}</td><td>{{.Contract}}</td><td>{{.Account}}</td><td>{{.Status}}</td><td class="num">{{.Quantity}}</td><td>{{.Start}}</td><td>{{.End}}</td><td class="num">{{.DaysRemaining}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// subscriptionsPageHandler renders the subscriptions of the last fetch as an
+// HTML table, the ones ending first on top. It takes the filters of
+// /api/v1/subscriptions.
+func subscriptionsPageHandler(w http.ResponseWriter, r *http.Request) {
+	now := currentTime()
+	updateMu.Lock()
+	fetched := "never"
+	if !lastDataTime.IsZero() {
+		fetched = lastDataTime.UTC().Format(time.RFC3339)
+	}
+	updateMu.Unlock()
+
+	var rows []pageRow
+	for _, s := range querySubscriptions(r.URL.Query()) {
+		days := math.MaxInt
+		if !s.EndDate.IsZero() {
+			days = int(math.Floor(s.EndDate.Sub(now).Hours() / 24))
+		}
+		row := pageRow{
+			Number:   s.SubscriptionNumber,
+			Name:     s.SubscriptionName,
+			SKU:      s.SKU,
+			Contract: s.ContractNumber,
+			Account:  s.Account,
+			Status:   s.Status,
+			Quantity: s.Quantity,
+			Start:    formatPageDate(s.StartDate),
+			End:      formatPageDate(s.EndDate),
+			days:     days,
+		}
+		if days != math.MaxInt {
+			row.DaysRemaining = strconv.Itoa(days)
+		}
+		switch {
+		case days < 0:
+			row.Class = "expired"
+		case days <= pageCriticalDays:
+			row.Class = "critical"
+		case days <= pageWarningDays:
+			row.Class = "warning"
+		}
+		rows = append(rows, row)
+	}
+	slices.SortStableFunc(rows, func(a, b pageRow) int {
+		return cmp.Compare(a.days, b.days)
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := subscriptionsPage.Execute(w, map[string]interface{}{
+		"Rows":         rows,
+		"Fetched":      fetched,
+		"CriticalDays": pageCriticalDays,
+		"WarningDays":  pageWarningDays,
+	})
+	if err != nil {
+		slog.Error("Error rendering subscriptions page", "err", err)
+	}
+}
+
+// formatPageDate formats a date for the subscriptions page, empty if unknown
+func formatPageDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var PaginationMismatchesCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "redhat_subscription_pagination_mismatches_total",
+	Help: "Total number of subscription pages and fetches not matching the count and offset reported in the pagination of the API.",
+},
+	[]string{"kind"})
+
+// The mismatch counters are initialized so they are exported before the
+// first mismatch
+func init() {
+	for _, kind := range rhsm.PaginationMismatchKinds {
+		PaginationMismatchesCounter.WithLabelValues(kind)
+	}
+}
+
+// countPaginationMismatch counts a mismatch of the pagination
+func countPaginationMismatch(kind string) {
+	PaginationMismatchesCounter.WithLabelValues(kind).Inc()
+}
@@ -0,0 +1,185 @@
+// Package candlepin fetches the pools of an owner (organization) from a
+// Candlepin API, like the one Red Hat Satellite serves at /rhsm, and converts
+// them into subscriptions. Disconnected environments can export their
+// subscriptions this way without reaching the Red Hat APIs.
+package candlepin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// DefaultPageSize is the number of pools requested per page
+const DefaultPageSize = 100
+
+// Pool is a Candlepin pool
+type Pool struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	ProductID      string    `json:"productId"`
+	ProductName    string    `json:"productName"`
+	ContractNumber string    `json:"contractNumber"`
+	SubscriptionID string    `json:"subscriptionId"`
+	AccountNumber  string    `json:"accountNumber"`
+	Quantity       int64     `json:"quantity"`
+	Consumed       int64     `json:"consumed"`
+	StartDate      time.Time `json:"startDate"`
+	EndDate        time.Time `json:"endDate"`
+}
+
+// Client fetches pools from Candlepin. Authenticate with Username and
+// Password or with a client certificate in the transport of HTTPClient.
+type Client struct {
+	HTTPClient *http.Client
+	// URL is the base URL of the API, e.g. https://satellite.example.com/rhsm
+	URL string
+	// Owner is the key of the owner (the Satellite organization label)
+	Owner    string
+	Username string
+	Password string
+	// PageSize is the number of pools requested per page, DefaultPageSize
+	// if 0
+	PageSize int
+	Retry    rhsm.RetryPolicy
+	// Now returns the time the statuses are derived for, time.Now if nil
+	Now func() time.Time
+}
+
+// ownerURL returns the URL of the owner
+func (c *Client) ownerURL() string {
+	return strings.TrimSuffix(c.URL, "/") + "/owners/" + url.PathEscape(c.Owner)
+}
+
+// get requests url with the credentials of the client and retries transient
+// failures
+func (c *Client) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.Retry.Do(c.HTTPClient, req)
+}
+
+// SCA reports whether the owner is in Simple Content Access mode, which
+// Candlepin calls the org_environment content access mode
+func (c *Client) SCA(ctx context.Context) (bool, error) {
+	body, err := c.get(ctx, c.ownerURL())
+	if err != nil {
+		return false, err
+	}
+	var owner struct {
+		ContentAccessMode string `json:"contentAccessMode"`
+	}
+	if err := json.Unmarshal(body, &owner); err != nil {
+		return false, fmt.Errorf("decode failed: %w", err)
+	}
+	return owner.ContentAccessMode == "org_environment", nil
+}
+
+// FetchPools fetches all pools of the owner
+func (c *Client) FetchPools(ctx context.Context) ([]Pool, error) {
+	perPage := c.PageSize
+	if perPage <= 0 {
+		perPage = DefaultPageSize
+	}
+	base := c.ownerURL() + "/pools"
+
+	var pools []Pool
+	for page := 1; ; page++ {
+		body, err := c.get(ctx, fmt.Sprintf("%s?page=%d&per_page=%d&order=asc&sort_by=id", base, page, perPage))
+		if err != nil {
+			return nil, err
+		}
+		var result []Pool
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("decode failed: %w", err)
+		}
+		pools = append(pools, result...)
+		if len(result) < perPage {
+			return pools, nil
+		}
+	}
+}
+
+// FetchAll fetches the pools of the owner and converts them into
+// subscriptions
+func (c *Client) FetchAll(ctx context.Context) ([]rhsm.Subscription, error) {
+	pools, err := c.FetchPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+	return Subscriptions(pools, now), nil
+}
+
+// Subscriptions groups pools by their upstream subscription. The quantity of
+// a subscription is the quantity of its NORMAL pools, derived pools are
+// listed as pools only. -1 (unlimited) becomes "Unlimited" like in the
+// Red Hat API. The status is derived from the dates relative to now.
+func Subscriptions(pools []Pool, now time.Time) []rhsm.Subscription {
+	index := map[string]int{}
+	quantities := map[string]int64{}
+	unlimited := map[string]bool{}
+	var subs []rhsm.Subscription
+
+	for _, p := range pools {
+		number := p.SubscriptionID
+		if number == "" {
+			number = p.ID
+		}
+		i, ok := index[number]
+		if !ok {
+			i = len(subs)
+			index[number] = i
+			subs = append(subs, rhsm.Subscription{
+				ContractNumber:     p.ContractNumber,
+				SubscriptionNumber: number,
+				SubscriptionName:   p.ProductName,
+				SKU:                p.ProductID,
+				StartDate:          p.StartDate,
+				EndDate:            p.EndDate,
+				Status:             rhsm.Status(p.StartDate, p.EndDate, now),
+			})
+		}
+		if p.Type == "NORMAL" || p.Type == "" {
+			if p.Quantity < 0 {
+				unlimited[number] = true
+			} else {
+				quantities[number] += p.Quantity
+			}
+		}
+		subs[i].Pools = append(subs[i].Pools, rhsm.Pool{
+			ID:       p.ID,
+			Type:     p.Type,
+			Quantity: int(p.Quantity),
+			Consumed: int(p.Consumed),
+		})
+	}
+
+	for i := range subs {
+		number := subs[i].SubscriptionNumber
+		if unlimited[number] {
+			subs[i].Quantity = "Unlimited"
+		} else {
+			subs[i].Quantity = strconv.FormatInt(quantities[number], 10)
+		}
+	}
+	slices.SortFunc(subs, func(a, b rhsm.Subscription) int { return strings.Compare(a.SubscriptionNumber, b.SubscriptionNumber) })
+	return subs
+}
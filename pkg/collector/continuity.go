@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"math"
+	"slices"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// entitlementLine identifies subscriptions renewing each other
+type entitlementLine struct {
+	Account        string
+	SKU            string
+	ContractNumber string
+}
+
+// updateEntitlementLines groups subscriptions into entitlement lines
+// and exports the coverage gaps between a subscription and its renewal
+func (m *Metrics) updateEntitlementLines(subs []rhsm.Subscription) {
+	lines := map[entitlementLine][]rhsm.Subscription{}
+	for _, s := range subs {
+		line := entitlementLine{Account: s.Account, SKU: s.SKU, ContractNumber: s.ContractNumber}
+		lines[line] = append(lines[line], s)
+	}
+
+	m.EntitlementLineSubscriptionsGauge.Reset()
+	m.EntitlementLineGapDaysGauge.Reset()
+	m.EntitlementLineMaxGapDaysGauge.Reset()
+
+	for line, members := range lines {
+		m.EntitlementLineSubscriptionsGauge.WithLabelValues(line.Account, line.SKU, line.ContractNumber).Set(float64(len(members)))
+		if len(members) < 2 {
+			continue
+		}
+
+		slices.SortFunc(members, func(a, b rhsm.Subscription) int { return a.StartDate.Compare(b.StartDate) })
+		maxGap := math.Inf(-1)
+		for i := 0; i < len(members)-1; i++ {
+			gap := math.Floor(members[i+1].StartDate.Sub(members[i].EndDate).Hours() / 24)
+			maxGap = max(maxGap, gap)
+			m.EntitlementLineGapDaysGauge.WithLabelValues(line.Account, line.SKU, line.ContractNumber, members[i].SubscriptionNumber).Set(gap)
+		}
+		m.EntitlementLineMaxGapDaysGauge.WithLabelValues(line.Account, line.SKU, line.ContractNumber).Set(maxGap)
+	}
+}
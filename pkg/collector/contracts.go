@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// contract identifies the subscriptions of a contract
+type contract struct {
+	Account        string
+	ContractNumber string
+}
+
+// updateContracts exports the number of subscriptions of every contract and
+// the earliest end date among its active and future subscriptions, the next
+// renewal of the contract. Subscriptions without a contract number are left
+// out.
+func (m *Metrics) updateContracts(subs []rhsm.Subscription, now time.Time) {
+	counts := map[contract]int{}
+	earliest := map[contract]time.Time{}
+	for _, s := range subs {
+		if s.ContractNumber == "" {
+			continue
+		}
+		key := contract{Account: s.Account, ContractNumber: s.ContractNumber}
+		counts[key]++
+		if s.EndDate.After(now) && (earliest[key].IsZero() || s.EndDate.Before(earliest[key])) {
+			earliest[key] = s.EndDate
+		}
+	}
+
+	m.ContractSubscriptionsGauge.Reset()
+	m.ContractEarliestEndGauge.Reset()
+	for key, count := range counts {
+		m.ContractSubscriptionsGauge.WithLabelValues(key.Account, key.ContractNumber).Set(float64(count))
+		if end, ok := earliest[key]; ok {
+			m.ContractEarliestEndGauge.WithLabelValues(key.Account, key.ContractNumber).Set(float64(end.Unix()))
+		}
+	}
+}
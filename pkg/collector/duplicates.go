@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// MergeDuplicates merges the rows the API returns for the same subscription
+// number (e.g. one per contract or pool) into one subscription, so the
+// metrics don't depend on the order of the rows. The quantities are summed,
+// the earliest start and latest end date are used, the pools are combined
+// and distinct contract numbers are joined with a comma. The status is that
+// of the row ending last. The second return value is the number of rows
+// merged into others.
+func MergeDuplicates(subs []rhsm.Subscription) ([]rhsm.Subscription, int) {
+	index := make(map[string]int, len(subs))
+	var groups [][]rhsm.Subscription
+	for _, s := range subs {
+		i, ok := index[s.SubscriptionNumber]
+		if !ok {
+			i = len(groups)
+			index[s.SubscriptionNumber] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], s)
+	}
+	if len(groups) == len(subs) {
+		return subs, 0
+	}
+
+	merged := make([]rhsm.Subscription, 0, len(groups))
+	for _, rows := range groups {
+		merged = append(merged, mergeRows(rows))
+	}
+	return merged, len(subs) - len(groups)
+}
+
+// mergeRows merges the rows of one subscription number
+func mergeRows(rows []rhsm.Subscription) rhsm.Subscription {
+	if len(rows) == 1 {
+		return rows[0]
+	}
+	slices.SortStableFunc(rows, func(a, b rhsm.Subscription) int {
+		if c := a.StartDate.Compare(b.StartDate); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ContractNumber, b.ContractNumber)
+	})
+
+	s := rows[0]
+	s.Pools = nil
+	var contracts []string
+	var quantity float64
+	quantityValid := true
+	last := rows[0]
+	for _, r := range rows {
+		if r.ContractNumber != "" && !slices.Contains(contracts, r.ContractNumber) {
+			contracts = append(contracts, r.ContractNumber)
+		}
+		if !r.StartDate.IsZero() && (s.StartDate.IsZero() || r.StartDate.Before(s.StartDate)) {
+			s.StartDate = r.StartDate
+		}
+		if r.EndDate.After(s.EndDate) {
+			s.EndDate = r.EndDate
+		}
+		if !r.EndDate.Before(last.EndDate) {
+			last = r
+		}
+		for _, p := range r.Pools {
+			if p.ID == "" || !slices.ContainsFunc(s.Pools, func(q rhsm.Pool) bool { return q.ID == p.ID }) {
+				s.Pools = append(s.Pools, p)
+			}
+		}
+
+		// An Unlimited or unparsable quantity wins, so it is handled like
+		// for a single row
+		if !quantityValid {
+			continue
+		}
+		q, err := strconv.ParseFloat(r.Quantity, 64)
+		if err != nil {
+			s.Quantity = r.Quantity
+			quantityValid = false
+			continue
+		}
+		quantity += q
+	}
+	if quantityValid {
+		s.Quantity = strconv.FormatFloat(quantity, 'f', -1, 64)
+	}
+	slices.Sort(contracts)
+	s.ContractNumber = strings.Join(contracts, ",")
+	s.Status = last.Status
+	return s
+}
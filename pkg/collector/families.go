@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// OtherProductFamily is the product family of subscriptions matching no
+// mapping
+const OtherProductFamily = "Other"
+
+// DefaultProductFamilies maps SKUs whose name doesn't tell the product to
+// their product family
+var DefaultProductFamilies = map[string]string{
+	// Red Hat Developer Subscription for Individuals
+	"RH00798": "RHEL",
+}
+
+// productFamilyKeywords map words of subscription names to product families.
+// They are matched in order, the first match wins, so more specific names
+// come first.
+var productFamilyKeywords = []struct {
+	keyword, family string
+}{
+	{"openshift data foundation", "Storage"},
+	{"openshift container storage", "Storage"},
+	{"ceph", "Storage"},
+	{"gluster", "Storage"},
+	{"openshift", "OpenShift"},
+	{"ansible", "Ansible"},
+	{"satellite", "Satellite"},
+	{"smart management", "Satellite"},
+	{"openstack", "OpenStack"},
+	{"virtualization", "Virtualization"},
+	{"jboss", "Middleware"},
+	{"middleware", "Middleware"},
+	{"runtimes", "Middleware"},
+	{"amq", "Middleware"},
+	{"fuse", "Middleware"},
+	{"3scale", "Middleware"},
+	{"quay", "Quay"},
+	{"advanced cluster", "OpenShift"},
+	{"enterprise linux", "RHEL"},
+	{"rhel", "RHEL"},
+	{"developer subscription", "RHEL"},
+}
+
+// ProductFamily returns the product family of a subscription for the
+// product_family label: the ProductFamilies override of its SKU, then
+// DefaultProductFamilies, then the first product named in the subscription
+// name, OtherProductFamily if none matches
+func (o Options) ProductFamily(s rhsm.Subscription) string {
+	if family, ok := lookupProductFamily(o.ProductFamilies, s.SKU); ok {
+		return family
+	}
+	if family, ok := DefaultProductFamilies[s.SKU]; ok {
+		return family
+	}
+	name := strings.ToLower(s.SubscriptionName)
+	for _, k := range productFamilyKeywords {
+		if strings.Contains(name, k.keyword) {
+			return k.family
+		}
+	}
+	return OtherProductFamily
+}
+
+// lookupProductFamily returns the family of sku in families, an exact SKU
+// before the longest matching prefix ending in *
+func lookupProductFamily(families map[string]string, sku string) (string, bool) {
+	if family, ok := families[sku]; ok {
+		return family, true
+	}
+	var family, longest string
+	found := false
+	for pattern, f := range families {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(sku, prefix) && (!found || len(prefix) > len(longest)) {
+			family, longest, found = f, prefix, true
+		}
+	}
+	return family, found
+}
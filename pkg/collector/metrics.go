@@ -0,0 +1,710 @@
+// Package collector exports Red Hat subscriptions as Prometheus metrics.
+//
+// Metrics are registered in a registry and updated with every fetch:
+//
+//	reg := prometheus.NewRegistry()
+//	m := collector.New(reg, collector.DefaultOptions())
+//	subs, err := rhsm.NewClient(ctx, offlineToken).FetchAll(ctx)
+//	if err == nil {
+//		m.Update(subs)
+//	}
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Options configures how subscriptions are turned into metrics
+type Options struct {
+	// Compat selects the exposed names of renamed metrics: legacy, both or
+	// new. Empty means legacy.
+	Compat string
+	// StaleCycles is the number of updates a vanished subscription is kept
+	// with stale="true" before its series are deleted
+	StaleCycles int
+	// FiscalYearStart is the month (1-12) the fiscal year starts in, used
+	// for the renewal quarters. 0 means January.
+	FiscalYearStart int
+	// RenewalWindow is the time before the end date in which a subscription
+	// is in its renewal window. 0 means DefaultRenewalWindow.
+	RenewalWindow time.Duration
+	// NoCostSKUs are the SKUs of no-cost subscriptions
+	NoCostSKUs []string
+	// IncludeNoCost includes no-cost subscriptions in the aggregates
+	IncludeNoCost bool
+	// UnlimitedQuantity is exported as the quantity of subscriptions with
+	// an Unlimited quantity, e.g. -1 or +Inf. 0 means -1. Unlimited
+	// quantities aren't added to the aggregates.
+	UnlimitedQuantity float64
+	// CapacityPoolTypes are the pool types counted in redhat_capacity_total,
+	// DefaultCapacityPoolTypes if nil
+	CapacityPoolTypes []string
+	// CountingModes maps SKUs to their counting mode, see
+	// CountingModeDivisors. SKUs not listed count instances.
+	CountingModes map[string]string
+	// ProductFamilies maps SKUs, or SKU prefixes ending in *, to the
+	// product_family label, overriding the built-in mapping of
+	// ProductFamily
+	ProductFamilies map[string]string
+	// Accounts always get aggregates, even without subscriptions. The
+	// accounts of the subscriptions are added.
+	Accounts []string
+	// SCAAccounts are the accounts in Simple Content Access mode, their
+	// subscriptions are exported with sca="true"
+	SCAAccounts []string
+	// InfoLabels are the subscription fields exported as labels of
+	// redhat_subscription_info, see InfoLabelFields. DefaultInfoLabels if
+	// nil. subscriptionNumber and the product_family, no_cost, sca and stale
+	// annotations are always exported. Only the labels given to New are
+	// used.
+	InfoLabels []string
+	// Attributes exports the service level, usage and role of the
+	// subscriptions as redhat_subscription_attributes
+	Attributes bool
+	// SCAConsumption is keep or drop. With drop the consumed units of pools
+	// of SCA accounts aren't exported, since SCA doesn't consume
+	// entitlements. Empty means keep.
+	SCAConsumption string
+	// Now returns the time the derived metrics are computed for, time.Now
+	// if nil
+	Now func() time.Time
+}
+
+var (
+	// DefaultNoCostSKUs are the SKUs of the no-cost Red Hat Developer program
+	DefaultNoCostSKUs = []string{"RH00798"}
+	// DefaultCapacityPoolTypes are the pool types counted as primary
+	// capacity, derived and bonus pools would double-count virtual
+	// datacenter entitlements
+	DefaultCapacityPoolTypes = []string{"NORMAL"}
+	// DefaultInfoLabels are the fields exported as info labels by default
+	DefaultInfoLabels = []string{"account", "contractNumber", "subscriptionName", "status", "sku"}
+	// DefaultRenewalWindow is the default renewal window of 90 days
+	DefaultRenewalWindow = 90 * 24 * time.Hour
+)
+
+// InfoLabelFields are the subscription fields that can be exported as info
+// labels
+var InfoLabelFields = map[string]func(s rhsm.Subscription) string{
+	"account":          func(s rhsm.Subscription) string { return s.Account },
+	"contractNumber":   func(s rhsm.Subscription) string { return s.ContractNumber },
+	"subscriptionName": func(s rhsm.Subscription) string { return s.SubscriptionName },
+	"status":           func(s rhsm.Subscription) string { return s.Status },
+	"sku":              func(s rhsm.Subscription) string { return s.SKU },
+	"quantity":         func(s rhsm.Subscription) string { return s.Quantity },
+	"serviceLevel":     func(s rhsm.Subscription) string { return s.ServiceLevel },
+	"usage":            func(s rhsm.Subscription) string { return s.Usage },
+	"role":             func(s rhsm.Subscription) string { return s.Role },
+	"source":           func(s rhsm.Subscription) string { return s.Source },
+}
+
+// infoAnnotations are the info labels that are always exported
+var infoAnnotations = []string{"subscriptionNumber", "product_family", "no_cost", "sca", "stale"}
+
+// DefaultOptions returns the options the exporter uses by default
+func DefaultOptions() Options {
+	return Options{
+		Compat:            "legacy",
+		UnlimitedQuantity: -1,
+		StaleCycles:       3,
+		FiscalYearStart:   1,
+		RenewalWindow:     DefaultRenewalWindow,
+		NoCostSKUs:        DefaultNoCostSKUs,
+		CapacityPoolTypes: DefaultCapacityPoolTypes,
+		Accounts:          []string{""},
+	}
+}
+
+func (o Options) now() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+// infoLabels returns the label names of redhat_subscription_info
+func (o Options) infoLabels() []string {
+	fields := o.InfoLabels
+	if fields == nil {
+		fields = DefaultInfoLabels
+	}
+	var labels []string
+	for _, name := range fields {
+		if _, ok := InfoLabelFields[name]; ok && !slices.Contains(labels, name) {
+			labels = append(labels, name)
+		}
+	}
+	return append(labels, infoAnnotations...)
+}
+
+// Quantity parses the quantity of a subscription, unlimited reports the
+// Unlimited quantity
+func (o Options) Quantity(value string) (quantity float64, unlimited bool, err error) {
+	if strings.EqualFold(value, "Unlimited") {
+		if o.UnlimitedQuantity == 0 {
+			return -1, true, nil
+		}
+		return o.UnlimitedQuantity, true, nil
+	}
+	quantity, err = strconv.ParseFloat(value, 64)
+	return quantity, false, err
+}
+
+func (o Options) renewalWindow() time.Duration {
+	if o.RenewalWindow <= 0 {
+		return DefaultRenewalWindow
+	}
+	return o.RenewalWindow
+}
+
+func (o Options) capacityPoolTypes() []string {
+	if o.CapacityPoolTypes == nil {
+		return DefaultCapacityPoolTypes
+	}
+	return o.CapacityPoolTypes
+}
+
+// accountNames returns the sorted names of the configured accounts and the
+// accounts of subs
+func (o Options) accountNames(subs []rhsm.Subscription) []string {
+	names := slices.Clone(o.Accounts)
+	for _, s := range subs {
+		names = append(names, s.Account)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// accountSKU identifies the aggregates of a SKU in an account
+type accountSKU struct {
+	Account string
+	SKU     string
+}
+
+// Metrics holds the subscription metric families of one registry and sets
+// them from fetched subscriptions
+type Metrics struct {
+	SubscriptionInfoGauge             *prometheus.GaugeVec
+	SubscriptionAttributesGauge       *prometheus.GaugeVec
+	SubscriptionQuantityGauge         *prometheus.GaugeVec
+	SubscriptionStartGauge            *prometheus.GaugeVec
+	SubscriptionEndGauge              *prometheus.GaugeVec
+	SubscriptionStartTimestampGauge   *prometheus.GaugeVec
+	SubscriptionEndTimestampGauge     *prometheus.GaugeVec
+	SubscriptionDaysRemainingGauge    *prometheus.GaugeVec
+	SubscriptionRenewalQuarterGauge   *prometheus.GaugeVec
+	SubscriptionRenewalWindowGauge    *prometheus.GaugeVec
+	SubscriptionActiveGauge           *prometheus.GaugeVec
+	SubscriptionFutureGauge           *prometheus.GaugeVec
+	OwnedQuantityGauge                *prometheus.GaugeVec
+	SKUQuantityTotalGauge             *prometheus.GaugeVec
+	ExpiredGauge                      *prometheus.GaugeVec
+	PoolCapacityUnitsGauge            *prometheus.GaugeVec
+	PoolConsumedUnitsGauge            *prometheus.GaugeVec
+	PoolUtilizationGauge              *prometheus.GaugeVec
+	CapacityTotalGauge                *prometheus.GaugeVec
+	SKUCoverageUntilGauge             *prometheus.GaugeVec
+	EntitlementLineSubscriptionsGauge *prometheus.GaugeVec
+	EntitlementLineGapDaysGauge       *prometheus.GaugeVec
+	EntitlementLineMaxGapDaysGauge    *prometheus.GaugeVec
+	ContractSubscriptionsGauge        *prometheus.GaugeVec
+	ContractEarliestEndGauge          *prometheus.GaugeVec
+	ParseErrorsCounter                *prometheus.CounterVec
+	DuplicateRowsGauge                prometheus.Gauge
+
+	mu   sync.Mutex
+	opts Options
+	// tracked is only accessed while holding mu
+	tracked map[string]*trackedSubscription
+	// parseErrors are the bad values of the last update, each is counted
+	// once while it stays in the fetched rows
+	parseErrors map[parseErrorKey]bool
+}
+
+// parseErrorKey identifies a value of a subscription that couldn't be parsed
+type parseErrorKey struct {
+	reason string
+	number string
+}
+
+// ParseErrorReasons are the reasons of redhat_subscription_parse_errors_total
+var ParseErrorReasons = []string{"quantity", "start_date", "end_date"}
+
+// New creates the subscription metric families in reg
+func New(reg prometheus.Registerer, opts Options) *Metrics {
+	f := promauto.With(reg)
+	m := &Metrics{
+		opts: opts,
+		SubscriptionInfoGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_info",
+			Help: "Contains info about subscriptions as labels.",
+		},
+			opts.infoLabels()),
+		SubscriptionAttributesGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_attributes",
+			Help: "Contains the service level, usage and system purpose role of subscriptions as labels.",
+		},
+			[]string{"subscriptionNumber", "serviceLevel", "usage", "role"}),
+		SubscriptionQuantityGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_quantity",
+			Help: "Total number of subscriptions.",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionStartGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_start",
+			Help: "Unix timestamp of subscription start date.",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionEndGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_end",
+			Help: "Unix timestamp of subscription end date.",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionStartTimestampGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_start_timestamp_seconds",
+			Help: "Unix timestamp of subscription start date.",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionEndTimestampGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_end_timestamp_seconds",
+			Help: "Unix timestamp of subscription end date.",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionDaysRemainingGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_days_remaining",
+			Help: "Number of days until the subscription ends, negative once it ended.",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionRenewalQuarterGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_renewal_quarter",
+			Help: "Fiscal year and quarter in which the subscription ends, always 1.",
+		},
+			[]string{"subscriptionNumber", "fiscal_year", "quarter"}),
+		SubscriptionRenewalWindowGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_in_renewal_window",
+			Help: "Whether the subscription ends within the renewal window (1) or not (0).",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionActiveGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_active",
+			Help: "Whether the subscription started and didn't end yet (1) or not (0).",
+		},
+			[]string{"subscriptionNumber"}),
+		SubscriptionFutureGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_future",
+			Help: "Whether the start date of the subscription is in the future (1) or not (0).",
+		},
+			[]string{"subscriptionNumber"}),
+		OwnedQuantityGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_owned_quantity",
+			Help: "Sum of the quantity of all subscriptions, excluding no-cost subscriptions by default.",
+		},
+			[]string{"account"}),
+		SKUQuantityTotalGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_sku_quantity_total",
+			Help: "Sum of the quantity of all subscriptions of a SKU, excluding Unlimited quantities and no-cost subscriptions by default.",
+		},
+			[]string{"account", "sku"}),
+		ExpiredGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscriptions_expired_total",
+			Help: "Number of subscriptions whose end date passed.",
+		},
+			[]string{"account"}),
+		PoolCapacityUnitsGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_pool_capacity_units",
+			Help: "Capacity of a pool in licensed units according to the counting mode of the SKU.",
+		},
+			[]string{"subscriptionNumber", "pool", "counting_mode"}),
+		PoolConsumedUnitsGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_pool_consumed_units",
+			Help: "Consumed entitlements of a pool in licensed units according to the counting mode of the SKU. Under Simple Content Access (sca=\"true\" in redhat_subscription_info) systems don't consume entitlements.",
+		},
+			[]string{"subscriptionNumber", "pool", "counting_mode"}),
+		PoolUtilizationGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_subscription_pool_utilization",
+			Help: "Ratio of consumed to available entitlements of a pool, clamped to 0-1. Unlimited pools are 0, pools without quantity are 1 once consumed.",
+		},
+			[]string{"subscriptionNumber", "pool"}),
+		CapacityTotalGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_capacity_total",
+			Help: "Account-level capacity per SKU, summed over primary pools only, without unlimited pools.",
+		},
+			[]string{"account", "sku"}),
+		SKUCoverageUntilGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_sku_coverage_until_timestamp_seconds",
+			Help: "Latest end date among active and future subscriptions of a SKU.",
+		},
+			[]string{"account", "sku"}),
+		EntitlementLineSubscriptionsGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_entitlement_line_subscriptions",
+			Help: "Number of subscriptions of an entitlement line (same SKU and contract).",
+		},
+			[]string{"account", "sku", "contractNumber"}),
+		EntitlementLineGapDaysGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_entitlement_line_gap_days",
+			Help: "Days between the end of a subscription and the start of its renewal, negative for overlaps.",
+		},
+			[]string{"account", "sku", "contractNumber", "subscriptionNumber"}),
+		EntitlementLineMaxGapDaysGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_entitlement_line_max_gap_days",
+			Help: "Largest gap in days between consecutive subscriptions of an entitlement line.",
+		},
+			[]string{"account", "sku", "contractNumber"}),
+		ContractSubscriptionsGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_contract_subscriptions",
+			Help: "Number of subscriptions of a contract.",
+		},
+			[]string{"account", "contractNumber"}),
+		ContractEarliestEndGauge: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "redhat_contract_earliest_end_timestamp_seconds",
+			Help: "Earliest end date among active and future subscriptions of a contract.",
+		},
+			[]string{"account", "contractNumber"}),
+		ParseErrorsCounter: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "redhat_subscription_parse_errors_total",
+			Help: "Total number of subscription values that couldn't be parsed (or were missing) and were left out of the metrics, counted once while a row keeps the bad value.",
+		},
+			[]string{"reason"}),
+		DuplicateRowsGauge: f.NewGauge(prometheus.GaugeOpts{
+			Name: "redhat_subscription_duplicate_rows",
+			Help: "Number of rows of the last update merged into another row with the same subscription number.",
+		}),
+		tracked:     map[string]*trackedSubscription{},
+		parseErrors: map[parseErrorKey]bool{},
+	}
+	for _, reason := range ParseErrorReasons {
+		m.ParseErrorsCounter.WithLabelValues(reason)
+	}
+	return m
+}
+
+// SetOptions replaces the options used by the next Update. The info labels
+// given to New are kept.
+func (m *Metrics) SetOptions(opts Options) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	opts.InfoLabels = m.opts.InfoLabels
+	m.opts = opts
+}
+
+// trackedSubscription remembers the exported info labels of a subscription
+// and for how many cycles it has been missing from the fetched data
+type trackedSubscription struct {
+	info   prometheus.Labels
+	missed int
+	// series are the labels set by the last update in the gauges with
+	// several series per subscription, so they are replaced without
+	// scanning the whole gauge
+	series map[*prometheus.GaugeVec][]prometheus.Labels
+}
+
+// gaugeSeries is a series of a gauge with its value
+type gaugeSeries struct {
+	labels prometheus.Labels
+	value  float64
+}
+
+// setSeries sets the series of the subscription in g and deletes those of
+// the last update that weren't set again
+func (t *trackedSubscription) setSeries(g *prometheus.GaugeVec, series []gaugeSeries) {
+	labels := make([]prometheus.Labels, 0, len(series))
+	for _, s := range series {
+		g.With(s.labels).Set(s.value)
+		labels = append(labels, s.labels)
+	}
+	for _, old := range t.series[g] {
+		if !slices.ContainsFunc(labels, func(l prometheus.Labels) bool { return maps.Equal(l, old) }) {
+			g.Delete(old)
+		}
+	}
+	t.series[g] = labels
+}
+
+// Update sets the gauges from the fetched subscriptions. Rows with the same
+// subscription number are merged, see MergeDuplicates.
+// Subscriptions missing from the fetch are flagged with stale="true" for
+// StaleCycles updates before their series are deleted.
+func (m *Metrics) Update(subs []rhsm.Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subs, duplicates := MergeDuplicates(subs)
+	m.DuplicateRowsGauge.Set(float64(duplicates))
+
+	seen := make(map[string]bool, len(subs))
+	ownedQuantity := map[string]float64{}
+	skuQuantity := map[accountSKU]float64{}
+	expired := map[string]float64{}
+	capacity := map[accountSKU]float64{}
+	coverage := map[accountSKU]time.Time{}
+	now := m.opts.now()
+	parseErrors := map[parseErrorKey]bool{}
+
+	for _, s := range subs {
+		seen[s.SubscriptionNumber] = true
+		quantity, unlimited, err := m.opts.Quantity(s.Quantity)
+		if err != nil {
+			m.parseError(parseErrors, "quantity", s, err)
+		}
+		if s.StartDate.IsZero() {
+			m.parseError(parseErrors, "start_date", s, nil)
+		}
+		if s.EndDate.IsZero() {
+			m.parseError(parseErrors, "end_date", s, nil)
+		}
+
+		if !s.EndDate.IsZero() && !s.EndDate.After(now) {
+			expired[s.Account]++
+		}
+
+		key := accountSKU{Account: s.Account, SKU: s.SKU}
+		if s.EndDate.After(now) && s.EndDate.After(coverage[key]) {
+			coverage[key] = s.EndDate
+		}
+
+		noCost := m.opts.IsNoCost(s.SKU)
+		if !noCost || m.opts.IncludeNoCost {
+			if _, ok := skuQuantity[key]; !ok {
+				skuQuantity[key] = 0
+			}
+			if !unlimited && err == nil {
+				ownedQuantity[s.Account] += quantity
+				skuQuantity[key] += quantity
+			}
+			for _, p := range s.Pools {
+				// Unlimited pools, with a negative quantity, are left out
+				// like unlimited subscription quantities
+				if slices.Contains(m.opts.capacityPoolTypes(), p.Type) {
+					capacity[key] += float64(max(p.Quantity, 0))
+				}
+			}
+		}
+
+		sca := slices.Contains(m.opts.SCAAccounts, s.Account)
+		info := prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber, "product_family": m.opts.ProductFamily(s), "no_cost": strconv.FormatBool(noCost), "sca": strconv.FormatBool(sca), "stale": "false"}
+		for _, name := range m.opts.infoLabels() {
+			if field, ok := InfoLabelFields[name]; ok {
+				info[name] = field(s)
+			}
+		}
+		t := m.setTrackedInfo(s.SubscriptionNumber, info)
+		var attributes []gaugeSeries
+		if m.opts.Attributes {
+			attributes = append(attributes, gaugeSeries{prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber, "serviceLevel": s.ServiceLevel, "usage": s.Usage, "role": s.Role}, 1})
+		}
+		t.setSeries(m.SubscriptionAttributesGauge, attributes)
+		// Values that can't be parsed are left out instead of exporting
+		// bogus ones, the subscription is still exported
+		number := prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber}
+		if err == nil {
+			m.SubscriptionQuantityGauge.With(number).Set(quantity)
+		} else {
+			m.SubscriptionQuantityGauge.Delete(number)
+		}
+		if !s.StartDate.IsZero() {
+			m.setCompatGauge(m.SubscriptionStartGauge, m.SubscriptionStartTimestampGauge, number, float64(s.StartDate.Unix()))
+		} else {
+			m.SubscriptionStartGauge.Delete(number)
+			m.SubscriptionStartTimestampGauge.Delete(number)
+		}
+		if !s.EndDate.IsZero() {
+			m.setCompatGauge(m.SubscriptionEndGauge, m.SubscriptionEndTimestampGauge, number, float64(s.EndDate.Unix()))
+			remaining := s.EndDate.Sub(now)
+			m.SubscriptionDaysRemainingGauge.With(number).Set(math.Floor(remaining.Hours() / 24))
+			m.SubscriptionRenewalWindowGauge.With(number).Set(boolValue(remaining > 0 && remaining <= m.opts.renewalWindow()))
+		} else {
+			m.SubscriptionEndGauge.Delete(number)
+			m.SubscriptionEndTimestampGauge.Delete(number)
+			m.SubscriptionDaysRemainingGauge.Delete(number)
+			m.SubscriptionRenewalWindowGauge.Delete(number)
+		}
+
+		future := s.StartDate.After(now)
+		m.SubscriptionActiveGauge.With(number).Set(boolValue(!future && (s.EndDate.IsZero() || s.EndDate.After(now))))
+		m.SubscriptionFutureGauge.With(number).Set(boolValue(future))
+
+		mode := m.opts.CountingMode(s.SKU)
+		var capacityUnits, consumedUnits, utilization []gaugeSeries
+		for _, p := range s.Pools {
+			labels := prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber, "pool": p.ID, "counting_mode": mode}
+			capacityUnits = append(capacityUnits, gaugeSeries{labels, float64(p.Quantity) / CountingModeDivisors[mode]})
+			if !sca || m.opts.SCAConsumption != "drop" {
+				consumedUnits = append(consumedUnits, gaugeSeries{labels, float64(p.Consumed) / CountingModeDivisors[mode]})
+				utilization = append(utilization, gaugeSeries{prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber, "pool": p.ID}, PoolUtilization(p)})
+			}
+		}
+		t.setSeries(m.PoolCapacityUnitsGauge, capacityUnits)
+		t.setSeries(m.PoolConsumedUnitsGauge, consumedUnits)
+		t.setSeries(m.PoolUtilizationGauge, utilization)
+
+		var renewalQuarter []gaugeSeries
+		if !s.EndDate.IsZero() {
+			year, quarter := fiscalQuarter(s.EndDate, max(m.opts.FiscalYearStart, 1))
+			renewalQuarter = append(renewalQuarter, gaugeSeries{prometheus.Labels{"subscriptionNumber": s.SubscriptionNumber, "fiscal_year": strconv.Itoa(year), "quarter": fmt.Sprintf("Q%d", quarter)}, 1})
+		}
+		t.setSeries(m.SubscriptionRenewalQuarterGauge, renewalQuarter)
+	}
+	m.OwnedQuantityGauge.Reset()
+	for _, account := range m.opts.accountNames(subs) {
+		m.OwnedQuantityGauge.WithLabelValues(account).Set(ownedQuantity[account])
+	}
+	m.ExpiredGauge.Reset()
+	for _, account := range m.opts.accountNames(subs) {
+		m.ExpiredGauge.WithLabelValues(account).Set(expired[account])
+	}
+	m.SKUQuantityTotalGauge.Reset()
+	for key, total := range skuQuantity {
+		m.SKUQuantityTotalGauge.WithLabelValues(key.Account, key.SKU).Set(total)
+	}
+	m.CapacityTotalGauge.Reset()
+	for key, total := range capacity {
+		m.CapacityTotalGauge.WithLabelValues(key.Account, key.SKU).Set(total)
+	}
+	m.SKUCoverageUntilGauge.Reset()
+	for key, until := range coverage {
+		m.SKUCoverageUntilGauge.WithLabelValues(key.Account, key.SKU).Set(float64(until.Unix()))
+	}
+	m.updateEntitlementLines(subs)
+	m.updateContracts(subs, now)
+	m.parseErrors = parseErrors
+
+	for number, t := range m.tracked {
+		if seen[number] {
+			continue
+		}
+		t.missed++
+		if t.missed > m.opts.StaleCycles {
+			m.deleteSubscription(number)
+			continue
+		}
+		info := maps.Clone(t.info)
+		info["stale"] = "true"
+		m.setTrackedInfo(number, info)
+	}
+}
+
+// parseError records a value of s that couldn't be parsed in errs. It is
+// logged and counted only if the previous update didn't have it already, so
+// the counter doesn't grow every cycle for the same bad row.
+func (m *Metrics) parseError(errs map[parseErrorKey]bool, reason string, s rhsm.Subscription, err error) {
+	key := parseErrorKey{reason: reason, number: s.SubscriptionNumber}
+	errs[key] = true
+	if m.parseErrors[key] {
+		return
+	}
+	if err != nil {
+		slog.Warn("Error parsing subscription", "subscription", s.SubscriptionNumber, "reason", reason, "err", err)
+	} else {
+		slog.Warn("Error parsing subscription", "subscription", s.SubscriptionNumber, "reason", reason)
+	}
+	m.ParseErrorsCounter.WithLabelValues(reason).Inc()
+}
+
+// setTrackedInfo exports the info series of a subscription, replacing the
+// previous one if its labels changed, and returns its tracking
+func (m *Metrics) setTrackedInfo(number string, info prometheus.Labels) *trackedSubscription {
+	t, ok := m.tracked[number]
+	if !ok {
+		t = &trackedSubscription{series: map[*prometheus.GaugeVec][]prometheus.Labels{}}
+		m.tracked[number] = t
+	} else if !maps.Equal(t.info, info) {
+		m.SubscriptionInfoGauge.Delete(t.info)
+	}
+	if info["stale"] == "false" {
+		t.missed = 0
+	}
+	t.info = info
+	m.SubscriptionInfoGauge.With(info).Set(1)
+	return t
+}
+
+// deleteSubscription removes all series of a subscription
+func (m *Metrics) deleteSubscription(number string) {
+	t := m.tracked[number]
+	delete(m.tracked, number)
+	m.SubscriptionInfoGauge.Delete(t.info)
+	for g, series := range t.series {
+		for _, labels := range series {
+			g.Delete(labels)
+		}
+	}
+	for _, g := range []*prometheus.GaugeVec{m.SubscriptionQuantityGauge, m.SubscriptionStartGauge, m.SubscriptionEndGauge, m.SubscriptionStartTimestampGauge, m.SubscriptionEndTimestampGauge, m.SubscriptionDaysRemainingGauge, m.SubscriptionRenewalWindowGauge, m.SubscriptionActiveGauge, m.SubscriptionFutureGauge} {
+		g.Delete(prometheus.Labels{"subscriptionNumber": number})
+	}
+}
+
+// boolValue returns 1 for true and 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// PoolUtilization returns the consumed share of the entitlements of p clamped
+// to 0-1. Unlimited pools, with a negative quantity, can't be exhausted.
+func PoolUtilization(p rhsm.Pool) float64 {
+	switch {
+	case p.Quantity < 0 || p.Consumed <= 0:
+		return 0
+	case p.Quantity == 0:
+		return 1
+	}
+	return min(float64(p.Consumed)/float64(p.Quantity), 1)
+}
+
+// setCompatGauge sets the legacy and/or renamed gauge depending on Compat
+func (m *Metrics) setCompatGauge(legacy, renamed *prometheus.GaugeVec, labels prometheus.Labels, value float64) {
+	if m.opts.Compat != "new" {
+		legacy.With(labels).Set(value)
+	}
+	if m.opts.Compat != "legacy" {
+		renamed.With(labels).Set(value)
+	}
+}
+
+// fiscalQuarter returns the fiscal year and quarter (1-4) of t in UTC for a
+// fiscal year starting in startMonth. Fiscal years not starting in January
+// are named after the calendar year they end in.
+func fiscalQuarter(t time.Time, startMonth int) (int, int) {
+	t = t.UTC()
+	month := int(t.Month())
+	quarter := (month-startMonth+12)%12/3 + 1
+	year := t.Year()
+	if startMonth > 1 && month >= startMonth {
+		year++
+	}
+	return year, quarter
+}
+
+// CountingModeDivisors converts pool entitlements into licensed units. A
+// socket-pair subscription shows up as two entitlements in its pool.
+var CountingModeDivisors = map[string]float64{
+	"instance":    1,
+	"socket-pair": 2,
+}
+
+// CountingMode returns the counting mode of sku, defaulting to instance
+func (o Options) CountingMode(sku string) string {
+	if mode, ok := o.CountingModes[sku]; ok {
+		return mode
+	}
+	return "instance"
+}
+
+// IsNoCost reports whether sku belongs to a no-cost subscription
+func (o Options) IsNoCost(sku string) bool {
+	return slices.Contains(o.NoCostSKUs, sku)
+}
@@ -0,0 +1,189 @@
+package collector
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpdateCountsParseErrorsOnce(t *testing.T) {
+	start := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	good := rhsm.Subscription{SubscriptionNumber: "100", Quantity: "1", StartDate: start, EndDate: end}
+	badQuantity := rhsm.Subscription{SubscriptionNumber: "200", Quantity: "many", StartDate: start, EndDate: end}
+	noDates := rhsm.Subscription{SubscriptionNumber: "300", Quantity: "1"}
+
+	// Each step is one Update, want are the counters after it
+	type counts struct{ quantity, startDate, endDate float64 }
+	tests := []struct {
+		name string
+		subs []rhsm.Subscription
+		want counts
+	}{
+		{name: "first fetch", subs: []rhsm.Subscription{good, badQuantity, noDates}, want: counts{1, 1, 1}},
+		{name: "same bad rows", subs: []rhsm.Subscription{good, badQuantity, noDates}, want: counts{1, 1, 1}},
+		{name: "rows fixed", subs: []rhsm.Subscription{good}, want: counts{1, 1, 1}},
+		{name: "bad again", subs: []rhsm.Subscription{good, badQuantity}, want: counts{2, 1, 1}},
+		{name: "another bad row", subs: []rhsm.Subscription{good, badQuantity, {SubscriptionNumber: "400", Quantity: "x"}}, want: counts{3, 2, 2}},
+	}
+
+	m := New(prometheus.NewRegistry(), Options{Now: func() time.Time { return start }})
+	for _, tt := range tests {
+		m.Update(tt.subs)
+		got := counts{
+			quantity:  testutil.ToFloat64(m.ParseErrorsCounter.WithLabelValues("quantity")),
+			startDate: testutil.ToFloat64(m.ParseErrorsCounter.WithLabelValues("start_date")),
+			endDate:   testutil.ToFloat64(m.ParseErrorsCounter.WithLabelValues("end_date")),
+		}
+		if got != tt.want {
+			t.Errorf("%s: parse errors = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateReplacesChangedSeries(t *testing.T) {
+	start := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := rhsm.Subscription{
+		SubscriptionNumber: "100", Quantity: "10", SKU: "RH1", Status: "Active", StartDate: start, EndDate: end,
+		ServiceLevel: "Standard",
+		Pools:        []rhsm.Pool{{ID: "p1", Quantity: 10, Type: "NORMAL"}, {ID: "p2", Quantity: 5, Type: "NORMAL"}},
+	}
+	after := before
+	after.Status = "Renewed"
+	after.ServiceLevel = "Premium"
+	after.Pools = []rhsm.Pool{{ID: "p2", Quantity: 5, Type: "NORMAL"}}
+
+	reg := prometheus.NewRegistry()
+	m := New(reg, Options{Attributes: true, Now: func() time.Time { return start }})
+	m.Update([]rhsm.Subscription{before})
+	m.Update([]rhsm.Subscription{after})
+
+	tests := []struct {
+		name string
+		vec  *prometheus.GaugeVec
+		want int
+	}{
+		{name: "info", vec: m.SubscriptionInfoGauge, want: 1},
+		{name: "attributes", vec: m.SubscriptionAttributesGauge, want: 1},
+		{name: "pool capacity", vec: m.PoolCapacityUnitsGauge, want: 1},
+		{name: "pool consumed", vec: m.PoolConsumedUnitsGauge, want: 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.CollectAndCount(tt.vec); got != tt.want {
+			t.Errorf("%s: %d series, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(m.SubscriptionAttributesGauge.WithLabelValues("100", "Premium", "", "")); got != 1 {
+		t.Errorf("attributes of the update = %v, want 1", got)
+	}
+}
+
+// seriesOf returns the names of the gathered metric families with a series
+// of the subscription number, and the stale label of its info series
+func seriesOf(t *testing.T, reg *prometheus.Registry, number string) (names []string, stale string) {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range metric.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["subscriptionNumber"] != number {
+				continue
+			}
+			if !slices.Contains(names, f.GetName()) {
+				names = append(names, f.GetName())
+			}
+			if f.GetName() == "redhat_subscription_info" {
+				stale = labels["stale"]
+			}
+		}
+	}
+	return names, stale
+}
+
+func TestUpdateStaleSeries(t *testing.T) {
+	start := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	kept := rhsm.Subscription{SubscriptionNumber: "100", Quantity: "1", SKU: "RH1", StartDate: start, EndDate: end}
+	vanishing := rhsm.Subscription{
+		SubscriptionNumber: "200", Quantity: "10", SKU: "RH2", StartDate: start, EndDate: end,
+		ServiceLevel: "Premium", Usage: "Production",
+		Pools: []rhsm.Pool{{ID: "p1", Quantity: 10, Consumed: 4, Type: "NORMAL"}},
+	}
+
+	tests := []struct {
+		name string
+		subs []rhsm.Subscription
+		// wantStale is the stale label of 200, empty once it is deleted
+		wantStale string
+	}{
+		{name: "fetched", subs: []rhsm.Subscription{kept, vanishing}, wantStale: "false"},
+		{name: "missing once", subs: []rhsm.Subscription{kept}, wantStale: "true"},
+		{name: "missing twice", subs: []rhsm.Subscription{kept}, wantStale: "true"},
+		{name: "deleted", subs: []rhsm.Subscription{kept}},
+		{name: "back", subs: []rhsm.Subscription{kept, vanishing}, wantStale: "false"},
+	}
+
+	reg := prometheus.NewRegistry()
+	m := New(reg, Options{StaleCycles: 2, Attributes: true, Now: func() time.Time { return start }})
+	var fetched []string
+	for _, tt := range tests {
+		m.Update(tt.subs)
+		names, stale := seriesOf(t, reg, "200")
+		if stale != tt.wantStale {
+			t.Errorf("%s: stale = %q, want %q", tt.name, stale, tt.wantStale)
+		}
+		switch {
+		case fetched == nil:
+			fetched = names
+			for _, name := range []string{"redhat_subscription_info", "redhat_subscription_attributes", "redhat_subscription_pool_capacity_units", "redhat_subscription_days_remaining"} {
+				if !slices.Contains(names, name) {
+					t.Errorf("%s: no %s series of 200", tt.name, name)
+				}
+			}
+		case tt.wantStale == "":
+			if len(names) > 0 {
+				t.Errorf("%s: series of 200 left in %v", tt.name, names)
+			}
+		case !slices.Equal(names, fetched):
+			t.Errorf("%s: series of 200 in %v, want %v", tt.name, names, fetched)
+		}
+		if names, _ := seriesOf(t, reg, "100"); !slices.Contains(names, "redhat_subscription_info") {
+			t.Errorf("%s: series of 100 deleted", tt.name)
+		}
+	}
+}
+
+func TestCapacityTotalSkipsUnlimitedPools(t *testing.T) {
+	start := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		pools []rhsm.Pool
+		want  float64
+	}{
+		{name: "limited", pools: []rhsm.Pool{{ID: "p1", Quantity: 10, Type: "NORMAL"}, {ID: "p2", Quantity: 5, Type: "NORMAL"}}, want: 15},
+		{name: "unlimited pool", pools: []rhsm.Pool{{ID: "p1", Quantity: 10, Type: "NORMAL"}, {ID: "p2", Quantity: -1, Type: "NORMAL"}}, want: 10},
+		{name: "only unlimited", pools: []rhsm.Pool{{ID: "p1", Quantity: -1, Type: "NORMAL"}}, want: 0},
+		{name: "derived pool", pools: []rhsm.Pool{{ID: "p1", Quantity: 10, Type: "NORMAL"}, {ID: "p2", Quantity: 10, Type: "UNMAPPED_GUEST"}}, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(prometheus.NewRegistry(), Options{Now: func() time.Time { return start }})
+			m.Update([]rhsm.Subscription{{SubscriptionNumber: "100", Account: "acme", SKU: "RH1", Quantity: "10", StartDate: start, EndDate: end, Pools: tt.pools}})
+			if got := testutil.ToFloat64(m.CapacityTotalGauge.WithLabelValues("acme", "RH1")); got != tt.want {
+				t.Errorf("capacity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+// Package entitlement reads the entitlement certificates subscription-manager
+// stores on a registered RHEL host (/etc/pki/entitlement) and converts them
+// into subscriptions, so hosts without any API access can export them.
+package entitlement
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// DefaultDir is where subscription-manager stores the entitlement certificates
+const DefaultDir = "/etc/pki/entitlement"
+
+// orderOID is the Red Hat OID namespace of the order (subscription) fields
+// of version 1 entitlement certificates
+var orderOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 2312, 9, 4}
+
+// Order fields of version 1 certificates, below orderOID
+const (
+	orderName           = 1
+	orderNumber         = 2
+	orderSKU            = 3
+	orderSubscription   = 4
+	orderQuantity       = 5
+	orderStart          = 6
+	orderEnd            = 7
+	orderContract       = 10
+	orderQuantityUsed   = 11
+	orderAccount        = 13
+	entitlementDataType = "ENTITLEMENT DATA"
+)
+
+// Cert is the subscription data of an entitlement certificate
+type Cert struct {
+	// Serial is the serial number of the certificate
+	Serial             string
+	SubscriptionName   string
+	SubscriptionNumber string
+	SKU                string
+	ContractNumber     string
+	AccountNumber      string
+	PoolID             string
+	// Quantity is the quantity of the subscription
+	Quantity string
+	// Consumed is the number of entitlements the host consumes
+	Consumed  int
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// entitlementData is the payload of version 3 certificates
+type entitlementData struct {
+	Quantity     int `json:"quantity"`
+	Subscription struct {
+		SKU  string `json:"sku"`
+		Name string `json:"name"`
+	} `json:"subscription"`
+	Order struct {
+		Number   string          `json:"number"`
+		Quantity json.RawMessage `json:"quantity"`
+		Start    time.Time       `json:"start"`
+		End      time.Time       `json:"end"`
+		Contract string          `json:"contract"`
+		Account  string          `json:"account"`
+	} `json:"order"`
+	Pool struct {
+		ID string `json:"id"`
+	} `json:"pool"`
+}
+
+// ParseCert parses the PEM file of an entitlement certificate. Version 3
+// certificates carry their data in an ENTITLEMENT DATA block, version 1
+// certificates in X.509 extensions.
+func ParseCert(data []byte) (*Cert, error) {
+	var cert *x509.Certificate
+	var payload []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			cert = c
+		case entitlementDataType:
+			payload = block.Bytes
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("no certificate found")
+	}
+
+	c := &Cert{
+		Serial:    cert.SerialNumber.String(),
+		StartDate: cert.NotBefore,
+		EndDate:   cert.NotAfter,
+	}
+	if payload != nil {
+		if err := c.parsePayload(payload); err != nil {
+			return nil, err
+		}
+	} else {
+		c.parseExtensions(cert)
+	}
+	if c.SubscriptionNumber == "" {
+		c.SubscriptionNumber = c.Serial
+	}
+	return c, nil
+}
+
+// parsePayload reads the zlib-compressed json of a version 3 certificate
+func (c *Cert) parsePayload(payload []byte) error {
+	r, err := zlib.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to decompress entitlement data: %w", err)
+	}
+	defer r.Close()
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to decompress entitlement data: %w", err)
+	}
+
+	var d entitlementData
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return fmt.Errorf("failed to decode entitlement data: %w", err)
+	}
+	c.SubscriptionName = d.Subscription.Name
+	c.SKU = d.Subscription.SKU
+	c.SubscriptionNumber = d.Order.Number
+	c.ContractNumber = d.Order.Contract
+	c.AccountNumber = d.Order.Account
+	c.PoolID = d.Pool.ID
+	c.Consumed = d.Quantity
+	// The quantity is a number, or a string in older versions
+	c.Quantity = strings.Trim(string(d.Order.Quantity), `"`)
+	if !d.Order.Start.IsZero() {
+		c.StartDate = d.Order.Start
+	}
+	if !d.Order.End.IsZero() {
+		c.EndDate = d.Order.End
+	}
+	return nil
+}
+
+// parseExtensions reads the order extensions of a version 1 certificate
+func (c *Cert) parseExtensions(cert *x509.Certificate) {
+	for _, ext := range cert.Extensions {
+		id := ext.Id
+		if len(id) != len(orderOID)+1 || !id[:len(orderOID)].Equal(orderOID) {
+			continue
+		}
+		value := extensionString(ext.Value)
+		switch id[len(orderOID)] {
+		case orderName:
+			c.SubscriptionName = value
+		case orderNumber:
+			c.SubscriptionNumber = value
+		case orderSKU:
+			c.SKU = value
+		case orderSubscription:
+			c.PoolID = value
+		case orderQuantity:
+			c.Quantity = value
+		case orderQuantityUsed:
+			c.Consumed, _ = strconv.Atoi(value)
+		case orderContract:
+			c.ContractNumber = value
+		case orderAccount:
+			c.AccountNumber = value
+		case orderStart:
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				c.StartDate = t
+			}
+		case orderEnd:
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				c.EndDate = t
+			}
+		}
+	}
+}
+
+// extensionString decodes an extension value, which is an ASN.1 string in
+// most certificates but plain bytes in some
+func extensionString(value []byte) string {
+	var s string
+	if _, err := asn1.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(value)
+}
+
+// ReadDir parses all entitlement certificates in dir, skipping the *-key.pem
+// keys, and converts them into subscriptions. Certificates of the same
+// subscription are merged, their consumed entitlements are summed.
+func ReadDir(dir string, now time.Time) ([]rhsm.Subscription, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*Cert
+	var errs []error
+	for _, path := range paths {
+		if strings.HasSuffix(path, "-key.pem") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c, err := ParseCert(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return Subscriptions(certs, now), nil
+}
+
+// Subscriptions converts certificates into subscriptions with one pool each,
+// whose consumed count is the number of entitlements this host consumes
+func Subscriptions(certs []*Cert, now time.Time) []rhsm.Subscription {
+	index := map[string]int{}
+	var subs []rhsm.Subscription
+	for _, c := range certs {
+		if i, ok := index[c.SubscriptionNumber]; ok {
+			subs[i].Pools[0].Consumed += c.Consumed
+			continue
+		}
+		quantity, _ := strconv.Atoi(c.Quantity)
+		index[c.SubscriptionNumber] = len(subs)
+		subs = append(subs, rhsm.Subscription{
+			ContractNumber:     c.ContractNumber,
+			SubscriptionNumber: c.SubscriptionNumber,
+			SubscriptionName:   c.SubscriptionName,
+			SKU:                c.SKU,
+			Quantity:           c.Quantity,
+			StartDate:          c.StartDate,
+			EndDate:            c.EndDate,
+			Status:             rhsm.Status(c.StartDate, c.EndDate, now),
+			Pools: []rhsm.Pool{{
+				ID:       c.PoolID,
+				Type:     "NORMAL",
+				Quantity: quantity,
+				Consumed: c.Consumed,
+			}},
+		})
+	}
+	slices.SortFunc(subs, func(a, b rhsm.Subscription) int { return strings.Compare(a.SubscriptionNumber, b.SubscriptionNumber) })
+	return subs
+}
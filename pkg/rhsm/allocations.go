@@ -0,0 +1,28 @@
+package rhsm
+
+import (
+	"context"
+	"time"
+)
+
+// Allocation is a subscription allocation, e.g. the manifest of a Satellite
+type Allocation struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	// Type is e.g. Satellite
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	// EntitlementQuantity is the number of entitlements attached to the
+	// allocation
+	EntitlementQuantity int       `json:"entitlementQuantity"`
+	CreatedDate         time.Time `json:"createdDate"`
+	LastModified        time.Time `json:"lastModified"`
+	// SimpleContentAccess is enabled or disabled
+	SimpleContentAccess string `json:"simpleContentAccess"`
+}
+
+// FetchAllocations fetches all subscription allocations of the account from
+// the allocations endpoint next to the subscriptions endpoint
+func (c *Client) FetchAllocations(ctx context.Context) ([]Allocation, error) {
+	return fetchList[Allocation](ctx, c, "allocations")
+}
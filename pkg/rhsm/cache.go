@@ -0,0 +1,73 @@
+package rhsm
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ResponseCache keeps the validators and decoded pages of the last
+// responses, so pages are requested conditionally and an unchanged page
+// (HTTP 304) is neither transferred nor decoded again. It is safe for
+// concurrent use and may be shared by the clients of several fetches with the
+// same credentials. The pages are cached by URL, so clients of different
+// accounts need a cache each.
+type ResponseCache struct {
+	mu    sync.Mutex
+	pages map[string]cachedPage
+}
+
+type cachedPage struct {
+	etag         string
+	lastModified string
+	page         Page
+}
+
+// NewResponseCache returns an empty cache
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{pages: map[string]cachedPage{}}
+}
+
+// setConditional adds the validators of the cached response of url to req
+// and reports whether there is one
+func (c *ResponseCache) setConditional(req *http.Request, url string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.pages[url]
+	if !ok {
+		return false
+	}
+	if cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	if cached.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.lastModified)
+	}
+	return true
+}
+
+// get returns a copy of the cached page of url
+func (c *ResponseCache) get(url string) (*Page, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.pages[url]
+	if !ok {
+		return nil, false
+	}
+	page := cached.page
+	return &page, true
+}
+
+// put stores the page of url if the response has validators
+func (c *ResponseCache) put(url string, header http.Header, page *Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		delete(c.pages, url)
+		return
+	}
+	c.pages[url] = cachedPage{etag: etag, lastModified: lastModified, page: *page}
+}
@@ -0,0 +1,114 @@
+package rhsm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestFetchPageCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// header sets the validators of the responses
+		header       map[string]string
+		changed      bool
+		wantNumber   string
+		wantNotMod   int
+		wantRequests []string
+	}{
+		{
+			name:         "etag unchanged",
+			header:       map[string]string{"ETag": `"v1"`},
+			wantNumber:   "v1",
+			wantNotMod:   1,
+			wantRequests: []string{"", `"v1"`},
+		},
+		{
+			name:         "etag changed",
+			header:       map[string]string{"ETag": `"v1"`},
+			changed:      true,
+			wantNumber:   "v2",
+			wantRequests: []string{"", `"v1"`},
+		},
+		{
+			name:         "last modified unchanged",
+			header:       map[string]string{"Last-Modified": "Mon, 01 Jan 2029 00:00:00 GMT"},
+			wantNumber:   "v1",
+			wantNotMod:   1,
+			wantRequests: []string{"", "Mon, 01 Jan 2029 00:00:00 GMT"},
+		},
+		{
+			name:         "no validators",
+			header:       map[string]string{},
+			wantNumber:   "v1",
+			wantRequests: []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version := "v1"
+			var requests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				validator := r.Header.Get("If-None-Match") + r.Header.Get("If-Modified-Since")
+				requests = append(requests, validator)
+				if validator != "" && (validator == tt.header["ETag"] || validator == tt.header["Last-Modified"]) && version == "v1" {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				if version == "v2" {
+					w.Header().Set("ETag", `"v2"`)
+				}
+				json.NewEncoder(w).Encode(Page{Body: []Subscription{{SubscriptionNumber: version}}})
+			}))
+			defer srv.Close()
+
+			notModified := 0
+			c := &Client{
+				HTTPClient:    srv.Client(),
+				URL:           srv.URL,
+				Cache:         NewResponseCache(),
+				OnNotModified: func() { notModified++ },
+			}
+			if _, err := c.FetchPage(context.Background(), 10, 0); err != nil {
+				t.Fatal(err)
+			}
+			if tt.changed {
+				version = "v2"
+			}
+			page, err := c.FetchPage(context.Background(), 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(page.Body) != 1 || page.Body[0].SubscriptionNumber != tt.wantNumber {
+				t.Errorf("page = %+v, want subscription %s", page.Body, tt.wantNumber)
+			}
+			if notModified != tt.wantNotMod {
+				t.Errorf("not modified %d times, want %d", notModified, tt.wantNotMod)
+			}
+			if !slices.Equal(requests, tt.wantRequests) {
+				t.Errorf("request validators = %q, want %q", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// TestFetchPageNotModifiedWithoutCache checks that a 304 for a page that
+// isn't cached is an error instead of an empty page
+func TestFetchPageNotModifiedWithoutCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	c := &Client{HTTPClient: srv.Client(), URL: srv.URL, Cache: NewResponseCache()}
+	if page, err := c.FetchPage(context.Background(), 10, 0); err == nil {
+		t.Fatalf("FetchPage returned %+v, want an error", page)
+	}
+}
@@ -0,0 +1,280 @@
+package rhsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
+)
+
+// Client fetches subscriptions from the API
+type Client struct {
+	// HTTPClient sends the requests and must add the access token, e.g. an
+	// oauth2 client
+	HTTPClient *http.Client
+	// URL is the subscriptions endpoint, DefaultAPIURL if empty. It may
+	// carry query parameters of its own.
+	URL string
+	// PageSize is the number of subscriptions requested per page,
+	// DefaultPageSize if 0
+	PageSize int
+	// Concurrency is the maximum number of pages fetched in parallel once
+	// the total count is known, 0 or 1 fetches them one after another
+	Concurrency int
+	// Retry is the retry policy of every page request
+	Retry RetryPolicy
+	// Lenient tolerates unexpected payloads instead of failing, see
+	// DecodeLenient
+	Lenient bool
+	// OnAnomaly is called for every value tolerated with Lenient, if set
+	OnAnomaly func(kind string)
+	// Cache requests the pages conditionally if set and reuses the cached
+	// page when the API reports it unchanged
+	Cache *ResponseCache
+	// OnNotModified is called for every page reused from Cache, if set
+	OnNotModified func()
+	// OnPaginationMismatch is called with one of PaginationMismatchKinds for
+	// every page or fetch not matching the reported pagination, if set
+	OnPaginationMismatch func(kind string)
+}
+
+// NewClient returns a client for DefaultAPIURL authenticating with the
+// offline token at DefaultTokenURL
+func NewClient(ctx context.Context, offlineToken string) *Client {
+	conf := &oauth2.Config{
+		ClientID: DefaultClientID,
+		Endpoint: oauth2.Endpoint{TokenURL: DefaultTokenURL},
+	}
+	return &Client{
+		HTTPClient: oauth2.NewClient(ctx, conf.TokenSource(ctx, &oauth2.Token{RefreshToken: offlineToken})),
+		Retry:      RetryPolicy{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: 30 * time.Second},
+	}
+}
+
+func (c *Client) url() string {
+	if c.URL == "" {
+		return DefaultAPIURL
+	}
+	return c.URL
+}
+
+func (c *Client) pageSize() int {
+	if c.PageSize <= 0 {
+		return DefaultPageSize
+	}
+	return c.PageSize
+}
+
+// FetchPage fetches a single page of subscriptions
+func (c *Client) FetchPage(ctx context.Context, limit, offset int) (*Page, error) {
+	ctx, span := startPageSpan(ctx, "subscriptions", limit, offset)
+	page, err := c.fetchPage(ctx, limit, offset)
+	count := 0
+	if page != nil {
+		count = len(page.Body)
+	}
+	endPageSpan(span, count, err)
+	return page, err
+}
+
+func (c *Client) fetchPage(ctx context.Context, limit, offset int) (*Page, error) {
+	baseURL := c.url()
+	sep := "?"
+	if strings.Contains(baseURL, "?") {
+		sep = "&"
+	}
+	url := fmt.Sprintf("%s%slimit=%d&offset=%d", baseURL, sep, limit, offset)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	conditional := c.Cache != nil && c.Cache.setConditional(req, url)
+
+	var result *Page
+	var header http.Header
+	err = c.Retry.stream(c.HTTPClient, req, func(body io.Reader, h http.Header) error {
+		header = h
+		if c.Lenient {
+			// The lenient decoder needs the whole body to inspect every field
+			bodyBytes, err := readBody(body)
+			if err != nil {
+				return err
+			}
+			var errResp errorResponse
+			if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error.Message != "" {
+				return &APIError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+			}
+			result, err = DecodeLenient(bodyBytes, c.OnAnomaly)
+			return err
+		}
+		page, err := decodeList[Subscription](body)
+		if err != nil {
+			return err
+		}
+		result = &Page{Body: page.Body, Pagination: page.Pagination}
+		return nil
+	})
+	if err != nil {
+		var statusErr *StatusError
+		if conditional && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotModified {
+			if cached, ok := c.Cache.get(url); ok {
+				slog.Debug("Page not modified", "url", req.URL.Redacted(), "offset", offset, "limit", limit, "count", len(cached.Body))
+				if c.OnNotModified != nil {
+					c.OnNotModified()
+				}
+				return cached, nil
+			}
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			slog.Warn("API returned an error", "url", req.URL.Redacted(), "code", apiErr.Code, "message", apiErr.Message)
+		}
+		return nil, err
+	}
+
+	if c.Cache != nil {
+		c.Cache.put(url, header, result)
+	}
+	slog.Debug("Fetched page", "url", req.URL.Redacted(), "offset", offset, "limit", limit, "count", len(result.Body), "total", result.Pagination.Count)
+	return result, nil
+}
+
+// PaginationMismatchKinds are the kinds of pagination mismatches passed to
+// OnPaginationMismatch: a page shorter than the limit before the reported
+// count was reached, fewer or more subscriptions in total than the reported
+// count, and a page reporting another offset than requested
+var PaginationMismatchKinds = []string{"short_page", "truncated", "extra", "offset"}
+
+// paginationMismatch logs a mismatch of the pagination and reports it to
+// OnPaginationMismatch
+func (c *Client) paginationMismatch(kind string, args ...any) {
+	slog.Warn("Pagination mismatch", append([]any{"kind", kind}, args...)...)
+	if c.OnPaginationMismatch != nil {
+		c.OnPaginationMismatch(kind)
+	}
+}
+
+// checkOffset reports a page whose pagination reports another offset than
+// requested. Pages without pagination report no offset.
+func (c *Client) checkOffset(page *Page, offset int) {
+	if page.Pagination.Limit != 0 && page.Pagination.Offset != offset {
+		c.paginationMismatch("offset", "requested", offset, "reported", page.Pagination.Offset)
+	}
+}
+
+// fetchPagesConcurrently fetches the given number of pages starting at offset
+// with at most Concurrency requests in flight, keeping the page order. The
+// first failed page cancels the others and its error is returned.
+func (c *Client) fetchPagesConcurrently(ctx context.Context, limit, offset, pages int) ([][]Subscription, error) {
+	results := make([][]Subscription, pages)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.Concurrency)
+	for i := range pages {
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			result, err := c.FetchPage(ctx, limit, offset+i*limit)
+			if err != nil {
+				return err
+			}
+			c.checkOffset(result, offset+i*limit)
+			results[i] = result.Body
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// FetchAll fetches all subscriptions. Once the first page reports the total
+// count, the remaining pages are fetched with up to Concurrency requests in
+// parallel.
+//
+// The fetch ends once the reported count is reached, a short page before it
+// is continued at the offset of its last subscription. Without a reported
+// count it ends at the first page shorter than the limit. Mismatches with the
+// count are reported to OnPaginationMismatch.
+func (c *Client) FetchAll(ctx context.Context) ([]Subscription, error) {
+	limit := c.pageSize()
+	offset := 0
+	// expected is the last reported count, -1 while the API reports none
+	expected := -1
+	var allSubs []Subscription
+
+	for {
+		result, err := c.FetchPage(ctx, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+		c.checkOffset(result, offset)
+		if result.Pagination.Count > 0 {
+			expected = result.Pagination.Count
+		}
+
+		allSubs = append(allSubs, result.Body...)
+		n := len(result.Body)
+
+		if expected < 0 {
+			if n < limit {
+				break
+			}
+			offset += limit
+			continue
+		}
+		if n == 0 || offset+n >= expected {
+			break
+		}
+		if n < limit {
+			c.paginationMismatch("short_page", "offset", offset, "limit", limit, "count", n, "total", expected)
+		}
+		offset += n
+
+		// When the API reports the total count, fetch the remaining pages in parallel
+		if offset == limit && c.Concurrency > 1 {
+			pages := (expected - offset + limit - 1) / limit
+			results, err := c.fetchPagesConcurrently(ctx, limit, offset, pages)
+			if err != nil {
+				return nil, err
+			}
+			short := false
+			for i, page := range results {
+				allSubs = append(allSubs, page...)
+				// The pages after a short one start at the wrong subscription,
+				// they are fetched again from its end
+				if end := offset + i*limit + len(page); len(page) < limit && end < expected {
+					c.paginationMismatch("short_page", "offset", offset+i*limit, "limit", limit, "count", len(page), "total", expected)
+					offset, short = end, true
+					break
+				}
+			}
+			if short {
+				continue
+			}
+			// Continue sequentially in case subscriptions were added meanwhile
+			if len(results[pages-1]) < limit {
+				break
+			}
+			offset += pages * limit
+		}
+	}
+
+	switch {
+	case expected >= 0 && len(allSubs) < expected:
+		c.paginationMismatch("truncated", "count", len(allSubs), "total", expected)
+	case expected >= 0 && len(allSubs) > expected:
+		c.paginationMismatch("extra", "count", len(allSubs), "total", expected)
+	}
+	return allSubs, nil
+}
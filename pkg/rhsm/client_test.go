@@ -0,0 +1,166 @@
+package rhsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAPI serves total subscriptions in the pagination envelope. Pages are at
+// most pageCap long if set, and count is reported instead of total if set or
+// no pagination with noCount. The page at failOffset fails with HTTP 400 if
+// set, the others are delayed by delay.
+type fakeAPI struct {
+	total      int
+	pageCap    int
+	count      int
+	noCount    bool
+	failOffset int
+	delay      time.Duration
+	requests   *atomic.Int32
+}
+
+func (f fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.requests != nil {
+		f.requests.Add(1)
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if f.failOffset > 0 && offset == f.failOffset {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	time.Sleep(f.delay)
+	if f.pageCap > 0 {
+		limit = min(limit, f.pageCap)
+	}
+	page := Page{Body: []Subscription{}, Pagination: Pagination{Count: f.total, Limit: limit, Offset: offset}}
+	if f.count != 0 {
+		page.Pagination.Count = f.count
+	}
+	if f.noCount {
+		page.Pagination = Pagination{}
+	}
+	for i := offset; i < min(offset+limit, f.total); i++ {
+		page.Body = append(page.Body, Subscription{SubscriptionNumber: strconv.Itoa(i)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func TestFetchAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		api         fakeAPI
+		pageSize    int
+		concurrency int
+		want        int
+	}{
+		{name: "sequential", api: fakeAPI{total: 12}, pageSize: 4, want: 12},
+		{name: "concurrent", api: fakeAPI{total: 12}, pageSize: 4, concurrency: 3, want: 12},
+		{name: "concurrent last page short", api: fakeAPI{total: 14}, pageSize: 4, concurrency: 3, want: 14},
+		{name: "more pages than workers", api: fakeAPI{total: 50}, pageSize: 3, concurrency: 4, want: 50},
+		{name: "no count", api: fakeAPI{total: 7, noCount: true}, pageSize: 5, want: 7},
+		{name: "no count full pages", api: fakeAPI{total: 10, noCount: true}, pageSize: 5, want: 10},
+		{name: "empty", api: fakeAPI{total: 0}, pageSize: 5, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.api)
+			defer srv.Close()
+
+			c := &Client{HTTPClient: srv.Client(), URL: srv.URL, PageSize: tt.pageSize, Concurrency: tt.concurrency}
+			subs, err := c.FetchAll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subs) != tt.want {
+				t.Fatalf("got %d subscriptions, want %d", len(subs), tt.want)
+			}
+			for i, s := range subs {
+				if s.SubscriptionNumber != strconv.Itoa(i) {
+					t.Fatalf("subscription %d is %s, the pages are out of order or overlap", i, s.SubscriptionNumber)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchAllPaginationMismatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		api         fakeAPI
+		pageSize    int
+		concurrency int
+		want        int
+		mismatches  map[string]int
+	}{
+		{name: "matching count", api: fakeAPI{total: 10}, pageSize: 5, want: 10},
+		{name: "short pages", api: fakeAPI{total: 10, pageCap: 3}, pageSize: 5, want: 10, mismatches: map[string]int{"short_page": 3}},
+		{name: "count too high", api: fakeAPI{total: 10, count: 12}, pageSize: 5, want: 10, mismatches: map[string]int{"truncated": 1}},
+		{name: "count too low", api: fakeAPI{total: 10, count: 8}, pageSize: 5, want: 10, mismatches: map[string]int{"extra": 1}},
+		{name: "concurrent short pages", api: fakeAPI{total: 20, pageCap: 3}, pageSize: 3, concurrency: 3, want: 20},
+		{name: "concurrent count too high", api: fakeAPI{total: 10, count: 12}, pageSize: 4, concurrency: 3, want: 10, mismatches: map[string]int{"short_page": 1, "truncated": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.api)
+			defer srv.Close()
+
+			var mu sync.Mutex
+			mismatches := map[string]int{}
+			c := &Client{
+				HTTPClient:  srv.Client(),
+				URL:         srv.URL,
+				PageSize:    tt.pageSize,
+				Concurrency: tt.concurrency,
+				OnPaginationMismatch: func(kind string) {
+					mu.Lock()
+					defer mu.Unlock()
+					mismatches[kind]++
+				},
+			}
+			subs, err := c.FetchAll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subs) != tt.want {
+				t.Fatalf("got %d subscriptions, want %d", len(subs), tt.want)
+			}
+			for i, s := range subs {
+				if s.SubscriptionNumber != strconv.Itoa(i) {
+					t.Fatalf("subscription %d is %s, the pages are out of order or overlap", i, s.SubscriptionNumber)
+				}
+			}
+			if !maps.Equal(mismatches, tt.mismatches) {
+				t.Errorf("mismatches = %v, want %v", mismatches, tt.mismatches)
+			}
+		})
+	}
+}
+
+// TestFetchAllConcurrentFailure checks that a failed page stops the
+// concurrent fetch instead of requesting all remaining pages
+func TestFetchAllConcurrentFailure(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(fakeAPI{total: 100, failOffset: 2, delay: 20 * time.Millisecond, requests: &requests})
+	defer srv.Close()
+
+	c := &Client{HTTPClient: srv.Client(), URL: srv.URL, PageSize: 1, Concurrency: 2}
+	_, err := c.FetchAll(context.Background())
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("error = %v, want HTTP 400", err)
+	}
+	if got := requests.Load(); got > 10 {
+		t.Errorf("%d pages requested after the failure, want the fetch cancelled", got)
+	}
+}
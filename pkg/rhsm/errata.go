@@ -0,0 +1,23 @@
+package rhsm
+
+import "context"
+
+// Erratum is an advisory applicable to systems of the account
+type Erratum struct {
+	AdvisoryID string `json:"advisoryId"`
+	Synopsis   string `json:"synopsis"`
+	// Type is Security Advisory, Bug Fix Advisory or Product Enhancement
+	// Advisory
+	Type string `json:"type"`
+	// Severity is e.g. Critical, Important, Moderate or Low for security
+	// advisories
+	Severity             string `json:"severity"`
+	Issued               string `json:"issued"`
+	AffectedSystemsCount int    `json:"affectedSystemsCount"`
+}
+
+// FetchErrata fetches all errata applicable to systems of the account from
+// the errata endpoint next to the subscriptions endpoint
+func (c *Client) FetchErrata(ctx context.Context) ([]Erratum, error) {
+	return fetchList[Erratum](ctx, c, "errata")
+}
@@ -0,0 +1,243 @@
+package rhsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnomalyKinds are the kinds of unexpected values tolerated by DecodeLenient
+var AnomalyKinds = []string{"unknown_field", "unknown_enum", "null_value", "type_mismatch", "invalid_date", "invalid_record"}
+
+// knownStatuses are the subscription statuses returned by the API, compared
+// case-insensitively and ignoring spaces
+var knownStatuses = []string{"active", "expired", "futuredated", "expiringsoon", "recentlyexpired"}
+
+// knownPoolTypes are the Candlepin pool types
+var knownPoolTypes = []string{"NORMAL", "ENTITLEMENT_DERIVED", "STACK_DERIVED", "BONUS", "UNMAPPED_GUEST", "DEVELOPMENT"}
+
+// lenientDecoder decodes a subscriptions response field by field, replacing
+// values it can't decode with their zero value instead of failing
+type lenientDecoder struct {
+	onAnomaly func(kind string)
+	anomalies int
+	// subscription is the subscription number of the record being decoded,
+	// used in log messages
+	subscription string
+}
+
+// anomaly counts and logs an unexpected value
+func (d *lenientDecoder) anomaly(kind, field string, value json.RawMessage) {
+	d.anomalies++
+	if d.onAnomaly != nil {
+		d.onAnomaly(kind)
+	}
+	slog.Debug("Tolerated unexpected value in API response", "kind", kind, "field", field, "subscription", d.subscription, "value", string(value))
+}
+
+// object splits value into its fields, reporting fields not in known
+func (d *lenientDecoder) object(field string, value json.RawMessage, known ...string) (map[string]json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil || fields == nil {
+		d.anomaly("invalid_record", field, value)
+		return nil, false
+	}
+	for name := range fields {
+		if !slices.Contains(known, name) {
+			d.anomaly("unknown_field", field+"."+name, nil)
+		}
+	}
+	return fields, true
+}
+
+// string decodes a string field, numbers and booleans are converted
+func (d *lenientDecoder) string(fields map[string]json.RawMessage, field string) string {
+	value, ok := fields[field]
+	if !ok || isNull(value) {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	var n json.Number
+	if err := json.Unmarshal(value, &n); err == nil {
+		d.anomaly("type_mismatch", field, value)
+		return n.String()
+	}
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		d.anomaly("type_mismatch", field, value)
+		return strconv.FormatBool(b)
+	}
+	d.anomaly("type_mismatch", field, value)
+	return ""
+}
+
+// int decodes a numeric field, null is taken as 0, floats are truncated and
+// numeric strings are parsed
+func (d *lenientDecoder) int(fields map[string]json.RawMessage, field string) int {
+	value, ok := fields[field]
+	if !ok {
+		return 0
+	}
+	if isNull(value) {
+		d.anomaly("null_value", field, value)
+		return 0
+	}
+	var n json.Number
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		d.anomaly("type_mismatch", field, value)
+		n = json.Number(strings.TrimSpace(s))
+	} else if err := json.Unmarshal(value, &n); err != nil {
+		d.anomaly("type_mismatch", field, value)
+		return 0
+	}
+	if i, err := n.Int64(); err == nil {
+		return int(i)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		// only reachable for strings, already counted
+		return 0
+	}
+	if f != float64(int64(f)) && s == "" {
+		d.anomaly("type_mismatch", field, value)
+	}
+	return int(f)
+}
+
+// time decodes a RFC 3339 date field, null and invalid dates are taken as the
+// zero time
+func (d *lenientDecoder) time(fields map[string]json.RawMessage, field string) time.Time {
+	value, ok := fields[field]
+	if !ok {
+		return time.Time{}
+	}
+	if isNull(value) {
+		d.anomaly("null_value", field, value)
+		return time.Time{}
+	}
+	var t time.Time
+	if err := json.Unmarshal(value, &t); err != nil {
+		d.anomaly("invalid_date", field, value)
+		return time.Time{}
+	}
+	return t
+}
+
+// enum reports values of field not in known, the value is kept
+func (d *lenientDecoder) enum(field, value string, known []string, normalize func(string) string) {
+	if value == "" {
+		return
+	}
+	if slices.Contains(known, normalize(value)) {
+		return
+	}
+	d.anomaly("unknown_enum", field, json.RawMessage(strconv.Quote(value)))
+}
+
+// record decodes a single subscription record
+func (d *lenientDecoder) record(value json.RawMessage) (Subscription, bool) {
+	d.subscription = ""
+	fields, ok := d.object("body", value, "contractNumber", "endDate", "quantity", "sku", "startDate", "status", "subscriptionName", "subscriptionNumber", "pools", "serviceLevel", "usage", "account")
+	if !ok {
+		return Subscription{}, false
+	}
+
+	var s Subscription
+	s.SubscriptionNumber = d.string(fields, "subscriptionNumber")
+	d.subscription = s.SubscriptionNumber
+	s.ContractNumber = d.string(fields, "contractNumber")
+	s.SKU = d.string(fields, "sku")
+	s.SubscriptionName = d.string(fields, "subscriptionName")
+	s.Status = d.string(fields, "status")
+	s.ServiceLevel = d.string(fields, "serviceLevel")
+	s.Usage = d.string(fields, "usage")
+	s.Role = d.string(fields, "role")
+	s.Account = d.string(fields, "account")
+	s.StartDate = d.time(fields, "startDate")
+	s.EndDate = d.time(fields, "endDate")
+	d.enum("status", s.Status, knownStatuses, func(v string) string {
+		return strings.ToLower(strings.ReplaceAll(v, " ", ""))
+	})
+
+	if quantity, ok := fields["quantity"]; ok && isNull(quantity) {
+		d.anomaly("null_value", "quantity", quantity)
+	} else {
+		s.Quantity = d.string(fields, "quantity")
+	}
+
+	if pools, ok := fields["pools"]; ok && !isNull(pools) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(pools, &items); err != nil {
+			d.anomaly("type_mismatch", "pools", pools)
+		}
+		for _, item := range items {
+			pool, ok := d.object("pools", item, "consumed", "id", "quantity", "type")
+			if !ok {
+				continue
+			}
+			p := Pool{
+				Consumed: d.int(pool, "consumed"),
+				ID:       d.string(pool, "id"),
+				Quantity: d.int(pool, "quantity"),
+				Type:     d.string(pool, "type"),
+			}
+			d.enum("pools.type", p.Type, knownPoolTypes, strings.ToUpper)
+			s.Pools = append(s.Pools, p)
+		}
+	}
+	return s, true
+}
+
+// DecodeLenient decodes a page of subscriptions, tolerating unknown fields
+// and enum values, nulls in numeric fields and mismatching types. onAnomaly,
+// if set, is called with one of AnomalyKinds for every tolerated value,
+// records that aren't objects are skipped. Only a response that isn't a JSON
+// object at all is an error.
+func DecodeLenient(data []byte, onAnomaly func(kind string)) (*Page, error) {
+	d := lenientDecoder{onAnomaly: onAnomaly}
+	envelope, ok := d.object("response", data, "body", "pagination")
+	if !ok {
+		return nil, fmt.Errorf("decode failed: response is not a JSON object")
+	}
+
+	var result Page
+	if body, ok := envelope["body"]; ok && !isNull(body) {
+		var records []json.RawMessage
+		if err := json.Unmarshal(body, &records); err != nil {
+			return nil, fmt.Errorf("decode failed: body is not a list: %w", err)
+		}
+		for _, record := range records {
+			if s, ok := d.record(record); ok {
+				result.Body = append(result.Body, s)
+			}
+		}
+	}
+
+	d.subscription = ""
+	if value, ok := envelope["pagination"]; ok && !isNull(value) {
+		if pagination, ok := d.object("pagination", value, "count", "limit", "offset"); ok {
+			result.Pagination.Count = d.int(pagination, "count")
+			result.Pagination.Limit = d.int(pagination, "limit")
+			result.Pagination.Offset = d.int(pagination, "offset")
+		}
+	}
+
+	if d.anomalies > 0 {
+		slog.Warn("Tolerated unexpected values in API response", "anomalies", d.anomalies)
+	}
+	return &result, nil
+}
+
+// isNull reports whether value is the JSON null
+func isNull(value json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(value), []byte("null"))
+}
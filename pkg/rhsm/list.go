@@ -0,0 +1,72 @@
+package rhsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// list is a page of any endpoint in the pagination envelope of the API
+type list[T any] struct {
+	Body       []T        `json:"body"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Endpoint returns the URL of another endpoint of the API, derived from the
+// subscriptions URL by replacing its last path segment. Query parameters of
+// the URL are kept.
+func (c *Client) Endpoint(path string) string {
+	base, query, _ := strings.Cut(c.url(), "?")
+	base = strings.TrimSuffix(strings.TrimSuffix(base, "/"), "/subscriptions")
+	url := base + "/" + path
+	if query != "" {
+		url += "?" + query
+	}
+	return url
+}
+
+// fetchList fetches all items of the endpoint at path, following the
+// pagination like FetchAll does sequentially
+func fetchList[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	baseURL := c.Endpoint(path)
+	sep := "?"
+	if strings.Contains(baseURL, "?") {
+		sep = "&"
+	}
+	limit := c.pageSize()
+	var all []T
+
+	for offset := 0; ; offset += limit {
+		url := fmt.Sprintf("%s%slimit=%d&offset=%d", baseURL, sep, limit, offset)
+		pageCtx, span := startPageSpan(ctx, path, limit, offset)
+		req, err := http.NewRequestWithContext(pageCtx, "GET", url, nil)
+		if err != nil {
+			endPageSpan(span, 0, err)
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		var page *list[T]
+		err = c.Retry.stream(c.HTTPClient, req, func(body io.Reader, _ http.Header) error {
+			page, err = decodeList[T](body)
+			return err
+		})
+		if err != nil {
+			endPageSpan(span, 0, err)
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				slog.Warn("API returned an error", "url", req.URL.Redacted(), "code", apiErr.Code, "message", apiErr.Message)
+			}
+			return nil, err
+		}
+
+		endPageSpan(span, len(page.Body), nil)
+		slog.Debug("Fetched page", "url", req.URL.Redacted(), "offset", offset, "limit", limit, "count", len(page.Body), "total", page.Pagination.Count)
+		all = append(all, page.Body...)
+		if len(page.Body) < limit {
+			return all, nil
+		}
+	}
+}
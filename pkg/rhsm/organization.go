@@ -0,0 +1,42 @@
+package rhsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Organization is the organization of the account
+type Organization struct {
+	ID string `json:"id"`
+	// SimpleContentAccess is enabled or disabled
+	SimpleContentAccess        string `json:"simpleContentAccess"`
+	SimpleContentAccessCapable bool   `json:"simpleContentAccessCapable"`
+}
+
+// SCA reports whether the organization is in Simple Content Access mode
+func (o *Organization) SCA() bool {
+	return o.SimpleContentAccess == "enabled"
+}
+
+// FetchOrganization fetches the organization of the account from the
+// organization endpoint next to the subscriptions endpoint
+func (c *Client) FetchOrganization(ctx context.Context) (*Organization, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Endpoint("organization"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	bodyBytes, err := c.Retry.Do(c.HTTPClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Body Organization `json:"body"`
+	}
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return nil, fmt.Errorf("decode failed: %w", err)
+	}
+	return &result.Body, nil
+}
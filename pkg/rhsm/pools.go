@@ -0,0 +1,30 @@
+package rhsm
+
+import (
+	"context"
+	"time"
+)
+
+// AccountPool is a pool of the account as listed by the pools endpoint, which
+// also covers pools not returned with their subscription
+type AccountPool struct {
+	ID                 string `json:"id"`
+	SubscriptionNumber string `json:"subscriptionNumber"`
+	SubscriptionName   string `json:"subscriptionName"`
+	SKU                string `json:"sku"`
+	// Type is e.g. NORMAL or ENTITLEMENT_DERIVED
+	Type string `json:"type"`
+	// Quantity is negative for unlimited pools
+	Quantity int `json:"quantity"`
+	Consumed int `json:"consumed"`
+	// Exported is the number of entitlements exported to manifests
+	Exported  int       `json:"exported"`
+	StartDate time.Time `json:"startDate"`
+	EndDate   time.Time `json:"endDate"`
+}
+
+// FetchPools fetches all pools of the account from the pools endpoint next to
+// the subscriptions endpoint
+func (c *Client) FetchPools(ctx context.Context) ([]AccountPool, error) {
+	return fetchList[AccountPool](ctx, c, "pools")
+}
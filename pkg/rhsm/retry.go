@@ -0,0 +1,266 @@
+package rhsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// StatusError is returned for non-2xx responses
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the wait time requested by the server, if any
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP error: %d %s", e.StatusCode, e.Status)
+}
+
+// APIError is an error reported in the payload of a 2xx response
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.Code, e.Message)
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying
+func IsRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	// A rejected refresh token stays rejected, no point in asking again
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && retrieveErr.Response.StatusCode >= 500
+	}
+
+	// The response arrived but can't be used, asking again won't help
+	var apiErr *APIError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &apiErr) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+
+	// Everything else is a transport error (timeouts, connection resets, ...)
+	return true
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as HTTP date
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// RetryPolicy retries transient failures with exponential backoff and
+// jitter. The zero value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per request
+	MaxAttempts int
+	// Backoff is the wait time before the first retry, doubled on each retry
+	Backoff time.Duration
+	// MaxBackoff caps the wait time between retries
+	MaxBackoff time.Duration
+	// MaxRetryAfter caps the wait requested by Retry-After, MaxBackoff if
+	// 0, or DefaultMaxRetryAfter if both are 0
+	MaxRetryAfter time.Duration
+	// MaxRateLimited is the number of consecutive HTTP 429 responses after
+	// which the request fails, DefaultMaxRateLimited if 0
+	MaxRateLimited int
+	// OnRateLimited is called for every HTTP 429 response, if set
+	OnRateLimited func()
+}
+
+const (
+	// DefaultMaxRetryAfter caps Retry-After if the policy sets no cap
+	DefaultMaxRetryAfter = time.Minute
+	// DefaultMaxRateLimited is the default number of consecutive HTTP 429
+	// responses after which a request fails
+	DefaultMaxRateLimited = 10
+)
+
+// rateLimitWait returns the wait time before retrying the request after the
+// given number of consecutive HTTP 429 responses, Retry-After capped to
+// MaxRetryAfter or the backoff if the server requested no wait
+func (p RetryPolicy) rateLimitWait(retryAfter time.Duration, rateLimited int) time.Duration {
+	if retryAfter <= 0 {
+		return p.backoff(rateLimited)
+	}
+	limit := p.MaxRetryAfter
+	if limit <= 0 {
+		limit = p.MaxBackoff
+	}
+	if limit <= 0 {
+		limit = DefaultMaxRetryAfter
+	}
+	return min(retryAfter, limit)
+}
+
+// backoff returns the wait time before the given retry, doubling the base
+// backoff each time and adding jitter so replicas don't retry in sync
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff << (retry - 1)
+	if d <= 0 || d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
+
+// DoOnce performs the request and returns the body of a 2xx response
+func DoOnce(client *http.Client, req *http.Request) ([]byte, error) {
+	var body []byte
+	err := doOnce(client, req, func(r io.Reader, _ http.Header) error {
+		var err error
+		body, err = readBody(r)
+		return err
+	})
+	return body, err
+}
+
+// readBody reads the whole response body
+func readBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return body, nil
+}
+
+// doOnce performs the request and passes the body and header of a 2xx
+// response to fn while the body is still being received
+func doOnce(client *http.Client, req *http.Request, fn func(body io.Reader, header http.Header) error) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			statusErr.RetryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return statusErr
+	}
+
+	return fn(resp.Body, resp.Header)
+}
+
+// Do performs the request and retries transient failures, up to
+// MaxAttempts times. Rate-limited requests (HTTP 429) wait for Retry-After,
+// at most MaxRetryAfter, and don't count as failed attempts, but fail after
+// MaxRateLimited consecutive 429 responses.
+func (p RetryPolicy) Do(client *http.Client, req *http.Request) ([]byte, error) {
+	var body []byte
+	err := p.stream(client, req, func(r io.Reader, _ http.Header) error {
+		var err error
+		body, err = readBody(r)
+		return err
+	})
+	return body, err
+}
+
+// stream is Do passing the body and header of the response to fn while it is
+// received instead of buffering it. A failed attempt may have called fn
+// before, fn must start over on every call.
+func (p RetryPolicy) stream(client *http.Client, req *http.Request, fn func(body io.Reader, header http.Header) error) error {
+	attempts := max(p.MaxAttempts, 1)
+
+	maxRateLimited := p.MaxRateLimited
+	if maxRateLimited <= 0 {
+		maxRateLimited = DefaultMaxRateLimited
+	}
+
+	var lastErr error
+	// rateLimited counts the consecutive HTTP 429 responses, they don't
+	// use up attempts but end the request once there are too many
+	rateLimited := 0
+	for attempt := 1; attempt <= attempts; {
+		err := doOnce(client, req, fn)
+		if err == nil {
+			return nil
+		}
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+			if p.OnRateLimited != nil {
+				p.OnRateLimited()
+			}
+			rateLimited++
+			if rateLimited >= maxRateLimited {
+				return fmt.Errorf("giving up after %d rate-limited responses: %w", rateLimited, err)
+			}
+			wait := p.rateLimitWait(statusErr.RetryAfter, rateLimited)
+			slog.Warn("Rate limited", "url", req.URL.Redacted(), "wait", wait, "retry_after", statusErr.RetryAfter)
+			if err := sleepContext(req.Context(), wait); err != nil {
+				return err
+			}
+			continue
+		}
+		rateLimited = 0
+
+		if !IsRetryable(err) || req.Context().Err() != nil {
+			return err
+		}
+		lastErr = err
+
+		if attempt == attempts {
+			break
+		}
+		wait := p.backoff(attempt)
+		attempt++
+		slog.Warn("Retrying request", "url", req.URL.Redacted(), "wait", wait, "attempt", attempt, "attempts", attempts, "err", err)
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return err
+		}
+	}
+
+	if attempts == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, lastErr)
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,202 @@
+package rhsm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		retry int
+		// the jittered wait is in [max/2, max)
+		max time.Duration
+	}{
+		{retry: 1, max: 100 * time.Millisecond},
+		{retry: 2, max: 200 * time.Millisecond},
+		{retry: 3, max: 400 * time.Millisecond},
+		{retry: 4, max: 800 * time.Millisecond},
+		{retry: 5, max: time.Second},
+		{retry: 10, max: time.Second},
+		// The shift overflows, the wait is still capped
+		{retry: 80, max: time.Second},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			if got := p.backoff(tt.retry); got < tt.max/2 || got >= tt.max {
+				t.Fatalf("backoff(%d) = %s, want in [%s, %s)", tt.retry, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		// statuses are returned in order, the last one repeatedly
+		statuses   []int
+		wantErr    bool
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "success", maxAttempts: 3, statuses: []int{200}, wantCalls: 1},
+		{name: "retried server errors", maxAttempts: 3, statuses: []int{500, 503, 200}, wantCalls: 3},
+		{name: "gives up", maxAttempts: 3, statuses: []int{500}, wantErr: true, wantStatus: 500, wantCalls: 3},
+		{name: "single attempt", maxAttempts: 0, statuses: []int{502}, wantErr: true, wantStatus: 502, wantCalls: 1},
+		{name: "client error not retried", maxAttempts: 3, statuses: []int{404}, wantErr: true, wantStatus: 404, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(calls.Add(1)) - 1
+				status := tt.statuses[min(i, len(tt.statuses)-1)]
+				w.WriteHeader(status)
+				w.Write([]byte(strconv.Itoa(status)))
+			}))
+			defer srv.Close()
+
+			p := RetryPolicy{MaxAttempts: tt.maxAttempts, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := p.Do(srv.Client(), req)
+
+			if tt.wantErr {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus {
+					t.Errorf("error = %v, want HTTP %d", err, tt.wantStatus)
+				}
+			} else if err != nil || string(body) != "200" {
+				t.Errorf("Do() = %q, %v, want the 200 body", body, err)
+			}
+			if got := int(calls.Load()); got != tt.wantCalls {
+				t.Errorf("%d requests, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "0", want: 0},
+		{value: "30", want: 30 * time.Second},
+		{value: "-5", want: 0},
+		{value: "Mon, 01 Jan 2029 00:02:00 GMT", want: 2 * time.Minute},
+		{value: "Sun, 31 Dec 2028 23:00:00 GMT", want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyRateLimited(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxAttempts    int
+		maxRetryAfter  time.Duration
+		maxRateLimited int
+		// statuses are returned in order, the last one repeatedly
+		statuses    []int
+		retryAfter  string
+		wantErr     bool
+		wantCalls   int
+		wantLimited int
+		minWait     time.Duration
+		maxWait     time.Duration
+	}{
+		{name: "doesn't use attempts", maxAttempts: 1, statuses: []int{429, 429, 200}, wantCalls: 3, wantLimited: 2},
+		{name: "retry after", maxAttempts: 1, maxRetryAfter: time.Minute, statuses: []int{429, 200}, retryAfter: "1", wantCalls: 2, wantLimited: 1, minWait: time.Second},
+		{name: "retry after capped", maxAttempts: 1, maxRetryAfter: 10 * time.Millisecond, statuses: []int{429, 200}, retryAfter: "3600", wantCalls: 2, wantLimited: 1, maxWait: time.Second},
+		{name: "gives up", maxAttempts: 3, maxRateLimited: 4, statuses: []int{429}, wantErr: true, wantCalls: 4, wantLimited: 4},
+		{name: "default limit", maxAttempts: 3, statuses: []int{429}, wantErr: true, wantCalls: DefaultMaxRateLimited, wantLimited: DefaultMaxRateLimited},
+		{name: "count is consecutive", maxAttempts: 3, maxRateLimited: 2, statuses: []int{429, 500, 429, 500, 200}, wantCalls: 5, wantLimited: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(calls.Add(1)) - 1
+				status := tt.statuses[min(i, len(tt.statuses)-1)]
+				if status == http.StatusTooManyRequests && tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+				w.Write([]byte(strconv.Itoa(status)))
+			}))
+			defer srv.Close()
+
+			limited := 0
+			p := RetryPolicy{
+				MaxAttempts:    tt.maxAttempts,
+				Backoff:        time.Millisecond,
+				MaxBackoff:     5 * time.Millisecond,
+				MaxRetryAfter:  tt.maxRetryAfter,
+				MaxRateLimited: tt.maxRateLimited,
+				OnRateLimited:  func() { limited++ },
+			}
+			// No deadline, the policy alone has to end the request
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			body, err := p.Do(srv.Client(), req)
+			elapsed := time.Since(start)
+
+			if tt.wantErr {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+					t.Errorf("error = %v, want HTTP 429", err)
+				}
+			} else if err != nil || string(body) != "200" {
+				t.Errorf("Do() = %q, %v, want the 200 body", body, err)
+			}
+			if got := int(calls.Load()); got != tt.wantCalls {
+				t.Errorf("%d requests, want %d", got, tt.wantCalls)
+			}
+			if limited != tt.wantLimited {
+				t.Errorf("rate limited %d times, want %d", limited, tt.wantLimited)
+			}
+			if elapsed < tt.minWait || (tt.maxWait > 0 && elapsed > tt.maxWait) {
+				t.Errorf("returned after %s, want between %s and %s", elapsed, tt.minWait, tt.maxWait)
+			}
+		})
+	}
+}
+
+// TestRetryPolicyDoCancelled checks that a cancelled request stops waiting
+// for Retry-After
+func TestRetryPolicyDoCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (RetryPolicy{MaxAttempts: 3, MaxRetryAfter: time.Hour}).Do(srv.Client(), req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
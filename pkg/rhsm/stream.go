@@ -0,0 +1,87 @@
+package rhsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// decodeList decodes a page in the pagination envelope from r item by item,
+// so only the decoded items and not the whole response body are held in
+// memory. An error reported in the payload is returned as *APIError.
+func decodeList[T any](r io.Reader) (*list[T], error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	var page list[T]
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("decode failed: %w", err)
+		}
+		switch token {
+		case "body":
+			if err := decodeItems(dec, &page.Body); err != nil {
+				return nil, err
+			}
+		case "pagination":
+			if err := dec.Decode(&page.Pagination); err != nil {
+				return nil, fmt.Errorf("decode failed: %w", err)
+			}
+		case "error":
+			var errResp errorResponse
+			if err := dec.Decode(&errResp.Error); err != nil {
+				return nil, fmt.Errorf("decode failed: %w", err)
+			}
+			if errResp.Error.Message != "" {
+				return nil, &APIError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("decode failed: %w", err)
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// decodeItems decodes a JSON array into items one element at a time, null
+// leaves items empty
+func decodeItems[T any](dec *json.Decoder, items *[]T) error {
+	token, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("decode failed: %w", err)
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("decode failed: body is not a list")
+	}
+	for dec.More() {
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return fmt.Errorf("decode failed: %w", err)
+		}
+		*items = append(*items, item)
+	}
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads the next token and fails unless it is delim
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("decode failed: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("decode failed: expected %v, got %v", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package rhsm
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// System is a system registered to the account
+type System struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	// Type is e.g. Physical, Virtual or Hypervisor
+	Type string `json:"type"`
+	// EntitlementStatus is e.g. valid, invalid, partial or unentitled
+	EntitlementStatus string    `json:"entitlementStatus"`
+	EntitlementCount  int       `json:"entitlementCount"`
+	LastCheckin       time.Time `json:"lastCheckin"`
+	Href              string    `json:"href"`
+}
+
+// FetchSystems fetches all systems registered to the account from the
+// systems endpoint next to the subscriptions endpoint
+func (c *Client) FetchSystems(ctx context.Context) ([]System, error) {
+	return fetchList[System](ctx, c, "systems")
+}
+
+// SystemEntitlement is an entitlement a system consumes from a pool
+type SystemEntitlement struct {
+	ID                 string `json:"id"`
+	PoolID             string `json:"poolId"`
+	SubscriptionNumber string `json:"subscriptionNumber"`
+	SKU                string `json:"sku"`
+	// Quantity is the number of entitlements consumed from the pool
+	Quantity int `json:"entitlementQuantity"`
+}
+
+// FetchSystemEntitlements fetches the entitlements consumed by the system
+// with the given UUID
+func (c *Client) FetchSystemEntitlements(ctx context.Context, uuid string) ([]SystemEntitlement, error) {
+	return fetchList[SystemEntitlement](ctx, c, "systems/"+url.PathEscape(uuid)+"/entitlements")
+}
@@ -0,0 +1,34 @@
+package rhsm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates a span per page request with the globally registered
+// OpenTelemetry tracer provider, a no-op unless the program installs one
+var tracer = otel.Tracer("github.com/dadav/redhat-subscription-exporter/pkg/rhsm")
+
+// startPageSpan starts the span of a page request of the endpoint at path
+func startPageSpan(ctx context.Context, path string, limit, offset int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "GET "+path, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("rhsm.endpoint", path),
+		attribute.Int("rhsm.limit", limit),
+		attribute.Int("rhsm.offset", offset),
+	))
+}
+
+// endPageSpan records the number of items or the error of a page request
+func endPageSpan(span trace.Span, count int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("rhsm.count", count))
+	}
+	span.End()
+}
@@ -0,0 +1,89 @@
+// Package rhsm is a client for the subscriptions endpoint of the Red Hat
+// Subscription Management API.
+//
+// A Client fetches all subscriptions of an account, following the pagination
+// of the API and retrying transient failures:
+//
+//	client := rhsm.NewClient(ctx, offlineToken)
+//	subs, err := client.FetchAll(ctx)
+package rhsm
+
+import "time"
+
+const (
+	// DefaultTokenURL is the Red Hat SSO endpoint exchanging offline tokens
+	// for access tokens
+	DefaultTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+	// DefaultAPIURL is the subscriptions endpoint of the API
+	DefaultAPIURL = "https://api.access.redhat.com/management/v1/subscriptions"
+	// DefaultClientID is the OAuth client offline tokens are issued for
+	DefaultClientID = "rhsm-api"
+	// DefaultPageSize is the number of subscriptions requested per page
+	DefaultPageSize = 50
+)
+
+// Subscription represents one subscription entry
+type Subscription struct {
+	ContractNumber     string    `json:"contractNumber"`
+	EndDate            time.Time `json:"endDate"`
+	Quantity           string    `json:"quantity"`
+	SKU                string    `json:"sku"`
+	StartDate          time.Time `json:"startDate"`
+	Status             string    `json:"status"`
+	SubscriptionName   string    `json:"subscriptionName"`
+	SubscriptionNumber string    `json:"subscriptionNumber"`
+	Pools              []Pool    `json:"pools"`
+	// ServiceLevel (e.g. Premium), Usage (e.g. Production) and the system
+	// purpose Role (e.g. Red Hat Enterprise Linux Server) are only returned
+	// for some subscriptions
+	ServiceLevel string `json:"serviceLevel,omitempty"`
+	Usage        string `json:"usage,omitempty"`
+	Role         string `json:"role,omitempty"`
+	// Account is not part of the API, programs fetching several accounts
+	// set it to tell the subscriptions apart
+	Account string `json:"account,omitempty"`
+	// Source is not part of the API either, programs merging several
+	// sources set it to the source of the subscription
+	Source string `json:"source,omitempty"`
+}
+
+// Pool is a pool of a subscription
+type Pool struct {
+	Consumed int    `json:"consumed"`
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+	Type     string `json:"type"`
+}
+
+// Page is a page of subscriptions in the pagination envelope of the API
+type Page struct {
+	Body       []Subscription `json:"body"`
+	Pagination Pagination     `json:"pagination"`
+}
+
+// Pagination describes a page, Count is the total number of subscriptions
+type Pagination struct {
+	Count  int `json:"count"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// Status returns the status the API reports for a subscription with the
+// given dates at now
+func Status(start, end, now time.Time) string {
+	switch {
+	case !end.After(now):
+		return "Expired"
+	case start.After(now):
+		return "Future Dated"
+	}
+	return "Active"
+}
+
+// errorResponse is the error payload
+type errorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var collectPools bool
+
+var poolsRegistry = prometheus.NewRegistry()
+
+var poolLabels = []string{"pool", "subscriptionNumber", "sku", "type"}
+
+var (
+	PoolQuantityGauge = promauto.With(poolsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_pool_quantity",
+		Help: "Number of entitlements of the pool, -1 for unlimited pools.",
+	},
+		poolLabels)
+	PoolConsumedGauge = promauto.With(poolsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_pool_consumed",
+		Help: "Number of consumed entitlements of the pool.",
+	},
+		poolLabels)
+	PoolExportedGauge = promauto.With(poolsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_pool_exported",
+		Help: "Number of entitlements of the pool exported to manifests.",
+	},
+		poolLabels)
+)
+
+func init() {
+	optionalCollectors = append(optionalCollectors, &optionalCollector{
+		name:     "pools",
+		path:     "pools",
+		enabled:  &collectPools,
+		registry: poolsRegistry,
+		collect:  collectPoolMetrics,
+	})
+}
+
+// collectPoolMetrics fetches the pools of the account and exports their
+// capacity, consumption and exports
+func collectPoolMetrics(ctx context.Context, client *rhsm.Client) error {
+	pools, err := client.FetchPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	PoolQuantityGauge.Reset()
+	PoolConsumedGauge.Reset()
+	PoolExportedGauge.Reset()
+	for _, p := range pools {
+		labels := []string{p.ID, p.SubscriptionNumber, p.SKU, p.Type}
+		quantity := float64(p.Quantity)
+		if p.Quantity < 0 {
+			quantity = -1
+		}
+		PoolQuantityGauge.WithLabelValues(labels...).Set(quantity)
+		PoolConsumedGauge.WithLabelValues(labels...).Set(float64(p.Consumed))
+		PoolExportedGauge.WithLabelValues(labels...).Set(float64(p.Exported))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/oauth2"
+)
+
+var (
+	probeMu sync.Mutex
+	// probeTokenSources caches the token source per account and
+	// credentials, so scrapes don't exchange the offline token every time
+	probeTokenSources = map[string]oauth2.TokenSource{}
+)
+
+// probeTokenSource returns the cached token source of a. The token transport
+// is only created with a new token source, the cached one keeps its own.
+func probeTokenSource(a account, tokenUrl string) (oauth2.TokenSource, error) {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+
+	key := a.Name + "\x00" + a.Token + "\x00" + a.ClientID + "\x00" + a.ClientSecret + "\x00" + tokenUrl
+	if ts, ok := probeTokenSources[key]; ok {
+		return ts, nil
+	}
+	transport, err := newTokenTransport()
+	if err != nil {
+		return nil, err
+	}
+	ts := newFailoverTokenSource(withTokenTransport(context.Background(), transport), a, append([]string{tokenUrl}, tokenURLFallbacks...))
+	probeTokenSources[key] = ts
+	return ts, nil
+}
+
+// probeClient returns the API client of the -accounts target, the status is
+// the HTTP status of the error. It reads the settings under the read lock,
+// the fetch itself runs without it so a reload doesn't wait for the probe.
+func probeClient(target string) (*rhsm.Client, int, error) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+
+	accounts, err := configuredAccounts()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	var a *account
+	for i := range accounts {
+		if accounts[i].Name != "" && accounts[i].Name == target {
+			a = &accounts[i]
+		}
+	}
+	if a == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("unknown target %q, must be one of -accounts", target)
+	}
+
+	transport, err := newAPITransport()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	transport.DisableKeepAlives = true
+	ts, err := probeTokenSource(*a, tokenURL())
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	client := &http.Client{Transport: &oauth2.Transport{Source: ts, Base: transport}, Timeout: httpTimeout}
+	return apiClient(client, apiURL(), a.Name), 0, nil
+}
+
+// probeHandler serves /probe?target=<account> like the blackbox exporter:
+// the subscriptions of the account from -accounts are fetched during the
+// scrape, so Prometheus controls the schedule and each account can have its
+// own scrape job
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "missing query parameter target", http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	c, status, err := probeClient(target)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	ctx := r.Context()
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+			defer cancel()
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	successGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether the subscriptions of the target were fetched successfully.",
+	})
+	durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "How long the fetch of the target took in seconds.",
+	})
+	registry.MustRegister(successGauge, durationGauge)
+
+	subs, err := c.FetchAll(ctx)
+	durationGauge.Set(time.Since(start).Seconds())
+
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if err != nil {
+		slog.Error("Probe failed", "account", target, "err", err)
+	} else {
+		for i := range subs {
+			subs[i].Account = target
+		}
+		// The probe exports the subscriptions like /metrics does
+		subs = prepareSubscriptions(subs)
+		m := collector.New(registry, collectorOptions([]string{target}))
+		m.Update(subs)
+		successGauge.Set(1)
+	}
+
+	promhttp.HandlerFor(labeledGatherer(registry), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// pushMetrics sends the subscription metrics, or aggregates of subs, to the
+// configured push backends after a successful fetch. A failing backend is
+// logged and counted, it doesn't fail the fetch or the other backends. Like
+// the metrics, the subscriptions are anonymized with -anonymize-labels. The
+// HTTP backends are requested with client.
+func pushMetrics(ctx context.Context, client *http.Client, subs []rhsm.Subscription) {
+	gatherer := labeledGatherer(subscriptionsRegistry)
+	subs = anonymizeSubscriptions(subs)
+	if remoteWriteURL != "" {
+		if err := pushRemoteWrite(ctx, client, gatherer); err != nil {
+			slog.Error("Error pushing to remote_write endpoint", "err", err)
+			RemoteWriteErrorsCounter.Inc()
+		}
+	}
+	if graphiteAddress != "" {
+		if err := pushGraphite(gatherer); err != nil {
+			slog.Error("Error pushing to Graphite", "address", graphiteAddress, "err", err)
+			GraphiteErrorsCounter.Inc()
+		}
+	}
+	if influxURL != "" {
+		if err := pushInflux(ctx, client, gatherer); err != nil {
+			slog.Error("Error writing to InfluxDB", "url", influxURL, "err", err)
+			InfluxErrorsCounter.Inc()
+		}
+	}
+	if statsdAddress != "" {
+		if err := pushStatsD(gatherer); err != nil {
+			slog.Error("Error pushing to StatsD", "address", statsdAddress, "err", err)
+			StatsDErrorsCounter.Inc()
+		}
+	}
+	if cloudWatchNamespace != "" {
+		if err := publishCloudWatch(ctx, subs); err != nil {
+			slog.Error("Error publishing to CloudWatch", "namespace", cloudWatchNamespace, "err", err)
+			CloudWatchErrorsCounter.Inc()
+		}
+	}
+	if zabbixServer != "" {
+		if err := pushZabbix(ctx, subs); err != nil {
+			slog.Error("Error pushing to Zabbix", "server", zabbixServer, "err", err)
+			ZabbixErrorsCounter.Inc()
+		}
+	}
+}
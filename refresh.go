@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	refreshBearerToken string
+	refreshMinInterval time.Duration
+
+	// lastManualRefresh is the time of the last refresh requested via
+	// /-/refresh, guarded by manualRefreshMu
+	lastManualRefresh time.Time
+	manualRefreshMu   sync.Mutex
+
+	ManualRefreshesCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_exporter_manual_refreshes_total",
+		Help: "Total number of refreshes requested via POST /-/refresh, by result: accepted, rate_limited, circuit_open or unauthorized.",
+	},
+		[]string{"result"})
+)
+
+func init() {
+	for _, result := range []string{"accepted", "rate_limited", "circuit_open", "unauthorized"} {
+		ManualRefreshesCounter.WithLabelValues(result)
+	}
+}
+
+// refreshHandler triggers a fetch on POST /-/refresh, e.g. right after
+// subscriptions were bought or renewed. With -refresh.bearer-token the request must
+// carry it as a bearer token. Refreshes are accepted at most once per
+// -refresh.min-interval, so the endpoint can't be used to hammer the API, and
+// not at all while the circuit is open.
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if refreshBearerToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(refreshBearerToken)) != 1 {
+			ManualRefreshesCounter.WithLabelValues("unauthorized").Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if circuit.isOpen() {
+		ManualRefreshesCounter.WithLabelValues("circuit_open").Inc()
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(circuitInterval.Seconds()))))
+		http.Error(w, fmt.Sprintf("the circuit is open after %d failed fetches, the fetch is retried every %s", circuitFailures, circuitInterval), http.StatusServiceUnavailable)
+		return
+	}
+
+	manualRefreshMu.Lock()
+	now := time.Now()
+	if wait := lastManualRefresh.Add(refreshMinInterval).Sub(now); wait > 0 {
+		manualRefreshMu.Unlock()
+		ManualRefreshesCounter.WithLabelValues("rate_limited").Inc()
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, fmt.Sprintf("a refresh was requested less than %s ago, retry in %s", refreshMinInterval, wait.Round(time.Second)), http.StatusTooManyRequests)
+		return
+	}
+	lastManualRefresh = now
+	manualRefreshMu.Unlock()
+
+	ManualRefreshesCounter.WithLabelValues("accepted").Inc()
+	if requestRefresh() {
+		slog.Info("Refresh requested", "remote", r.RemoteAddr)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "Refresh requested, the metrics are updated once the fetch finished")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+var relabelConfig string
+
+// relabelRule rewrites a field of every fetched subscription before it is
+// exported
+type relabelRule struct {
+	Field string `json:"field"`
+	// Action is replace (default), lowercase or uppercase
+	Action string `json:"action"`
+	// Regex must match the whole field for replace, default (.*)
+	Regex string `json:"regex"`
+	// Replacement may refer to the capture groups of Regex, e.g. $1
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// relabelFields are the fields rules can rewrite. The subscription number
+// identifies the series and can't be changed.
+var relabelFields = map[string]func(s *rhsm.Subscription) *string{
+	"account":          func(s *rhsm.Subscription) *string { return &s.Account },
+	"contractNumber":   func(s *rhsm.Subscription) *string { return &s.ContractNumber },
+	"subscriptionName": func(s *rhsm.Subscription) *string { return &s.SubscriptionName },
+	"status":           func(s *rhsm.Subscription) *string { return &s.Status },
+	"sku":              func(s *rhsm.Subscription) *string { return &s.SKU },
+	"serviceLevel":     func(s *rhsm.Subscription) *string { return &s.ServiceLevel },
+	"usage":            func(s *rhsm.Subscription) *string { return &s.Usage },
+	"role":             func(s *rhsm.Subscription) *string { return &s.Role },
+}
+
+// relabelRules are the parsed rules of -relabel
+var relabelRules []relabelRule
+
+// parseRelabelRules parses the json list of rules of -relabel
+func parseRelabelRules(config string) ([]relabelRule, error) {
+	if config == "" {
+		return nil, nil
+	}
+	var rules []relabelRule
+	if err := json.Unmarshal([]byte(config), &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		r := &rules[i]
+		if _, ok := relabelFields[r.Field]; !ok {
+			return nil, fmt.Errorf("rule %d: unknown field %q", i+1, r.Field)
+		}
+		switch r.Action {
+		case "":
+			r.Action = "replace"
+		case "replace", "lowercase", "uppercase":
+		default:
+			return nil, fmt.Errorf("rule %d: invalid action %q, must be replace, lowercase or uppercase", i+1, r.Action)
+		}
+		if r.Regex == "" {
+			r.Regex = "(.*)"
+		}
+		re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		r.re = re
+	}
+	return rules, nil
+}
+
+// apply rewrites the field of s if it matches the regex
+func (r relabelRule) apply(s *rhsm.Subscription) {
+	field := relabelFields[r.Field](s)
+	if !r.re.MatchString(*field) {
+		return
+	}
+	switch r.Action {
+	case "replace":
+		*field = r.re.ReplaceAllString(*field, r.Replacement)
+	case "lowercase":
+		*field = strings.ToLower(*field)
+	case "uppercase":
+		*field = strings.ToUpper(*field)
+	}
+}
+
+// relabelSubscriptions applies the rules in order to every subscription
+func relabelSubscriptions(subs []rhsm.Subscription) {
+	for i := range subs {
+		for _, r := range relabelRules {
+			r.apply(&subs[i])
+		}
+	}
+}
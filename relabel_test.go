@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+func TestRelabelRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		in      rhsm.Subscription
+		want    rhsm.Subscription
+		wantErr bool
+	}{
+		{
+			name:   "replace with capture group",
+			config: `[{"field": "sku", "regex": "RH(\\d+)", "replacement": "sku-$1"}]`,
+			in:     rhsm.Subscription{SKU: "RH00798"},
+			want:   rhsm.Subscription{SKU: "sku-00798"},
+		},
+		{
+			name:   "regex must match the whole field",
+			config: `[{"field": "sku", "regex": "RH", "replacement": "x"}]`,
+			in:     rhsm.Subscription{SKU: "RH00798"},
+			want:   rhsm.Subscription{SKU: "RH00798"},
+		},
+		{
+			name:   "rules apply in order",
+			config: `[{"field": "account", "action": "uppercase"}, {"field": "account", "regex": "ACME(.*)", "replacement": "acme$1"}]`,
+			in:     rhsm.Subscription{Account: "acme-emea"},
+			want:   rhsm.Subscription{Account: "acme-EMEA"},
+		},
+		{
+			name:   "lowercase",
+			config: `[{"field": "usage", "action": "lowercase"}]`,
+			in:     rhsm.Subscription{Usage: "Production"},
+			want:   rhsm.Subscription{Usage: "production"},
+		},
+		{name: "subscription number is fixed", config: `[{"field": "subscriptionNumber"}]`, wantErr: true},
+		{name: "unknown action", config: `[{"field": "sku", "action": "drop"}]`, wantErr: true},
+		{name: "invalid regex", config: `[{"field": "sku", "regex": "("}]`, wantErr: true},
+		{name: "invalid json", config: `{"field": "sku"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseRelabelRules(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRelabelRules() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := tt.in
+			for _, r := range rules {
+				r.apply(&got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relabeled = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRelabelImportFallback checks that the rules are applied once to the
+// subscriptions a failed import source keeps from the last fetch, not again
+// every cycle it fails
+func TestRelabelImportFallback(t *testing.T) {
+	defer func(rules []relabelRule) { relabelRules = rules }(relabelRules)
+	defer func() {
+		updateMu.Lock()
+		lastFetched = nil
+		updateMu.Unlock()
+	}()
+
+	var err error
+	// The rule isn't idempotent, applying it twice prefixes twice
+	if relabelRules, err = parseRelabelRules(`[{"field": "account", "replacement": "team-$1"}]`); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	up := map[string]bool{"a": true, "b": true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Path[1:]
+		if !up[source] {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]rhsm.Subscription{{SubscriptionNumber: source, Account: source, Quantity: "1", StartDate: start, EndDate: end}})
+	}))
+	defer srv.Close()
+	sources := []importSource{{name: "a", url: srv.URL + "/a"}, {name: "b", url: srv.URL + "/b"}}
+
+	tests := []struct {
+		name string
+		bUp  bool
+	}{
+		{name: "both sources", bUp: true},
+		{name: "b failed"},
+		{name: "b failed again"},
+		{name: "b back", bUp: true},
+	}
+	for _, tt := range tests {
+		up["b"] = tt.bUp
+		subs, err := fetchImports(context.Background(), srv.Client(), sources, importOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		// As in fetchLoop, the rules change a copy of the fetched rows
+		fetched := slices.Clone(subs)
+		relabelSubscriptions(subs)
+		updateMu.Lock()
+		lastFetched = fetched
+		updateMu.Unlock()
+
+		var accounts []string
+		for _, s := range subs {
+			accounts = append(accounts, s.Account)
+		}
+		if want := []string{"team-a", "team-b"}; !slices.Equal(accounts, want) {
+			t.Errorf("%s: accounts = %v, want %v", tt.name, accounts, want)
+		}
+	}
+}
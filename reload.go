@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// cliFlags are the flags given on the command line, a reload can't change them
+	cliFlags = map[string]bool{}
+	// configEnvFromFile are the env vars set from the config file, a reload
+	// may change them as opposed to env vars set by the environment
+	configEnvFromFile = map[string]bool{}
+	// reloadRequests is served by the fetch loop supervisor, which stops the
+	// fetch loop while the config is reloaded
+	reloadRequests = make(chan reloadRequest)
+	// settingsMu guards the flag values and the settings applySettings
+	// derives from them. A reload changes them under the write lock, the HTTP
+	// handlers and the scheduled tasks read them under the read lock. The
+	// fetch loop is stopped while they change.
+	settingsMu sync.RWMutex
+)
+
+// reloadRequest asks the fetch loop supervisor to run apply while the fetch
+// loop is stopped and to restart it with the new settings
+type reloadRequest struct {
+	apply func() error
+	resp  chan error
+}
+
+// listValue is implemented by repeatable flags, which are reset before they
+// are set by a reload, so their items are replaced instead of appended to
+type listValue interface {
+	flag.Value
+	reset()
+}
+
+// setFlag sets the flag name to value, replacing the items of a repeatable
+// flag
+func setFlag(name, value string) error {
+	if list, ok := flag.Lookup(name).Value.(listValue); ok {
+		list.reset()
+	}
+	return flag.Set(name, value)
+}
+
+// isReloadable reports whether the setting of a config key can change at
+// runtime. The web server, the profiler, OTLP, Vault, the leader election,
+// the secret watcher, the info and static labels, the schedule of the
+// notifications and the one-shot modes are set up only once.
+func isReloadable(key string) bool {
+	switch {
+	case strings.HasPrefix(key, "web."), strings.HasPrefix(key, "debug."), strings.HasPrefix(key, "otlp."), strings.HasPrefix(key, "vault."), strings.HasPrefix(key, "leader."), strings.HasPrefix(key, "export"), key == "secrets.watch", key == "notify.schedule", key == "metrics.info-labels", key == "labels":
+		return false
+	}
+	return true
+}
+
+// requestReload asks the fetch loop supervisor to reload the config and waits
+// for the result
+func requestReload(ctx context.Context) error {
+	return restartFetchLoop(ctx, func() error {
+		if err := reloadConfig(); err != nil {
+			return err
+		}
+		slog.Info("Reloaded config", "file", configFile)
+		return nil
+	})
+}
+
+// restartFetchLoop stops the fetch loop, runs apply and restarts the loop,
+// which reads the settings again, and waits for the result of apply. If apply
+// fails, the restarted loop keeps the schedule of the stopped one instead of
+// fetching right away.
+func restartFetchLoop(ctx context.Context, apply func() error) error {
+	resp := make(chan error, 1)
+	select {
+	case reloadRequests <- reloadRequest{apply: apply, resp: resp}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-resp:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reloadConfig re-reads the config file and applies the reloadable settings
+// that are not overridden by flags or env vars. Keys removed from the file
+// keep their current value. Nothing is changed if the new config is invalid.
+func reloadConfig() error {
+	if configFile == "" {
+		return errors.New("no config file given via -config")
+	}
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	values, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	flagValues := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		flagValues[f.Name] = f.Value.String()
+	})
+	envValues := map[string]string{}
+	for _, env := range configEnvVars {
+		envValues[env] = os.Getenv(env)
+	}
+	restore := func() {
+		for name, value := range flagValues {
+			setFlag(name, value)
+		}
+		for env, value := range envValues {
+			os.Setenv(env, value)
+		}
+	}
+
+	for key, value := range values {
+		env := configEnvVars[key]
+		if !isReloadable(key) || cliFlags[key] || (os.Getenv(env) != "" && !configEnvFromFile[env]) {
+			continue
+		}
+		os.Setenv(env, value)
+		configEnvFromFile[env] = true
+		if flag.Lookup(key) != nil {
+			if err := setFlag(key, value); err != nil {
+				restore()
+				return fmt.Errorf("invalid value for %s: %w", key, err)
+			}
+		}
+	}
+
+	if err := applySettings(); err != nil {
+		restore()
+		if err := applySettings(); err != nil {
+			return fmt.Errorf("failed to restore previous config: %w", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// withSettings serves h under the settings read lock, so a reload can't
+// change them halfway through a request
+func withSettings(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settingsMu.RLock()
+		defer settingsMu.RUnlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withSettingsTask runs a scheduled task under the settings read lock
+func withSettingsTask(task func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		settingsMu.RLock()
+		defer settingsMu.RUnlock()
+		return task(ctx)
+	}
+}
+
+// reloadHandler reloads the config on POST /-/reload, like /-/quit it is only
+// served with -web.enable-lifecycle
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !enableLifecycle {
+		http.Error(w, "Lifecycle API is not enabled.", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := requestReload(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	remoteWriteURL         string
+	remoteWriteUsername    string
+	remoteWritePassword    string
+	remoteWriteBearerToken string
+)
+
+// remoteWriteLabel is a label pair of a remote_write time series
+type remoteWriteLabel struct {
+	Name  string
+	Value string
+}
+
+// remoteWriteSeries is a single sample time series of a remote_write request
+type remoteWriteSeries struct {
+	Labels []remoteWriteLabel
+	Value  float64
+}
+
+// seriesFromFamilies flattens metric families into remote_write series,
+// expanding summaries and histograms like the text exposition does
+func seriesFromFamilies(families []*dto.MetricFamily, extra map[string]string) []remoteWriteSeries {
+	var series []remoteWriteSeries
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			add := func(name string, value float64, more ...remoteWriteLabel) {
+				labels := []remoteWriteLabel{{Name: "__name__", Value: name}}
+				for k, v := range extra {
+					labels = append(labels, remoteWriteLabel{Name: k, Value: v})
+				}
+				for _, lp := range m.GetLabel() {
+					labels = append(labels, remoteWriteLabel{Name: lp.GetName(), Value: lp.GetValue()})
+				}
+				labels = append(labels, more...)
+				slices.SortFunc(labels, func(a, b remoteWriteLabel) int { return strings.Compare(a.Name, b.Name) })
+				labels = slices.CompactFunc(labels, func(a, b remoteWriteLabel) bool { return a.Name == b.Name })
+				series = append(series, remoteWriteSeries{Labels: labels, Value: value})
+			}
+
+			name := mf.GetName()
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), remoteWriteLabel{Name: "quantile", Value: strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), remoteWriteLabel{Name: "le", Value: strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)})
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), remoteWriteLabel{Name: "le", Value: "+Inf"})
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf
+func encodeWriteRequest(series []remoteWriteSeries, timestamp time.Time) []byte {
+	var buf []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp.UnixMilli()))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
+
+// pushRemoteWrite sends the current metrics to the -remote-write.url endpoint
+func pushRemoteWrite(ctx context.Context, client *http.Client, gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	series := seriesFromFamilies(families, map[string]string{"job": "redhat-subscription-exporter"})
+	body := snappy.Encode(nil, encodeWriteRequest(series, time.Now()))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", remoteWriteURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if remoteWriteBearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+remoteWriteBearerToken)
+	} else if remoteWriteUsername != "" && remoteWritePassword != "" {
+		req.SetBasicAuth(remoteWriteUsername, remoteWritePassword)
+	}
+
+	_, err = rhsm.DoOnce(client, req)
+	return err
+}
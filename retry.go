@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+var (
+	retryMaxAttempts int
+	retryBackoff     time.Duration
+	retryMaxBackoff  time.Duration
+	// retryMaxRetryAfter and retryMaxRateLimited bound the waits for a
+	// rate-limiting API, even without -fetch.timeout
+	retryMaxRetryAfter  time.Duration
+	retryMaxRateLimited int
+)
+
+// retryPolicy returns the retry policy of the -retry.* flags
+func retryPolicy() rhsm.RetryPolicy {
+	return rhsm.RetryPolicy{
+		MaxAttempts:    retryMaxAttempts,
+		Backoff:        retryBackoff,
+		MaxBackoff:     retryMaxBackoff,
+		MaxRetryAfter:  retryMaxRetryAfter,
+		MaxRateLimited: retryMaxRateLimited,
+		OnRateLimited:  RateLimitedCounter.Inc,
+	}
+}
+
+// doWithRetry performs the request with the retry policy of the -retry.*
+// flags and returns the body of a 2xx response
+func doWithRetry(client *http.Client, req *http.Request) ([]byte, error) {
+	return retryPolicy().Do(client, req)
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"go.yaml.in/yaml/v2"
+)
+
+// rulesDefaultUsage is the utilization alerted on without -check.*-usage,
+// like assets/rules.yaml
+const rulesDefaultUsage = 0.95
+
+// rulesMinStale is the shortest time without a successful fetch alerted on,
+// short -fetch.interval would alert on a single failed fetch
+const rulesMinStale = 15 * time.Minute
+
+var (
+	rulesFormat    string
+	rulesNamespace string
+	rulesJob       string
+)
+
+// alertingRule is a rule of a Prometheus rule group
+type alertingRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// ruleGroups is the content of a Prometheus rules file and the spec of a
+// PrometheusRule
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string         `yaml:"name"`
+	Rules []alertingRule `yaml:"rules"`
+}
+
+// prometheusRule is the PrometheusRule resource of the Prometheus operator
+type prometheusRule struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace,omitempty"`
+	} `yaml:"metadata"`
+	Spec ruleGroups `yaml:"spec"`
+}
+
+// rulesSelector returns the selector of the -labels of this deployment, e.g.
+// {region="eu"}, so the rules of several deployments don't overlap
+func rulesSelector() string {
+	var matchers []string
+	for _, name := range slices.Sorted(maps.Keys(staticLabels)) {
+		matchers = append(matchers, name+"="+strconv.Quote(staticLabels[name]))
+	}
+	if len(matchers) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// newAlertingRule returns a rule firing after an hour
+func newAlertingRule(name, severity, expr, summary string) alertingRule {
+	return alertingRule{
+		Alert:       name,
+		Expr:        expr,
+		For:         "1h",
+		Labels:      map[string]string{"severity": severity},
+		Annotations: map[string]string{"summary": summary},
+	}
+}
+
+// generateRules returns the alerting rules for the -check.* thresholds and
+// the -labels. Expiry and utilization alert with the severity of the check
+// command, a disabled threshold leaves out its rule.
+func generateRules() ruleGroups {
+	sel := rulesSelector()
+	days := "redhat_subscription_days_remaining" + sel
+	utilization := "redhat_subscription_pool_utilization" + sel
+	var rules []alertingRule
+
+	if checkWarningDays > 0 {
+		lower := 0
+		if checkCriticalDays > 0 && checkCriticalDays < checkWarningDays {
+			lower = checkCriticalDays
+		}
+		rules = append(rules, newAlertingRule("RedHatSubscriptionExpiringSoon", "warning",
+			fmt.Sprintf("%s > %d and %s <= %d", days, lower, days, checkWarningDays),
+			"Red Hat subscription {{ $labels.subscriptionNumber }} expires in {{ $value }} days"))
+	}
+	if checkCriticalDays > 0 {
+		rules = append(rules, newAlertingRule("RedHatSubscriptionExpiringSoon", "critical",
+			fmt.Sprintf("%s > 0 and %s <= %d", days, days, checkCriticalDays),
+			"Red Hat subscription {{ $labels.subscriptionNumber }} expires in {{ $value }} days"))
+	}
+	rules = append(rules, newAlertingRule("RedHatSubscriptionExpired", "critical",
+		days+" <= 0",
+		"Red Hat subscription {{ $labels.subscriptionNumber }} has expired"))
+
+	// The -check.*-usage thresholds are percentages, the utilization a ratio
+	warning, critical := checkWarningUsage/100, checkCriticalUsage/100
+	if warning <= 0 && critical <= 0 {
+		warning = rulesDefaultUsage
+	}
+	summary := "Pool {{ $labels.pool }} of Red Hat subscription {{ $labels.subscriptionNumber }} is {{ $value | humanizePercentage }} consumed"
+	if warning > 0 {
+		expr := fmt.Sprintf("%s >= %s", utilization, strconv.FormatFloat(warning, 'f', -1, 64))
+		if critical > warning {
+			expr += fmt.Sprintf(" and %s < %s", utilization, strconv.FormatFloat(critical, 'f', -1, 64))
+		}
+		rules = append(rules, newAlertingRule("RedHatSubscriptionPoolExhausted", "warning", expr, summary))
+	}
+	if critical > 0 {
+		rules = append(rules, newAlertingRule("RedHatSubscriptionPoolExhausted", "critical",
+			fmt.Sprintf("%s >= %s", utilization, strconv.FormatFloat(critical, 'f', -1, 64)), summary))
+	}
+
+	rules = append(rules,
+		newAlertingRule("RedHatOfflineTokenExpiring", "warning",
+			"redhat_exporter_offline_token_expiring"+sel+" == 1",
+			"The Red Hat offline token of account {{ $labels.account }} expires soon unless it is used successfully"),
+		newAlertingRule("RedHatSubscriptionCollectorDown", "warning",
+			"redhat_exporter_collector_up"+sel+" == 0",
+			"Collector {{ $labels.collector }} of the Red Hat subscription exporter is failing"),
+	)
+
+	// Scrape failures: the target is down or gone, or the exporter is up
+	// but its data is older than a few fetches
+	job := "{job=" + strconv.Quote(rulesJob) + "}"
+	down := newAlertingRule("RedHatSubscriptionExporterDown", "critical",
+		"up"+job+" == 0",
+		"The Red Hat subscription exporter {{ $labels.instance }} can't be scraped")
+	down.For = "5m"
+	absent := newAlertingRule("RedHatSubscriptionExporterAbsent", "critical",
+		"absent(up"+job+")",
+		"No target of job "+rulesJob+" is scraped")
+	absent.For = "15m"
+	stale := max(3*time.Duration(fetchInterval), rulesMinStale)
+	rules = append(rules, down, absent, newAlertingRule("RedHatSubscriptionDataStale", "warning",
+		fmt.Sprintf("time() - redhat_subscription_data_timestamp_seconds%s > %d", sel, int(stale.Seconds())),
+		"The Red Hat subscriptions of {{ $labels.instance }} weren't fetched for "+model.Duration(stale).String()))
+
+	return ruleGroups{Groups: []ruleGroup{{Name: "redhat-subscription-exporter", Rules: rules}}}
+}
+
+// runRules prints the generated rules in the -rules.format and returns the
+// exit code
+func runRules() int {
+	var doc interface{} = generateRules()
+	if rulesFormat == "prometheusrule" {
+		rule := prometheusRule{APIVersion: "monitoring.coreos.com/v1", Kind: "PrometheusRule", Spec: generateRules()}
+		rule.Metadata.Name = "redhat-subscription-exporter"
+		rule.Metadata.Namespace = rulesNamespace
+		doc = rule
+	}
+	// Long expressions stay on one line
+	yaml.FutureLineWrap()
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
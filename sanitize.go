@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// sanitizeMaxLength truncates longer label values of the subscriptions, 0
+// keeps them
+var sanitizeMaxLength int
+
+// sanitizeValue replaces invalid UTF-8, drops control and invisible format
+// characters (e.g. zero-width spaces), trims and collapses whitespace and
+// truncates the value to maxLength characters, marking the cut with an
+// ellipsis
+func sanitizeValue(value string, maxLength int) string {
+	value = strings.ToValidUTF8(value, "�")
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.Join(strings.Fields(value), " ")
+	if maxLength > 0 && utf8.RuneCountInString(value) > maxLength {
+		value = strings.TrimSpace(string([]rune(value)[:maxLength-1])) + "…"
+	}
+	return value
+}
+
+// sanitizeSubscriptions normalizes the label values of subs, so series don't
+// differ only by invisible formatting of the API data. The subscription
+// number is only trimmed, it identifies the subscription.
+func sanitizeSubscriptions(subs []rhsm.Subscription) {
+	for i := range subs {
+		s := &subs[i]
+		s.SubscriptionNumber = strings.TrimSpace(s.SubscriptionNumber)
+		for name, field := range relabelFields {
+			value := field(s)
+			if sanitized := sanitizeValue(*value, sanitizeMaxLength); sanitized != *value {
+				slog.Debug("Sanitized label value", "subscription", s.SubscriptionNumber, "field", name, "value", *value, "sanitized", sanitized)
+				*value = sanitized
+			}
+		}
+	}
+}
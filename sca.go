@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	scaDetect      bool
+	scaConsumption string
+)
+
+var SCAEnabledGauge = promauto.With(subscriptionsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+	Name: "redhat_subscription_sca_enabled",
+	Help: "Whether the organization of the account is in Simple Content Access mode (1) or not (0).",
+},
+	[]string{"account"})
+
+var (
+	scaMu sync.Mutex
+	// scaModes is the detected SCA mode per account, guarded by scaMu
+	scaModes = map[string]bool{}
+)
+
+// scaAccounts returns the sorted accounts detected in SCA mode
+func scaAccounts() []string {
+	scaMu.Lock()
+	defer scaMu.Unlock()
+	var accounts []string
+	for name, enabled := range scaModes {
+		if enabled {
+			accounts = append(accounts, name)
+		}
+	}
+	slices.Sort(accounts)
+	return accounts
+}
+
+// setSCA records the detected SCA mode of an account
+func setSCA(name string, enabled bool) {
+	scaMu.Lock()
+	defer scaMu.Unlock()
+	if previous, ok := scaModes[name]; !ok || previous != enabled {
+		slog.Info("Detected content access mode", "account", name, "sca", enabled)
+	}
+	scaModes[name] = enabled
+	value := 0.0
+	if enabled {
+		value = 1
+	}
+	SCAEnabledGauge.WithLabelValues(name).Set(value)
+}
+
+// detectSCA detects whether the organizations of the accounts (or the
+// Candlepin owner) are in Simple Content Access mode. Accounts whose mode
+// can't be fetched keep their last known mode.
+func detectSCA(ctx context.Context, accounts []account, client *http.Client, apiUrl string) {
+	if fetchSource == "candlepin" {
+		enabled, err := candlepinClient(client).SCA(ctx)
+		if err != nil {
+			slog.Warn("Error detecting the content access mode", "err", err)
+			return
+		}
+		setSCA("", enabled)
+		return
+	}
+
+	for _, a := range accounts {
+		org, err := apiClient(a.client, accountURL(apiUrl, a), a.Name).FetchOrganization(ctx)
+		if err != nil {
+			slog.Warn("Error detecting the content access mode", "account", a.Name, "err", err)
+			continue
+		}
+		setSCA(a.Name, org.SCA())
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	fetchScheduleSpec  string
+	fetchSchedule      cron.Schedule
+	exportScheduleSpec string
+)
+
+var ScheduledTaskSkippedCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "redhat_exporter_scheduled_task_skipped_total",
+	Help: "Total number of scheduled runs skipped because the previous run was still in progress.",
+},
+	[]string{"task"})
+
+// parseSchedule parses a standard 5-field cron expression or a descriptor
+// like @daily, an empty spec returns nil
+func parseSchedule(flagName, spec string) (cron.Schedule, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", flagName, spec, err)
+	}
+	return schedule, nil
+}
+
+// scheduledTask is a task run by the scheduler or, for the fetch loop, only
+// tracked for /status
+type scheduledTask struct {
+	Name      string    `json:"name"`
+	Schedule  string    `json:"schedule"`
+	NextRun   time.Time `json:"next_run"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+	Running   bool      `json:"running"`
+
+	schedule cron.Schedule
+	run      func(context.Context) error
+}
+
+// scheduler runs tasks on cron schedules. A run is skipped while the
+// previous run of the same task is still in progress.
+type scheduler struct {
+	mu    sync.Mutex
+	tasks map[string]*scheduledTask
+	wake  chan struct{}
+}
+
+// tasks is the scheduler of all scheduled tasks
+var tasks = &scheduler{tasks: map[string]*scheduledTask{}, wake: make(chan struct{}, 1)}
+
+// add schedules fn, replacing a task of the same name
+func (s *scheduler) add(name, spec string, schedule cron.Schedule, fn func(context.Context) error) {
+	s.mu.Lock()
+	s.tasks[name] = &scheduledTask{Name: name, Schedule: spec, NextRun: schedule.Next(time.Now()), schedule: schedule, run: fn}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// track records the state of a task run outside the scheduler
+func (s *scheduler) track(name, spec string, next, last time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[name]
+	if !ok {
+		t = &scheduledTask{Name: name}
+		s.tasks[name] = t
+	}
+	t.Schedule = spec
+	t.NextRun = next
+	if !last.IsZero() {
+		t.LastRun = last
+		t.LastError = errorString(err)
+	}
+}
+
+// errorString returns the message of err or an empty string
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// run starts the due tasks until ctx is cancelled
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		now := time.Now()
+		next := now.Add(time.Hour)
+
+		s.mu.Lock()
+		for _, t := range s.tasks {
+			if t.run == nil {
+				continue
+			}
+			if !t.NextRun.After(now) {
+				if t.Running {
+					slog.Warn("Skipping scheduled run, the previous run is still in progress", "task", t.Name)
+					ScheduledTaskSkippedCounter.WithLabelValues(t.Name).Inc()
+				} else {
+					t.Running = true
+					go s.runTask(ctx, t)
+				}
+				t.NextRun = t.schedule.Next(now)
+			}
+			if t.NextRun.Before(next) {
+				next = t.NextRun
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// runTask runs a task once and records the result
+func (s *scheduler) runTask(ctx context.Context, t *scheduledTask) {
+	start := time.Now()
+	err := t.run(ctx)
+	if err != nil {
+		slog.Error("Scheduled run failed", "task", t.Name, "err", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.Running = false
+	t.LastRun = start
+	t.LastError = errorString(err)
+}
+
+// statusHandler lists the scheduled tasks with their next and last runs
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tasks": tasks.snapshot(),
+	})
+}
+
+// snapshot returns a copy of all tasks sorted by name
+func (s *scheduler) snapshot() []scheduledTask {
+	s.mu.Lock()
+	list := make([]scheduledTask, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		list = append(list, *t)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(list, func(a, b scheduledTask) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+)
+
+// searchResult is a subscription matching a search query
+type searchResult struct {
+	Score        int               `json:"score"`
+	Subscription rhsm.Subscription `json:"subscription"`
+}
+
+// fuzzyScore rates how well query matches value, 0 means no match. Exact
+// matches rank above prefixes, prefixes above substrings and substrings above
+// characters that merely appear in order.
+func fuzzyScore(query, value string) int {
+	value = strings.ToLower(value)
+	switch {
+	case value == "":
+		return 0
+	case value == query:
+		return 100
+	case strings.HasPrefix(value, query):
+		return 75
+	case strings.Contains(value, query):
+		return 50
+	}
+
+	// Subsequence match, the fewer characters skipped the better
+	qi, skipped := 0, 0
+	q := []rune(query)
+	for _, r := range value {
+		if qi == len(q) {
+			break
+		}
+		if r == q[qi] {
+			qi++
+		} else if qi > 0 {
+			skipped++
+		}
+	}
+	if qi < len(q) {
+		return 0
+	}
+	return max(1, 25-skipped)
+}
+
+// searchSubscriptions ranks subs by the best match of query against name,
+// SKU and contract number
+func searchSubscriptions(subs []rhsm.Subscription, query string, limit int) []searchResult {
+	query = strings.ToLower(strings.TrimSpace(query))
+	results := []searchResult{}
+	for _, sub := range subs {
+		score := max(
+			fuzzyScore(query, sub.SubscriptionName),
+			fuzzyScore(query, sub.SKU),
+			fuzzyScore(query, sub.ContractNumber),
+		)
+		if score > 0 {
+			results = append(results, searchResult{Score: score, Subscription: sub})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b searchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Subscription.SubscriptionNumber, b.Subscription.SubscriptionNumber)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// searchHandler serves /api/v1/search?q=, a quick lookup in the subscriptions
+// of the last fetch. ?limit= caps the number of results, default 20.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		http.Error(w, "missing query parameter q", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	updateMu.Lock()
+	results := searchSubscriptions(lastSubscriptions, query, limit)
+	updateMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   query,
+		"results": results,
+	})
+}
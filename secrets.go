@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// secretWatchDebounce is the time to wait for more changes of the secret
+// directories, Kubernetes updates every file of a secret at once
+const secretWatchDebounce = time.Second
+
+var (
+	secretsWatch bool
+
+	// secretFiles are the files read via the *_FILE convention by env var
+	secretFilesMu sync.Mutex
+	secretFiles   = map[string]string{}
+
+	SecretReloadsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_secret_reloads_total",
+		Help: "Total number of times the API clients were rebuilt because a secret file of the *_FILE convention changed.",
+	})
+)
+
+// watchSecretFile records that the secret of key was read from path
+func watchSecretFile(key, path string) {
+	secretFilesMu.Lock()
+	defer secretFilesMu.Unlock()
+	secretFiles[key] = path
+}
+
+// readSecretFiles returns the trimmed content of every secret file by env var
+func readSecretFiles() map[string]string {
+	secretFilesMu.Lock()
+	files := make(map[string]string, len(secretFiles))
+	for key, path := range secretFiles {
+		files[key] = path
+	}
+	secretFilesMu.Unlock()
+
+	values := make(map[string]string, len(files))
+	for key, path := range files {
+		if data, err := os.ReadFile(path); err == nil {
+			values[key] = strings.TrimSpace(string(data))
+		}
+	}
+	return values
+}
+
+// secretFlag returns the flag set by the secret env var key, empty for the
+// account credentials, which are read again whenever the fetch loop starts
+func secretFlag(key string) string {
+	for name, env := range configEnvVars {
+		if env == key && flag.Lookup(name) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// applySecrets sets the flags of the changed secrets, unless they were given
+// on the command line or can't change at runtime
+func applySecrets(changed map[string]string) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for key, value := range changed {
+		name := secretFlag(key)
+		if name == "" || cliFlags[name] || !isReloadable(name) {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchSecrets restarts the fetch loop whenever the content of a secret file
+// changes, so it rebuilds the HTTP and OAuth clients with the rotated
+// credentials. The directories are watched and the files compared after
+// every change, Kubernetes replaces a mounted secret by swapping the ..data
+// symlink instead of writing to the files.
+func watchSecrets(ctx context.Context) error {
+	last := readSecretFiles()
+	if len(last) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	secretFilesMu.Lock()
+	for _, path := range secretFiles {
+		if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	secretFilesMu.Unlock()
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				debounce = time.After(secretWatchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Error watching secret files", "err", err)
+			case <-debounce:
+				debounce = nil
+				current := readSecretFiles()
+				changed := map[string]string{}
+				var vars []string
+				for key, value := range current {
+					if value != last[key] {
+						changed[key] = value
+						vars = append(vars, key+"_FILE")
+					}
+				}
+				if len(changed) == 0 {
+					continue
+				}
+				slices.Sort(vars)
+				slog.Info("Secret files changed, rebuilding the API clients", "vars", vars)
+				if err := restartFetchLoop(ctx, func() error { return applySecrets(changed) }); err != nil {
+					slog.Error("Error applying the changed secrets", "err", err)
+					continue
+				}
+				last = current
+				SecretReloadsCounter.Inc()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	selfcheckAllowNaNList string
+	selfcheckAllowNaN     []string
+)
+
+var SelfcheckFailuresCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "redhat_exporter_selfcheck_failures_total",
+	Help: "Total number of failed consistency checks of the exposed subscription metrics.",
+},
+	[]string{"check"})
+
+// selfcheckChecks are the names of the consistency checks, initialized so the
+// counters are exported before the first failure
+var selfcheckChecks = []string{"missing_series", "nan", "count"}
+
+func init() {
+	for _, check := range selfcheckChecks {
+		SelfcheckFailuresCounter.WithLabelValues(check)
+	}
+}
+
+// runSelfCheck validates the subscription metrics after an update against
+// the fetched subscriptions: every info series has quantity, start and end
+// series, no value is NaN unless allowed by -selfcheck.allow-nan and the
+// number of current info series matches the fetched records. Failures are
+// logged and counted, the metrics are served regardless.
+func runSelfCheck(subs []rhsm.Subscription) {
+	families, err := subscriptionsRegistry.Gather()
+	if err != nil {
+		slog.Error("Selfcheck failed to gather metrics", "err", err)
+		return
+	}
+
+	bySubscription := map[string]map[string]bool{}
+	var infos []*dto.Metric
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			if m.GetGauge() != nil && math.IsNaN(m.GetGauge().GetValue()) && !slices.Contains(selfcheckAllowNaN, mf.GetName()) {
+				selfcheckFailed("nan", "%s{%s} is NaN", mf.GetName(), labelString(m))
+			}
+			number := labelValue(m, "subscriptionNumber")
+			if number == "" {
+				continue
+			}
+			if bySubscription[number] == nil {
+				bySubscription[number] = map[string]bool{}
+			}
+			bySubscription[number][mf.GetName()] = true
+			if mf.GetName() == "redhat_subscription_info" {
+				infos = append(infos, m)
+			}
+		}
+	}
+
+	start, end := "redhat_subscription_start", "redhat_subscription_end"
+	if metricsCompat == "new" {
+		start, end = "redhat_subscription_start_timestamp_seconds", "redhat_subscription_end_timestamp_seconds"
+	}
+
+	// Values that can't be parsed are left out by the update and counted in
+	// redhat_subscription_parse_errors_total instead
+	opts := collectorOptions(nil)
+	required := map[string][]string{}
+	for _, s := range subs {
+		var names []string
+		if _, _, err := opts.Quantity(s.Quantity); err == nil {
+			names = append(names, "redhat_subscription_quantity")
+		}
+		if !s.StartDate.IsZero() {
+			names = append(names, start)
+		}
+		if !s.EndDate.IsZero() {
+			names = append(names, end)
+		}
+		required[s.SubscriptionNumber] = names
+	}
+
+	current := 0
+	for _, m := range infos {
+		number := labelValue(m, "subscriptionNumber")
+		names, ok := required[number]
+		if !ok {
+			// A stale subscription missing from the fetch
+			names = []string{start, end}
+		}
+		for _, name := range names {
+			if !bySubscription[number][name] {
+				selfcheckFailed("missing_series", "subscription %s has an info series but no %s", number, name)
+			}
+		}
+		if labelValue(m, "stale") == "false" {
+			current++
+		}
+	}
+
+	if current != len(required) {
+		selfcheckFailed("count", "%d current info series for %d fetched subscriptions", current, len(required))
+	}
+}
+
+// selfcheckFailed logs and counts a failed check
+func selfcheckFailed(check, format string, args ...interface{}) {
+	slog.Warn("Selfcheck failed", "check", check, "detail", fmt.Sprintf(format, args...))
+	SelfcheckFailuresCounter.WithLabelValues(check).Inc()
+}
+
+// labelValue returns the value of the label name of m
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// labelString formats the labels of m for log messages
+func labelString(m *dto.Metric) string {
+	s := ""
+	for i, l := range m.Label {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%s=%q", l.GetName(), l.GetValue())
+	}
+	return s
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+// runServiceCommand runs the Windows service commands, there are none on
+// other platforms
+func runServiceCommand() (int, bool) {
+	return 0, false
+}
+
+// startService returns ctx, the exporter only runs as a service on Windows
+func startService(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
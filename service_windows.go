@@ -0,0 +1,215 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name of the Windows service and its event log source
+const serviceName = "redhat-subscription-exporter"
+
+func init() {
+	commands = append(commands,
+		subcommand{"install-service", "Install the exporter as a Windows service started with the given flags"},
+		subcommand{"uninstall-service", "Remove the Windows service"},
+	)
+}
+
+// runServiceCommand runs the install-service and uninstall-service commands
+func runServiceCommand() (int, bool) {
+	var err error
+	switch command {
+	case "install-service":
+		err = installService()
+	case "uninstall-service":
+		err = uninstallService()
+	default:
+		return 0, false
+	}
+	if err != nil {
+		slog.Error("Service command failed", "command", command, "service", serviceName, "err", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// installService registers the executable as an automatically started
+// service with the flags of the command line and an event log source
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	args := slices.Clone(os.Args[1:])
+	if i := slices.Index(args, command); i >= 0 {
+		args = slices.Delete(args, i, i+1)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Red Hat Subscription Exporter",
+		Description: "Exports Red Hat subscriptions as Prometheus metrics",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to create the event log source: %w", err)
+	}
+	slog.Info("Installed service", "service", serviceName, "args", args)
+	return nil
+}
+
+// uninstallService removes the service and its event log source
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		return fmt.Errorf("failed to remove the event log source: %w", err)
+	}
+	slog.Info("Removed service", "service", serviceName)
+	return nil
+}
+
+// serviceHandler reports the state of the exporter to the service control
+// manager and cancels the exporter when the service is stopped
+type serviceHandler struct {
+	cancel context.CancelFunc
+	// done is closed once the exporter shut down
+	done chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-h.done:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: 15000}
+				h.cancel()
+				select {
+				case <-h.done:
+				case <-time.After(15 * time.Second):
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// startService runs the exporter as a Windows service when started by the
+// service control manager, logging to the event log. The returned context
+// is cancelled when the service is stopped, stopped must be called once the
+// exporter shut down.
+func startService(ctx context.Context) (context.Context, func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return ctx, func() {}
+	}
+
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		slog.SetDefault(slog.New(&eventlogHandler{elog: elog, next: slog.Default().Handler(), mu: &sync.Mutex{}}))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	h := &serviceHandler{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		if err := svc.Run(serviceName, h); err != nil {
+			slog.Error("Service failed", "service", serviceName, "err", err)
+		}
+		cancel()
+	}()
+	var once sync.Once
+	return ctx, func() { once.Do(func() { close(h.done) }) }
+}
+
+// eventlogHandler writes the log messages enabled by next to the Windows
+// event log, formatted like -log.format text
+type eventlogHandler struct {
+	elog  *eventlog.Log
+	next  slog.Handler
+	attrs []slog.Attr
+	group string
+	mu    *sync.Mutex
+}
+
+func (h *eventlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *eventlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var buf bytes.Buffer
+	var text slog.Handler = slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// The event log records the time and level itself
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	if h.group != "" {
+		text = text.WithGroup(h.group)
+	}
+	if err := text.WithAttrs(h.attrs).Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := buf.String()
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.elog.Error(1, msg)
+	case r.Level >= slog.LevelWarn:
+		return h.elog.Warning(1, msg)
+	}
+	return h.elog.Info(1, msg)
+}
+
+func (h *eventlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(slices.Clone(h.attrs), attrs...)
+	return &c
+}
+
+func (h *eventlogHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.group = name
+	return &c
+}
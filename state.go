@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	stateFile string
+
+	DataTimestampGauge = promauto.With(exporterRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "redhat_subscription_data_timestamp_seconds",
+		Help: "Unix timestamp of the fetch the exported subscriptions are from, older than the last fetch after a restart from -state.file.",
+	})
+)
+
+// state is the snapshot of the last successful fetch in -state.file, Fetched
+// are the subscriptions before they were relabeled
+type state struct {
+	FetchedAt     time.Time           `json:"fetched_at"`
+	Subscriptions []rhsm.Subscription `json:"subscriptions"`
+	Fetched       []rhsm.Subscription `json:"fetched,omitempty"`
+}
+
+// saveState writes the snapshot to path atomically, so a crash can't leave a
+// truncated state file behind
+func saveState(path string, subs, fetched []rhsm.Subscription, fetchedAt time.Time) error {
+	data, err := json.Marshal(state{FetchedAt: fetchedAt, Subscriptions: subs, Fetched: fetched})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadState exports the snapshot of path until the first fetch succeeds. A
+// missing file is not an error, there is just nothing to restore yet.
+func loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	defaultSubscriptionMetrics.Update(s.Subscriptions)
+	lastSubscriptions = s.Subscriptions
+	lastFetched = s.Fetched
+	lastDataTime = s.FetchedAt
+	dataTimestampMs.Store(s.FetchedAt.UnixMilli())
+	DataTimestampGauge.Set(float64(s.FetchedAt.Unix()))
+	ready.Store(true)
+	slog.Info("Restored subscriptions from state file", "file", path, "count", len(s.Subscriptions), "fetched_at", s.FetchedAt)
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// statsdMaxPacket is the largest datagram sent to -statsd.address, small
+// enough for the MTU of most networks
+const statsdMaxPacket = 1432
+
+// statsdTimeout bounds a push to -statsd.address
+const statsdTimeout = 15 * time.Second
+
+var (
+	statsdAddress   string
+	statsdPrefix    string
+	statsdTagFormat string
+
+	StatsDErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_statsd_errors_total",
+		Help: "Total number of failed pushes to the -statsd.address StatsD endpoint.",
+	})
+)
+
+// statsdReplacer replaces the characters of the StatsD line format in names
+// and tags
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", " ")
+
+// statsdLines formats series as StatsD gauges. DogStatsD tags carry the
+// labels, with -statsd.tag-format=none the label values are appended to the
+// name instead. Counters are sent as gauges too, StatsD counters are deltas.
+func statsdLines(series []remoteWriteSeries) []string {
+	var lines []string
+	for _, s := range series {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		var name string
+		var tags []string
+		for _, l := range s.Labels {
+			switch {
+			case l.Name == "__name__":
+				name = l.Value
+			case statsdTagFormat == "none":
+				if l.Value != "" {
+					tags = append(tags, statsdReplacer.Replace(l.Value))
+				}
+			default:
+				tags = append(tags, l.Name+":"+statsdReplacer.Replace(l.Value))
+			}
+		}
+		if statsdPrefix != "" {
+			name = statsdPrefix + "." + name
+		}
+		line := statsdReplacer.Replace(name)
+		if statsdTagFormat == "none" {
+			for _, tag := range tags {
+				line += "." + strings.ReplaceAll(tag, ".", "_")
+			}
+		}
+		line += ":" + strconv.FormatFloat(s.Value, 'f', -1, 64) + "|g"
+		if statsdTagFormat != "none" && len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// pushStatsD sends the current metrics as gauges to the -statsd.address
+// StatsD or DogStatsD endpoint over UDP, batching several lines per
+// datagram
+func pushStatsD(gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	conn, err := net.DialTimeout("udp", statsdAddress, statsdTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(statsdTimeout)); err != nil {
+		return err
+	}
+
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range statsdLines(seriesFromFamilies(families, nil)) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
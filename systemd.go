@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// systemdSocket serves on the sockets passed by systemd socket activation
+// instead of -web.listen-address
+var systemdSocket bool
+
+// fetchHealthy reports whether the fetch loop makes progress and the last
+// successful fetch is recent, see -watchdog.multiple and
+// -health.stale-multiple
+func fetchHealthy(now time.Time) bool {
+	interval := time.Duration(fetchInterval)
+	if staleFor(now, interval) > 0 {
+		return false
+	}
+	timeout := watchdogTimeout(interval)
+	return timeout <= 0 || now.Sub(time.Unix(0, lastHeartbeat.Load())) <= timeout
+}
+
+// systemdNotify tells systemd the service is ready once the first fetch
+// succeeded and pings the systemd watchdog (WatchdogSec=) while the fetch
+// loop is healthy, so systemd restarts a wedged exporter. Nothing is sent
+// unless the service runs with Type=notify.
+func systemdNotify(ctx context.Context) {
+	if ok, err := daemon.SdNotify(false, "STATUS=Waiting for the first fetch"); !ok {
+		if err != nil {
+			slog.Warn("Error notifying systemd", "err", err)
+		}
+		return
+	}
+	watchdogInterval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		slog.Warn("Invalid systemd watchdog settings", "err", err)
+	}
+
+	tick := time.Second
+	if watchdogInterval > 0 {
+		tick = min(tick, watchdogInterval/2)
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	notified := false
+	for {
+		select {
+		case <-ctx.Done():
+			daemon.SdNotify(false, daemon.SdNotifyStopping)
+			return
+		case now := <-ticker.C:
+			if !notified && fetched.Load() {
+				notified = true
+				updateMu.Lock()
+				count := len(lastSubscriptions)
+				updateMu.Unlock()
+				daemon.SdNotify(false, fmt.Sprintf("%s\nSTATUS=Exporting %d subscriptions", daemon.SdNotifyReady, count))
+			}
+			if watchdogInterval > 0 && fetchHealthy(now) {
+				daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var collectSystems bool
+
+var systemsRegistry = prometheus.NewRegistry()
+
+var (
+	SystemsGauge = promauto.With(systemsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "redhat_subscription_systems",
+		Help: "Number of systems registered to the account.",
+	})
+	SystemsByTypeGauge = promauto.With(systemsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_systems_by_type",
+		Help: "Number of registered systems by type, e.g. Physical, Virtual or Hypervisor.",
+	},
+		[]string{"type"})
+	SystemsByEntitlementStatusGauge = promauto.With(systemsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_systems_by_entitlement_status",
+		Help: "Number of registered systems by entitlement status, e.g. valid, invalid, partial or unentitled.",
+	},
+		[]string{"status"})
+)
+
+func init() {
+	optionalCollectors = append(optionalCollectors, &optionalCollector{
+		name:     "systems",
+		path:     "systems",
+		enabled:  &collectSystems,
+		registry: systemsRegistry,
+		collect:  collectSystemMetrics,
+	})
+}
+
+// collectSystemMetrics fetches the registered systems and counts them
+func collectSystemMetrics(ctx context.Context, client *rhsm.Client) error {
+	systems, err := client.FetchSystems(ctx)
+	if err != nil {
+		return err
+	}
+
+	byType := map[string]int{}
+	byStatus := map[string]int{}
+	for _, s := range systems {
+		byType[valueOrUnknown(s.Type)]++
+		byStatus[valueOrUnknown(s.EntitlementStatus)]++
+	}
+
+	SystemsGauge.Set(float64(len(systems)))
+	SystemsByTypeGauge.Reset()
+	for t, n := range byType {
+		SystemsByTypeGauge.WithLabelValues(t).Set(float64(n))
+	}
+	SystemsByEntitlementStatusGauge.Reset()
+	for status, n := range byStatus {
+		SystemsByEntitlementStatusGauge.WithLabelValues(status).Set(float64(n))
+	}
+	return nil
+}
+
+// valueOrUnknown returns "unknown" for an empty label value
+func valueOrUnknown(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	sampleTimestamps bool
+
+	// dataTimestampMs is the unix milli timestamp of the fetch the exported
+	// subscriptions are from, lastDataTime without taking updateMu
+	dataTimestampMs atomic.Int64
+)
+
+// timestampedGatherer sets the timestamp of the fetch the subscriptions are
+// from on every sample with -metrics.timestamps, so a scrape of cached or
+// restored data is recognizable downstream. Samples without data yet are
+// left untouched.
+func timestampedGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		ts := dataTimestampMs.Load()
+		if !sampleTimestamps || ts == 0 {
+			return families, err
+		}
+		for _, mf := range families {
+			for _, m := range mf.Metric {
+				m.TimestampMs = &ts
+			}
+		}
+		return families, err
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+var (
+	tokenURLFallbackList string
+	tokenURLFallbacks    []string
+	tokenRefreshBefore   time.Duration
+	// apiClientID is the OAuth client the offline tokens are issued for
+	apiClientID   string
+	apiScopesList string
+	apiScopes     []string
+)
+
+var (
+	TokenRefreshesCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_sso_token_refreshes_total",
+		Help: "Total number of access tokens obtained from SSO.",
+	},
+		[]string{"account"})
+	TokenRefreshFailuresCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_sso_token_refresh_failures_total",
+		Help: "Total number of failed access token requests to SSO, one per token URL tried.",
+	},
+		[]string{"account"})
+	TokenExpiryGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_sso_token_expiry_timestamp_seconds",
+		Help: "Unix timestamp the current access token expires at.",
+	},
+		[]string{"account"})
+)
+
+// failoverTokenSource refreshes the access token shortly before it expires,
+// trying the fallback token URLs when the primary one fails. When every token
+// URL is unreachable the cached access token is used until it really expires.
+// With a client secret the client-credentials grant of a service account is
+// used instead of the offline refresh token.
+type failoverTokenSource struct {
+	ctx          context.Context
+	account      string
+	clientID     string
+	clientSecret string
+	tokenURLs    []string
+	refreshToken string
+	// tokenFile is re-read when it changes, so the token can be rotated
+	tokenFile    string
+	tokenModTime time.Time
+	// vault supplies the refresh token when the offline token lives in Vault
+	vault      *vaultClient
+	vaultToken string
+	age        *offlineTokenAge
+
+	// parent is the span of the current fetch cycle, the parent of the token
+	// refresh spans, as oauth2.Transport doesn't pass the request context
+	parent atomic.Value
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// newFailoverTokenSource creates a token source for the credentials of a at
+// the given token URLs in order. If the account has a token file, the refresh
+// token is re-read from it whenever the file changes.
+func newFailoverTokenSource(ctx context.Context, a account, tokenURLs []string) *failoverTokenSource {
+	s := &failoverTokenSource{
+		ctx:       ctx,
+		account:   a.Name,
+		clientID:  apiClientID,
+		tokenURLs: tokenURLs,
+	}
+	// The counters are exported before the first refresh
+	TokenRefreshesCounter.WithLabelValues(a.Name)
+	TokenRefreshFailuresCounter.WithLabelValues(a.Name)
+	if a.ClientSecret != "" {
+		s.clientID = a.ClientID
+		s.clientSecret = a.ClientSecret
+		return s
+	}
+
+	s.refreshToken = a.Token
+	s.tokenFile = a.TokenFile
+	s.vault = vaultSource
+	s.age = &offlineTokenAge{account: a.Name}
+	s.age.setToken(a.Token)
+	if vaultSource != nil {
+		s.vaultToken = a.Token
+	}
+	if a.TokenFile != "" {
+		if fi, err := os.Stat(a.TokenFile); err == nil {
+			s.tokenModTime = fi.ModTime()
+		}
+	}
+	return s
+}
+
+// checkTokenFile picks up a rotated refresh token and drops the access token
+// obtained with the old one
+func (s *failoverTokenSource) checkTokenFile() {
+	if s.tokenFile == "" {
+		return
+	}
+	fi, err := os.Stat(s.tokenFile)
+	if err != nil || fi.ModTime().Equal(s.tokenModTime) {
+		return
+	}
+	data, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		slog.Error("Error reading offline token file", "file", s.tokenFile, "err", err)
+		return
+	}
+	s.tokenModTime = fi.ModTime()
+	if token := strings.TrimSpace(string(data)); token != "" && token != s.refreshToken {
+		slog.Info("Offline token file changed, using the new token", "file", s.tokenFile)
+		s.refreshToken = token
+		s.token = nil
+		s.age.setToken(token)
+	}
+}
+
+// checkVault picks up an offline token rotated in Vault
+func (s *failoverTokenSource) checkVault() {
+	if s.vault == nil {
+		return
+	}
+	if token := s.vault.OfflineToken(); token != "" && token != s.vaultToken {
+		if s.vaultToken != "" {
+			slog.Info("Offline token in vault changed, using the new token")
+		}
+		s.vaultToken = token
+		s.refreshToken = token
+		s.token = nil
+		s.age.setToken(token)
+	}
+}
+
+// setCycle makes the span of ctx the parent of the token refreshes of the
+// fetch cycle
+func (s *failoverTokenSource) setCycle(ctx context.Context) {
+	s.parent.Store(trace.SpanFromContext(ctx))
+}
+
+// Token implements oauth2.TokenSource
+func (s *failoverTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkTokenFile()
+	s.checkVault()
+
+	if s.token != nil && s.token.Valid() && time.Until(s.token.Expiry) > tokenRefreshBefore {
+		return s.token, nil
+	}
+
+	var errs []error
+	for _, tokenURL := range s.tokenURLs {
+		var ts oauth2.TokenSource
+		if s.clientSecret != "" {
+			conf := &clientcredentials.Config{
+				ClientID:     s.clientID,
+				ClientSecret: s.clientSecret,
+				TokenURL:     tokenURL,
+				Scopes:       apiScopes,
+			}
+			ts = conf.TokenSource(s.ctx)
+		} else if len(apiScopes) > 0 {
+			// The refresh of oauth2.Config doesn't send scopes, the client
+			// credentials source does and may send the refresh grant instead
+			conf := &clientcredentials.Config{
+				ClientID: s.clientID,
+				TokenURL: tokenURL,
+				Scopes:   apiScopes,
+				EndpointParams: url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {s.refreshToken},
+				},
+			}
+			ts = conf.TokenSource(s.ctx)
+		} else {
+			conf := &oauth2.Config{
+				ClientID: s.clientID,
+				Endpoint: oauth2.Endpoint{
+					TokenURL: tokenURL,
+				},
+			}
+			ts = conf.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.refreshToken})
+		}
+		spanCtx := s.ctx
+		if parent, ok := s.parent.Load().(trace.Span); ok {
+			spanCtx = trace.ContextWithSpan(s.ctx, parent)
+		}
+		_, span := tracer.Start(spanCtx, "token refresh", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("token.url", tokenURL)))
+		token, err := ts.Token()
+		endSpan(span, err)
+		if err != nil {
+			TokenRefreshFailuresCounter.WithLabelValues(s.account).Inc()
+			s.age.failed(err)
+			errs = append(errs, fmt.Errorf("%s: %w", tokenURL, err))
+			continue
+		}
+		if token.RefreshToken != "" {
+			s.refreshToken = token.RefreshToken
+		}
+		TokenRefreshesCounter.WithLabelValues(s.account).Inc()
+		if !token.Expiry.IsZero() {
+			TokenExpiryGauge.WithLabelValues(s.account).Set(float64(token.Expiry.Unix()))
+		}
+		s.age.used()
+		s.token = token
+		return token, nil
+	}
+
+	err := errors.Join(errs...)
+	s.age.check()
+	if s.token != nil && s.token.Valid() {
+		slog.Warn("Token refresh failed, using cached access token", "expiry", s.token.Expiry.Format(time.RFC3339), "err", err)
+		return s.token, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/oauth2"
+)
+
+var (
+	tokenInactivityWindow time.Duration
+	tokenInactivityWarn   time.Duration
+)
+
+var (
+	OfflineTokenIssuedGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_exporter_offline_token_issued_timestamp_seconds",
+		Help: "Unix timestamp the current offline token was issued at.",
+	},
+		[]string{"account"})
+	OfflineTokenLastUsedGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_exporter_offline_token_last_used_timestamp_seconds",
+		Help: "Unix timestamp the offline token was last exchanged for an access token.",
+	},
+		[]string{"account"})
+	OfflineTokenInactivityDeadlineGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_exporter_offline_token_inactivity_deadline_timestamp_seconds",
+		Help: "Unix timestamp the offline token expires at if it isn't used until then.",
+	},
+		[]string{"account"})
+	OfflineTokenExpiringGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_exporter_offline_token_expiring",
+		Help: "1 if the offline token expires within -api.token-inactivity-warn unless it is used, or expired already, else 0.",
+	},
+		[]string{"account"})
+	OfflineTokenRejectedCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "redhat_exporter_offline_token_rejected_total",
+		Help: "Total number of token refreshes rejected with invalid_grant, usually an expired or revoked offline token.",
+	},
+		[]string{"account"})
+)
+
+// tokenClaims decodes the claims of a JWT token into v, false if the token
+// is no JWT
+func tokenClaims(token string, v interface{}) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+// tokenIssuedAt returns the iat claim of a JWT offline token, or the zero
+// time if the token is no JWT
+func tokenIssuedAt(token string) time.Time {
+	var claims struct {
+		IssuedAt int64 `json:"iat"`
+	}
+	if !tokenClaims(token, &claims) || claims.IssuedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.IssuedAt, 0)
+}
+
+// offlineTokenAge tracks the issue and last use of the offline token of an
+// account. Red Hat expires offline tokens after 30 days without use, which
+// would otherwise look like any other auth error. A nil offlineTokenAge, used
+// with client credentials, tracks nothing.
+type offlineTokenAge struct {
+	account  string
+	issued   time.Time
+	lastUsed time.Time
+	warned   bool
+}
+
+// setToken records a new offline token
+func (a *offlineTokenAge) setToken(token string) {
+	if a == nil {
+		return
+	}
+	a.issued = tokenIssuedAt(token)
+	a.lastUsed = time.Time{}
+	a.warned = false
+	if a.issued.IsZero() {
+		OfflineTokenIssuedGauge.DeleteLabelValues(a.account)
+	} else {
+		OfflineTokenIssuedGauge.WithLabelValues(a.account).Set(float64(a.issued.Unix()))
+	}
+	a.check()
+}
+
+// used records a successful exchange of the offline token
+func (a *offlineTokenAge) used() {
+	if a == nil {
+		return
+	}
+	a.lastUsed = time.Now()
+	a.warned = false
+	OfflineTokenLastUsedGauge.WithLabelValues(a.account).Set(float64(a.lastUsed.Unix()))
+	a.check()
+}
+
+// failed explains an invalid_grant rejection of the offline token
+func (a *offlineTokenAge) failed(err error) {
+	if a == nil {
+		return
+	}
+	var rerr *oauth2.RetrieveError
+	if !errors.As(err, &rerr) || rerr.ErrorCode != "invalid_grant" {
+		return
+	}
+	OfflineTokenRejectedCounter.WithLabelValues(a.account).Inc()
+	last := a.lastUsed
+	if last.IsZero() {
+		last = a.issued
+	}
+	if !last.IsZero() && time.Since(last) > tokenInactivityWindow {
+		slog.Error("Offline token was rejected, it probably expired after the inactivity window, generate a new one", "account", a.account, "last_used", last.Format(time.RFC3339), "window", tokenInactivityWindow)
+	} else {
+		slog.Error("Offline token was rejected as invalid_grant, it may have been revoked or expired", "account", a.account)
+	}
+}
+
+// check exports the inactivity deadline and warns once when it comes close
+func (a *offlineTokenAge) check() {
+	if a == nil {
+		return
+	}
+	last := a.lastUsed
+	if last.IsZero() {
+		last = a.issued
+	}
+	if last.IsZero() {
+		return
+	}
+	deadline := last.Add(tokenInactivityWindow)
+	OfflineTokenInactivityDeadlineGauge.WithLabelValues(a.account).Set(float64(deadline.Unix()))
+	expiring := time.Until(deadline) < tokenInactivityWarn
+	if expiring {
+		OfflineTokenExpiringGauge.WithLabelValues(a.account).Set(1)
+	} else {
+		OfflineTokenExpiringGauge.WithLabelValues(a.account).Set(0)
+	}
+	if !a.warned && expiring {
+		if time.Now().After(deadline) {
+			slog.Warn("Offline token was not used within the inactivity window and has probably expired", "account", a.account, "last_used", last.Format(time.RFC3339), "deadline", deadline.Format(time.RFC3339))
+		} else {
+			slog.Warn("Offline token expires unless it is used successfully before", "account", a.account, "deadline", deadline.Format(time.RFC3339))
+		}
+		a.warned = true
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// maxClockSkew is the difference between the local clock and SSO from which
+// the token check warns about clock skew
+const maxClockSkew = 30 * time.Second
+
+// verifyToken enables the token check on startup
+var verifyToken bool
+
+// verifyTokens exchanges the offline token (or client credentials) of every
+// account once before the fetch loop starts. A token SSO rejects is a fatal
+// error with a diagnostic of the likely cause, an unreachable SSO is only
+// logged, the fetch loop retries it.
+func verifyTokens(ctx context.Context) error {
+	accounts, err := configuredAccounts()
+	if err != nil {
+		return err
+	}
+	tokenTransport, err := newTokenTransport()
+	if err != nil {
+		return err
+	}
+	defer tokenTransport.CloseIdleConnections()
+
+	tokenUrl := tokenURL()
+	var errs []error
+	for _, a := range accounts {
+		ts := newFailoverTokenSource(withTokenTransport(ctx, tokenTransport), a, append([]string{tokenUrl}, tokenURLFallbacks...))
+		token, err := ts.Token()
+		if err != nil {
+			diagnosis, fatal := diagnoseTokenError(err, a, tokenUrl)
+			if !fatal {
+				slog.Warn("Token exchange failed, retrying in the fetch loop", "account", a.Name, "diagnosis", diagnosis, "err", err)
+				continue
+			}
+			slog.Error("Token exchange failed", "account", a.Name, "diagnosis", diagnosis, "err", err)
+			if a.Name != "" {
+				diagnosis = fmt.Sprintf("account %s: %s", a.Name, diagnosis)
+			}
+			errs = append(errs, errors.New(diagnosis))
+			continue
+		}
+		if skew, ok := tokenClockSkew(token.AccessToken, time.Now()); ok && skew.Abs() > maxClockSkew {
+			slog.Warn("The local clock differs from the SSO server, tokens may be rejected as not yet valid or expired", "account", a.Name, "skew", skew.Round(time.Second))
+		}
+		slog.Info("Verified token", "account", a.Name, "expiry", token.Expiry.Format(time.RFC3339))
+	}
+	return errors.Join(errs...)
+}
+
+// diagnoseTokenError explains why the token exchange of an account failed and
+// whether the error is fatal, i.e. retrying can't help
+func diagnoseTokenError(err error, a account, tokenUrl string) (string, bool) {
+	var rerr *oauth2.RetrieveError
+	if !errors.As(err, &rerr) {
+		return "SSO is unreachable, check the network, the proxy and RH_TOKEN_URL", false
+	}
+	if rerr.Response != nil && rerr.Response.StatusCode >= 500 {
+		return fmt.Sprintf("SSO returned %s", rerr.Response.Status), false
+	}
+
+	var skew string
+	if rerr.Response != nil {
+		if date, err := http.ParseTime(rerr.Response.Header.Get("Date")); err == nil {
+			if d := time.Since(date); d.Abs() > maxClockSkew {
+				skew = fmt.Sprintf(", the local clock is off by %s compared to SSO", d.Round(time.Second))
+			}
+		}
+	}
+	description := strings.ToLower(rerr.ErrorDescription)
+
+	switch {
+	case a.ClientSecret != "" && (rerr.ErrorCode == "invalid_client" || rerr.ErrorCode == "unauthorized_client"):
+		return "the service account credentials were rejected, check RH_CLIENT_ID and RH_CLIENT_SECRET" + skew, true
+	case rerr.ErrorCode == "invalid_client" || rerr.ErrorCode == "unauthorized_client":
+		return fmt.Sprintf("the offline token was not issued for the OAuth client %q, check -api.offline-client-id and RH_TOKEN_URL%s", apiClientID, skew), true
+	case strings.Contains(description, "issuer") || realmMismatch(a.Token, tokenUrl):
+		return fmt.Sprintf("the offline token was issued by realm %q but is exchanged at realm %q, check RH_TOKEN_URL%s", tokenRealm(a.Token), urlRealm(tokenUrl), skew), true
+	case skew != "" && (strings.Contains(description, "not active") || strings.Contains(description, "expired")):
+		return "the token is not active" + skew + ", fix the system clock (e.g. NTP)", true
+	case rerr.ErrorCode == "invalid_grant":
+		return "the offline token expired or was revoked, offline tokens expire after 30 days without use, generate a new one at https://access.redhat.com/management/api" + skew, true
+	}
+	return fmt.Sprintf("SSO rejected the token with %q", rerr.ErrorCode) + skew, true
+}
+
+// tokenRealm returns the realm of the iss claim of a JWT token
+func tokenRealm(token string) string {
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if !tokenClaims(token, &claims) {
+		return ""
+	}
+	return urlRealm(claims.Issuer)
+}
+
+// urlRealm returns the Keycloak realm of an SSO URL
+func urlRealm(u string) string {
+	_, rest, ok := strings.Cut(u, "/realms/")
+	if !ok {
+		return ""
+	}
+	realm, _, _ := strings.Cut(rest, "/")
+	return realm
+}
+
+// realmMismatch reports whether the offline token was issued by another realm
+// than the one of the token URL
+func realmMismatch(token, tokenUrl string) bool {
+	issued, used := tokenRealm(token), urlRealm(tokenUrl)
+	return issued != "" && used != "" && issued != used
+}
+
+// tokenClockSkew returns the difference between now and the iat claim of a
+// fresh JWT access token, which SSO set to its own clock
+func tokenClockSkew(accessToken string, now time.Time) (time.Duration, bool) {
+	var claims struct {
+		IssuedAt int64 `json:"iat"`
+	}
+	if !tokenClaims(accessToken, &claims) || claims.IssuedAt == 0 {
+		return 0, false
+	}
+	return now.Sub(time.Unix(claims.IssuedAt, 0)), true
+}
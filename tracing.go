@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var otlpTraces bool
+
+// tracer creates the spans of the fetch cycles, a no-op until startTracing
+// installs a provider
+var tracer = otel.Tracer("github.com/dadav/redhat-subscription-exporter")
+
+// startTracing exports the spans of the fetch cycles, token refreshes and API
+// requests to an OpenTelemetry collector, configured like startOTLP via the
+// OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER env vars
+func startTracing(ctx context.Context) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch protocol := otlpProtocol("TRACES"); protocol {
+	case "grpc":
+		exporter, err = otlptracegrpc.New(ctx)
+	case "", "http/protobuf":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, must be grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res, err := otlpResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// endSpan records err on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/version"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+)
+
+var (
+	tlsCAFile             string
+	tlsCertFile           string
+	tlsKeyFile            string
+	tlsInsecureSkipVerify bool
+	proxyURL              string
+	proxyTokenURL         string
+	noProxy               string
+	httpTimeout           time.Duration
+	httpDialTimeout       time.Duration
+	fetchTimeout          time.Duration
+	userAgentSuffix       string
+	apiRateLimit          float64
+	apiRateBurst          int
+)
+
+// userAgent identifies the exporter in all outgoing requests, as Red Hat asks
+// API consumers to do
+func userAgent() string {
+	ua := "redhat-subscription-exporter/" + version.Version
+	if userAgentSuffix != "" {
+		ua += " " + userAgentSuffix
+	}
+	return ua
+}
+
+// APIRequestsCounter counts the requests of the API and token transports
+var APIRequestsCounter = promauto.With(exporterRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "redhat_subscription_api_requests_total",
+	Help: "Total number of requests to the API, the token endpoint and the imports by endpoint and HTTP status code, error if no response was received.",
+},
+	[]string{"endpoint", "code"})
+
+// idSegment matches the path segments of IDs like system UUIDs, which are
+// replaced in the endpoint label to keep its cardinality bounded
+var idSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F-]+)$`)
+
+// requestEndpoint returns the path of u with IDs replaced by {id}
+func requestEndpoint(u *url.URL) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		if idSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// apiLimiter is the token bucket of -api.rate-limit shared by all API
+// requests, the pages of a fetch as well as the optional collectors
+var apiLimiter = rate.NewLimiter(rate.Inf, 1)
+
+var RateLimitWaitCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+	Name: "redhat_exporter_api_rate_limit_wait_seconds_total",
+	Help: "Total time API requests waited for the -api.rate-limit token bucket.",
+})
+
+// setAPIRateLimit applies -api.rate-limit and -api.rate-burst, 0 disables the
+// limit
+func setAPIRateLimit(rps float64, burst int) {
+	limit := rate.Inf
+	if rps > 0 {
+		limit = rate.Limit(rps)
+	}
+	apiLimiter.SetLimit(limit)
+	apiLimiter.SetBurst(burst)
+}
+
+// apiTransport is an http.Transport setting the User-Agent header and
+// counting the requests by endpoint and status code. With limiter the
+// requests wait for its token bucket.
+type apiTransport struct {
+	*http.Transport
+	limiter *rate.Limiter
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.limiter != nil {
+		start := time.Now()
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			return nil, fmt.Errorf("waiting for -api.rate-limit: %w", err)
+		}
+		if waited := time.Since(start); waited > time.Millisecond {
+			RateLimitWaitCounter.Add(waited.Seconds())
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := t.Transport.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	APIRequestsCounter.WithLabelValues(requestEndpoint(req.URL), code).Inc()
+	return resp, err
+}
+
+// newAPITransport returns the transport for the API and import requests with
+// the -tls.*, -proxy.* and -api.rate-limit options applied
+func newAPITransport() (*apiTransport, error) {
+	t, err := newTransport(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	t.limiter = apiLimiter
+	return t, nil
+}
+
+// newTokenTransport returns the transport for the SSO token requests, which
+// may use a different proxy than the API
+func newTokenTransport() (*apiTransport, error) {
+	if proxyTokenURL != "" {
+		return newTransport(proxyTokenURL)
+	}
+	return newTransport(proxyURL)
+}
+
+// newTransport returns a transport with the -tls.* options using the given
+// HTTP, HTTPS or SOCKS5 proxy. Without a proxy the standard proxy env vars
+// apply.
+func newTransport(proxy string) (*apiTransport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: tlsInsecureSkipVerify}
+
+	if tlsCAFile != "" {
+		pem, err := os.ReadFile(tlsCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -tls.ca-file: %w", err)
+		}
+		// Extend the system roots, a corporate proxy CA usually comes on top
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in -tls.ca-file %s", tlsCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return nil, errors.New("-tls.cert-file and -tls.key-file must be set together")
+	}
+	if tlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{Timeout: httpDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q, must be http, https or socks5", u.Scheme)
+		}
+		proxyFunc := (&httpproxy.Config{HTTPProxy: proxy, HTTPSProxy: proxy, NoProxy: noProxy}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	return &apiTransport{Transport: transport}, nil
+}
+
+// withTokenTransport makes the oauth2 token requests made with ctx use
+// transport
+func withTokenTransport(ctx context.Context, transport http.RoundTripper) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport, Timeout: httpTimeout})
+}
+
+// withFetchTimeout bounds a whole fetch cycle including retries by
+// -fetch.timeout, 0 disables the deadline
+func withFetchTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if fetchTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, fetchTimeout)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var historyTrendWindow time.Duration
+
+var (
+	TrendBaselineGauge = promauto.With(exporterRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "redhat_subscription_trend_baseline_timestamp_seconds",
+		Help: "Unix timestamp of the fetch in -history.db the trends compare with, the oldest one if the history is shorter than -history.trend-window.",
+	})
+	SKUQuantityDeltaGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_sku_quantity_delta",
+		Help: "Change of the summed quantity of the subscriptions of a SKU since the trend baseline, Unlimited quantities excluded.",
+	},
+		[]string{"account", "sku"})
+	RenewalsGauge = promauto.With(exporterRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redhat_subscription_renewals",
+		Help: "Number of subscriptions active at the trend baseline that ended since, by whether a subscription of the same SKU continues them (renewed) or not (lapsed).",
+	},
+		[]string{"account", "result"})
+)
+
+// snapshotBefore returns the subscriptions of the latest fetch at or before
+// t, or of the oldest fetch if there is none. ok is false for an empty
+// history.
+func (h *historyStore) snapshotBefore(t time.Time) (fetchedAt time.Time, subs []rhsm.Subscription, ok bool, err error) {
+	var unix, snapshot int64
+	err = h.db.QueryRow(`SELECT fetched_at, snapshot_id FROM fetches WHERE fetched_at <= ? ORDER BY fetched_at DESC LIMIT 1`, t.Unix()).Scan(&unix, &snapshot)
+	if err == sql.ErrNoRows {
+		err = h.db.QueryRow(`SELECT fetched_at, snapshot_id FROM fetches ORDER BY fetched_at LIMIT 1`).Scan(&unix, &snapshot)
+	}
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil, false, nil
+	}
+	if err != nil {
+		return time.Time{}, nil, false, err
+	}
+
+	rows, err := h.db.Query(`SELECT account, subscription_number, subscription_name, sku, contract_number, quantity, status, start_date, end_date
+		FROM subscriptions WHERE snapshot_id = ?`, snapshot)
+	if err != nil {
+		return time.Time{}, nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s rhsm.Subscription
+		var start, end sql.NullInt64
+		if err := rows.Scan(&s.Account, &s.SubscriptionNumber, &s.SubscriptionName, &s.SKU, &s.ContractNumber, &s.Quantity, &s.Status, &start, &end); err != nil {
+			return time.Time{}, nil, false, err
+		}
+		if start.Valid {
+			s.StartDate = time.Unix(start.Int64, 0)
+		}
+		if end.Valid {
+			s.EndDate = time.Unix(end.Int64, 0)
+		}
+		subs = append(subs, s)
+	}
+	return time.Unix(unix, 0), subs, true, rows.Err()
+}
+
+// skuKey identifies a SKU of an account
+type skuKey struct {
+	account, sku string
+}
+
+// sumQuantities sums the numeric quantities of subs per SKU
+func sumQuantities(subs []rhsm.Subscription) map[skuKey]float64 {
+	sums := map[skuKey]float64{}
+	for _, s := range subs {
+		key := skuKey{s.Account, s.SKU}
+		if _, ok := sums[key]; !ok {
+			sums[key] = 0
+		}
+		if q, err := strconv.ParseFloat(s.Quantity, 64); err == nil && q >= 0 {
+			sums[key] += q
+		}
+	}
+	return sums
+}
+
+// updateTrends compares the current subscriptions with the baseline of
+// -history.trend-window ago in the history database
+func updateTrends(h *historyStore, current []rhsm.Subscription, now time.Time) error {
+	baselineAt, baseline, ok, err := h.snapshotBefore(now.Add(-historyTrendWindow))
+	if err != nil || !ok {
+		return err
+	}
+	current, _ = collector.MergeDuplicates(current)
+	TrendBaselineGauge.Set(float64(baselineAt.Unix()))
+
+	before, after := sumQuantities(baseline), sumQuantities(current)
+	SKUQuantityDeltaGauge.Reset()
+	for key, q := range after {
+		SKUQuantityDeltaGauge.WithLabelValues(key.account, key.sku).Set(q - before[key])
+	}
+	for key, q := range before {
+		if _, ok := after[key]; !ok {
+			SKUQuantityDeltaGauge.WithLabelValues(key.account, key.sku).Set(-q)
+		}
+	}
+
+	// A subscription is continued by any subscription of its SKU, including
+	// itself with a new end date, that didn't end yet
+	continued := map[skuKey]bool{}
+	for _, s := range current {
+		if s.EndDate.After(now) {
+			continued[skuKey{s.Account, s.SKU}] = true
+		}
+	}
+	RenewalsGauge.Reset()
+	for _, s := range baseline {
+		if s.EndDate.IsZero() || !s.EndDate.After(baselineAt) || s.EndDate.After(now) {
+			continue
+		}
+		result := "lapsed"
+		if continued[skuKey{s.Account, s.SKU}] {
+			result = "renewed"
+		}
+		RenewalsGauge.WithLabelValues(s.Account, result).Inc()
+	}
+	for _, account := range accountsOf(baseline) {
+		RenewalsGauge.WithLabelValues(account, "renewed").Add(0)
+		RenewalsGauge.WithLabelValues(account, "lapsed").Add(0)
+	}
+	return nil
+}
+
+// accountsOf returns the distinct accounts of subs
+func accountsOf(subs []rhsm.Subscription) []string {
+	var accounts []string
+	seen := map[string]bool{}
+	for _, s := range subs {
+		if !seen[s.Account] {
+			seen[s.Account] = true
+			accounts = append(accounts, s.Account)
+		}
+	}
+	return accounts
+}
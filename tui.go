@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"golang.org/x/term"
+)
+
+var (
+	tuiRefreshInterval time.Duration
+	tuiRows            int
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiDim    = "\x1b[2m"
+)
+
+// logBuffer keeps the last lines written to it, so log messages can be shown
+// inside the dashboard instead of scrolling it away
+type logBuffer struct {
+	mu    sync.Mutex
+	size  int
+	lines []string
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.size {
+		b.lines = b.lines[len(b.lines)-b.size:]
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) last() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.lines)
+}
+
+// runTUI runs the fetch loop like the exporter does and shows a live
+// dashboard in the terminal until q or Ctrl-C is pressed
+func runTUI(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logs := &logBuffer{size: 5}
+	logOutput = logs
+	defer func() { logOutput = os.Stderr }()
+	if err := setupLogging(); err != nil {
+		return err
+	}
+
+	// In raw mode Ctrl-C doesn't raise SIGINT, so the keys are read here
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err == nil {
+			defer term.Restore(fd, state)
+			go readTUIKeys(cancel)
+		}
+	}
+
+	// Switch to the alternate screen and hide the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	done := make(chan error, 1)
+	metricsLoop(ctx, done)
+
+	ticker := time.NewTicker(tuiRefreshInterval)
+	defer ticker.Stop()
+	for {
+		var buf bytes.Buffer
+		renderTUI(&buf, logs.last())
+		os.Stdout.Write(buf.Bytes())
+
+		select {
+		case err := <-done:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// readTUIKeys cancels the dashboard on q, Q, Ctrl-C or Ctrl-D
+func readTUIKeys(cancel context.CancelFunc) {
+	key := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(key); err != nil {
+			return
+		}
+		switch key[0] {
+		case 'q', 'Q', 3, 4:
+			cancel()
+			return
+		}
+	}
+}
+
+// renderTUI draws the dashboard: the fetch status, the subscriptions
+// expiring next and the most utilized pools
+func renderTUI(w io.Writer, logs []string) {
+	width := 100
+	if cols, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && cols > 0 {
+		width = cols
+	}
+
+	updateMu.Lock()
+	subs := slices.Clone(lastSubscriptions)
+	updateMu.Unlock()
+	now := currentTime()
+
+	// The terminal is in raw mode, so every line ends with \r\n
+	line := func(format string, args ...any) {
+		s := fmt.Sprintf(format, args...)
+		fmt.Fprint(w, s, "\x1b[K\r\n")
+	}
+
+	fmt.Fprint(w, "\x1b[H")
+	line("%sredhat-subscription-exporter %s%s  %s%s%s", ansiBold, version.Version, ansiReset, ansiDim, now.Format(time.RFC1123), ansiReset)
+	line("")
+
+	line("%sFetch%s", ansiBold, ansiReset)
+	status := ansiYellow + "waiting for first fetch" + ansiReset
+	if ready.Load() {
+		status = ansiGreen + "ok" + ansiReset
+	}
+	for _, t := range tasks.snapshot() {
+		if t.Name != "fetch" {
+			continue
+		}
+		if t.LastError != "" {
+			status = ansiRed + "failed: " + truncate(t.LastError, width-20) + ansiReset
+		}
+		if !t.LastRun.IsZero() {
+			line("  last run:      %s (%s ago)", t.LastRun.Format(time.RFC3339), now.Sub(t.LastRun).Round(time.Second))
+		}
+		line("  next run:      %s (in %s)", t.NextRun.Format(time.RFC3339), t.NextRun.Sub(now).Round(time.Second))
+	}
+	line("  status:        %s", status)
+	line("  subscriptions: %d", len(subs))
+	line("  fetch errors:  %.0f", counterValue(FetchErrorsCounter))
+	line("")
+
+	line("%sExpiring soon%s", ansiBold, ansiReset)
+	active := slices.DeleteFunc(slices.Clone(subs), func(s rhsm.Subscription) bool { return !s.EndDate.After(now) })
+	slices.SortFunc(active, func(a, b rhsm.Subscription) int { return a.EndDate.Compare(b.EndDate) })
+	if len(active) == 0 {
+		line("  %snone%s", ansiDim, ansiReset)
+	}
+	for _, s := range active[:min(tuiRows, len(active))] {
+		days := int(s.EndDate.Sub(now).Hours() / 24)
+		color := ""
+		switch {
+		case days < 30:
+			color = ansiRed
+		case days < 90:
+			color = ansiYellow
+		}
+		line("  %s%5dd%s  %s  %-12s %s", color, days, ansiReset, s.EndDate.Format("2006-01-02"), s.SKU, truncate(s.SubscriptionName, width-36))
+	}
+	line("")
+
+	line("%sPool utilization%s", ansiBold, ansiReset)
+	type pool struct {
+		sku, name       string
+		consumed, total float64
+		utilization     float64
+	}
+	var pools []pool
+	opts := collectorOptions(nil)
+	for _, s := range subs {
+		divisor := collector.CountingModeDivisors[opts.CountingMode(s.SKU)]
+		for _, p := range s.Pools {
+			if p.Quantity <= 0 {
+				continue
+			}
+			pools = append(pools, pool{
+				sku:         s.SKU,
+				name:        s.SubscriptionName,
+				consumed:    float64(p.Consumed) / divisor,
+				total:       float64(p.Quantity) / divisor,
+				utilization: float64(p.Consumed) / float64(p.Quantity),
+			})
+		}
+	}
+	slices.SortStableFunc(pools, func(a, b pool) int {
+		if a.utilization != b.utilization {
+			if a.utilization > b.utilization {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.sku, b.sku)
+	})
+	if len(pools) == 0 {
+		line("  %snone%s", ansiDim, ansiReset)
+	}
+	for _, p := range pools[:min(tuiRows, len(pools))] {
+		line("  %s %4.0f%%  %6.0f/%-6.0f %-12s %s", utilizationBar(p.utilization, 20), p.utilization*100, p.consumed, p.total, p.sku, truncate(p.name, width-60))
+	}
+	line("")
+
+	line("%sLog%s", ansiBold, ansiReset)
+	for _, l := range logs {
+		line("  %s%s%s", ansiDim, truncate(l, width-4), ansiReset)
+	}
+	line("")
+	line("%sPress q to quit%s", ansiDim, ansiReset)
+	// Clear whatever is left of the previous, longer frame
+	fmt.Fprint(w, "\x1b[J")
+}
+
+// utilizationBar draws a bar of the given width, colored by utilization
+func utilizationBar(utilization float64, width int) string {
+	filled := min(int(utilization*float64(width)+0.5), width)
+	color := ansiGreen
+	switch {
+	case utilization >= 0.9:
+		color = ansiRed
+	case utilization >= 0.75:
+		color = ansiYellow
+	}
+	return "[" + color + strings.Repeat("#", filled) + ansiReset + strings.Repeat(".", width-filled) + "]"
+}
+
+// truncate shortens s to n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if n <= 0 {
+		return ""
+	}
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// counterValue returns the current value of c
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		slog.Debug("Error reading counter", "err", err)
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
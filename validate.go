@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"golang.org/x/oauth2"
+)
+
+// validateReport prints the result of the validation steps aligned
+type validateReport struct {
+	out    io.Writer
+	failed bool
+}
+
+func (r *validateReport) ok(step, format string, args ...interface{}) {
+	fmt.Fprintf(r.out, "%-8s OK      %s\n", step, fmt.Sprintf(format, args...))
+}
+
+func (r *validateReport) fail(step string, err error) {
+	r.failed = true
+	fmt.Fprintf(r.out, "%-8s FAILED  %v\n", step, err)
+}
+
+func (r *validateReport) skip(step string) {
+	fmt.Fprintf(r.out, "%-8s SKIPPED\n", step)
+}
+
+// runValidate checks the config, exchanges the offline token of every
+// account at SSO and fetches and decodes one page of subscriptions, printing
+// which step failed. It returns the exit code.
+func runValidate(ctx context.Context) int {
+	r := &validateReport{out: os.Stdout}
+	if configFile != "" {
+		r.ok("config", "%s", configFile)
+	} else {
+		r.ok("config", "flags and env vars")
+	}
+
+	if len(importSources) > 0 || importFile != "" || fetchSource != "rhsm" {
+		validateFetch(ctx, r)
+	} else if err := validateAccounts(ctx, r); err != nil {
+		r.fail("config", err)
+	}
+
+	if r.failed {
+		return 1
+	}
+	return 0
+}
+
+// validateFetch fetches once from the sources without an offline token
+func validateFetch(ctx context.Context, r *validateReport) {
+	fetchOnce = true
+	done := make(chan error, 1)
+	metricsLoop(ctx, done)
+	if err := <-done; err != nil {
+		r.fail("fetch", err)
+		return
+	}
+	updateMu.Lock()
+	count := len(lastSubscriptions)
+	updateMu.Unlock()
+	r.ok("fetch", "%d subscriptions", count)
+}
+
+// validateAccounts runs the SSO, API and parsing steps for every account
+func validateAccounts(ctx context.Context, r *validateReport) error {
+	accounts, err := configuredAccounts()
+	if err != nil {
+		return err
+	}
+	transport, err := newAPITransport()
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	tokenTransport, err := newTokenTransport()
+	if err != nil {
+		return err
+	}
+	defer tokenTransport.CloseIdleConnections()
+
+	tokenUrl := tokenURL()
+	apiUrl := apiURL()
+	for _, a := range accounts {
+		if a.Name != "" {
+			fmt.Fprintf(r.out, "account %s:\n", a.Name)
+		}
+		ts := newFailoverTokenSource(withTokenTransport(ctx, tokenTransport), a, append([]string{tokenUrl}, tokenURLFallbacks...))
+		client := &http.Client{Transport: &oauth2.Transport{Source: ts, Base: transport}, Timeout: httpTimeout}
+		validateAccount(ctx, r, ts, client, apiUrl)
+	}
+	return nil
+}
+
+// validateAccount exchanges the token and fetches the first page without
+// retries, so each step reports its own error
+func validateAccount(ctx context.Context, r *validateReport, ts oauth2.TokenSource, client *http.Client, apiUrl string) {
+	token, err := ts.Token()
+	if err != nil {
+		r.fail("sso", err)
+		r.skip("api")
+		r.skip("parsing")
+		return
+	}
+	r.ok("sso", "access token valid until %s", token.Expiry.Format(time.RFC3339))
+
+	limit := pageSize
+	if limit <= 0 {
+		limit = rhsm.DefaultPageSize
+	}
+	sep := "?"
+	if strings.Contains(apiUrl, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s%slimit=%d&offset=0", apiUrl, sep, limit), nil)
+	if err != nil {
+		r.fail("api", err)
+		r.skip("parsing")
+		return
+	}
+	body, err := rhsm.DoOnce(client, req)
+	if err != nil {
+		r.fail("api", err)
+		r.skip("parsing")
+		return
+	}
+
+	var page struct {
+		rhsm.Page
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.Unmarshal(body, &page)
+	if err == nil && page.Error != nil {
+		r.fail("api", &rhsm.APIError{Code: page.Error.Code, Message: page.Error.Message})
+		r.skip("parsing")
+		return
+	}
+	r.ok("api", "%d bytes from %s", len(body), req.URL.Redacted())
+	if err != nil {
+		r.fail("parsing", err)
+		return
+	}
+	r.ok("parsing", "%d of %d subscriptions on the first page", len(page.Body), page.Pagination.Count)
+}
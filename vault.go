@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	vaultAddress       string
+	vaultPath          string
+	vaultKey           string
+	vaultAuth          string
+	vaultRole          string
+	vaultMount         string
+	vaultJWTFile       string
+	vaultApproleRoleID string
+	vaultApproleSecret string
+	vaultTokenForAuth  string
+	vaultRefreshEvery  time.Duration
+	vaultCAFile        string
+	vaultNamespace     string
+	vaultSource        *vaultClient
+)
+
+// vaultClient reads the offline token from a Vault KV secret and keeps its
+// Vault token renewed
+type vaultClient struct {
+	client *http.Client
+
+	mu           sync.Mutex
+	token        string
+	renewable    bool
+	leaseExpiry  time.Time
+	offlineToken string
+}
+
+// vaultAuthResponse is the payload of Vault login and renew requests
+type vaultAuthResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// vaultSecretResponse is the payload of a KV v1 or v2 read
+type vaultSecretResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// newVaultClient logs in and reads the offline token once
+func newVaultClient(ctx context.Context) (*vaultClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if vaultCAFile != "" {
+		// Vault usually has a certificate of a private CA, it is trusted in
+		// addition to the system roots
+		pem, err := os.ReadFile(vaultCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -vault.ca-file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in -vault.ca-file %s", vaultCAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	v := &vaultClient{client: &http.Client{Transport: transport, Timeout: 30 * time.Second}}
+	if err := v.login(ctx); err != nil {
+		return nil, err
+	}
+	if err := v.readOfflineToken(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// OfflineToken returns the last offline token read from Vault
+func (v *vaultClient) OfflineToken() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.offlineToken
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out
+func (v *vaultClient) do(ctx context.Context, method, path, token string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	url := strings.TrimSuffix(vaultAddress, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if vaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", vaultNamespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && resp.StatusCode < 300 {
+		return fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("vault %s %s: HTTP %d", method, path, resp.StatusCode)
+	}
+	return nil
+}
+
+// login authenticates with the configured -vault.auth method
+func (v *vaultClient) login(ctx context.Context) error {
+	var body map[string]string
+	switch vaultAuth {
+	case "token":
+		token := getSecretEnv("VAULT_TOKEN")
+		if token == "" {
+			return errors.New("-vault.auth=token requires VAULT_TOKEN or VAULT_TOKEN_FILE")
+		}
+		v.mu.Lock()
+		v.token = token
+		v.renewable = false
+		v.mu.Unlock()
+		return nil
+	case "kubernetes":
+		jwt, err := os.ReadFile(vaultJWTFile)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		body = map[string]string{"role": vaultRole, "jwt": strings.TrimSpace(string(jwt))}
+	case "approle":
+		body = map[string]string{"role_id": vaultApproleRoleID, "secret_id": vaultApproleSecret}
+	default:
+		return fmt.Errorf("unknown -vault.auth %q, must be kubernetes, approle or token", vaultAuth)
+	}
+
+	mount := vaultMount
+	if mount == "" {
+		mount = vaultAuth
+	}
+	var resp vaultAuthResponse
+	if err := v.do(ctx, "POST", "auth/"+mount+"/login", "", body, &resp); err != nil {
+		return fmt.Errorf("vault login failed: %w", err)
+	}
+	v.setAuth(resp)
+	return nil
+}
+
+// setAuth stores the token of a login or renew response
+func (v *vaultClient) setAuth(resp vaultAuthResponse) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.token = resp.Auth.ClientToken
+	v.renewable = resp.Auth.Renewable
+	v.leaseExpiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second)
+}
+
+// renew extends the lease of the Vault token, logging in again if that fails
+func (v *vaultClient) renew(ctx context.Context) error {
+	v.mu.Lock()
+	token, renewable := v.token, v.renewable
+	v.mu.Unlock()
+
+	if renewable {
+		var resp vaultAuthResponse
+		err := v.do(ctx, "POST", "auth/token/renew-self", token, map[string]string{}, &resp)
+		if err == nil && resp.Auth.ClientToken != "" {
+			v.setAuth(resp)
+			return nil
+		}
+		slog.Warn("Renewing vault token failed, logging in again", "err", err)
+	}
+	return v.login(ctx)
+}
+
+// readOfflineToken reads -vault.key from the KV secret at -vault.path
+func (v *vaultClient) readOfflineToken(ctx context.Context) error {
+	v.mu.Lock()
+	token := v.token
+	v.mu.Unlock()
+
+	var resp vaultSecretResponse
+	if err := v.do(ctx, "GET", vaultPath, token, nil, &resp); err != nil {
+		return fmt.Errorf("failed to read %s: %w", vaultPath, err)
+	}
+
+	// KV v2 nests the secret below data.data
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[vaultKey].(string)
+	if !ok || value == "" {
+		return fmt.Errorf("secret %s has no key %q", vaultPath, vaultKey)
+	}
+
+	v.mu.Lock()
+	v.offlineToken = value
+	v.mu.Unlock()
+	return nil
+}
+
+// run keeps the Vault token renewed and re-reads the secret every
+// -vault.refresh-interval until ctx is cancelled
+func (v *vaultClient) run(ctx context.Context) {
+	for {
+		v.mu.Lock()
+		wait := vaultRefreshEvery
+		if v.renewable {
+			// Renew at half of the remaining lease
+			wait = min(wait, time.Until(v.leaseExpiry)/2)
+		}
+		v.mu.Unlock()
+
+		if err := sleepContext(ctx, max(wait, 5*time.Second)); err != nil {
+			return
+		}
+		if err := v.renew(ctx); err != nil {
+			slog.Error("Error renewing vault token", "err", err)
+			continue
+		}
+		if err := v.readOfflineToken(ctx); err != nil {
+			slog.Error("Error reading offline token from vault", "err", err)
+		}
+	}
+}
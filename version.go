@@ -0,0 +1,29 @@
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+
+	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
+	"github.com/prometheus/common/version"
+)
+
+// showVersion prints the build information and exits
+var showVersion bool
+
+// The build information is set via ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/prometheus/common/version.Version=1.2.3 \
+//	  -X github.com/prometheus/common/version.Revision=$(git rev-parse HEAD) \
+//	  -X github.com/prometheus/common/version.BuildDate=$(date -u +%Y%m%d-%H:%M:%S)"
+//
+// Without ldflags the module version of `go install` is used, or dev.
+func init() {
+	if version.Version == "" {
+		version.Version = "dev"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			version.Version = strings.TrimPrefix(info.Main.Version, "v")
+		}
+	}
+	exporterRegistry.MustRegister(versioncollector.NewCollector("redhat_subscription_exporter"))
+}
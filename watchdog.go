@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	watchdogMultiple int64
+	// lastHeartbeat is the unix nano timestamp of the last fetch loop progress
+	lastHeartbeat atomic.Int64
+
+	healthStaleMultiple int64
+	// lastSuccess is the unix nano timestamp of the last successful fetch, or
+	// of the start of the fetch loop before the first one. 0 without a fetch
+	// loop, e.g. in the mock server.
+	lastSuccess atomic.Int64
+)
+
+// staleFor returns how long the last successful fetch is older than
+// -health.stale-multiple fetch intervals allow, 0 if it is recent enough or
+// the check is disabled
+func staleFor(now time.Time, interval time.Duration) time.Duration {
+	last := lastSuccess.Load()
+	if healthStaleMultiple <= 0 || last == 0 {
+		return 0
+	}
+	return max(now.Sub(time.Unix(0, last))-time.Duration(healthStaleMultiple)*interval, 0)
+}
+
+// watchdogTimeout returns how long the fetch loop may make no progress before
+// the watchdog restarts it, -watchdog.multiple fetch intervals but at least
+// the -fetch.timeout of a cycle plus an interval, so a slow cycle within its
+// deadline isn't mistaken for a stuck one. 0 if the watchdog is disabled.
+func watchdogTimeout(interval time.Duration) time.Duration {
+	if watchdogMultiple <= 0 {
+		return 0
+	}
+	return max(time.Duration(watchdogMultiple)*interval, fetchTimeout+interval)
+}
+
+// watchdog returns a channel that is closed once the fetch loop didn't report
+// progress via lastHeartbeat for longer than timeout. A timeout <= 0 disables it.
+func watchdog(ctx context.Context, timeout time.Duration) <-chan struct{} {
+	stuck := make(chan struct{})
+	if timeout <= 0 {
+		return stuck
+	}
+
+	go func() {
+		ticker := time.NewTicker(min(timeout/10, time.Minute))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastHeartbeat.Load())) > timeout {
+					close(stuck)
+					return
+				}
+			}
+		}
+	}()
+
+	return stuck
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	// ready is set once there are subscriptions to export, from the first
+	// fetch or the state file
+	ready atomic.Bool
+	// fetched is set once the first fetch succeeded, restored state doesn't
+	// count for readiness
+	fetched atomic.Bool
+)
+
+// subscriptionsRegistry holds the subscription metric families, so they can
+// be scraped separately from the cheap exporter health metrics
+var subscriptionsRegistry = prometheus.NewRegistry()
+
+// exporterRegistry holds the metrics of the exporter itself and, unless
+// -web.disable-exporter-metrics is set, the Go runtime and process metrics
+var exporterRegistry = prometheus.NewRegistry()
+
+// disableExporterMetrics leaves out the go_* and process_* metrics
+var disableExporterMetrics bool
+
+// registerRuntimeCollectors registers the Go runtime and process collectors
+// unless -web.disable-exporter-metrics is set
+func registerRuntimeCollectors() {
+	if disableExporterMetrics {
+		return
+	}
+	exporterRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// collectorGatherers returns the gatherer of every collector that can be
+// selected with ?collect[]=
+func collectorGatherers() map[string]prometheus.Gatherer {
+	gatherers := map[string]prometheus.Gatherer{
+		"exporter":      exporterRegistry,
+		"subscriptions": timestampedGatherer(subscriptionsRegistry),
+	}
+	for _, c := range optionalCollectors {
+		// Inactive collectors are selectable but return nothing
+		gatherers[c.name] = prometheus.Gatherers{}
+		if c.active() {
+			gatherers[c.name] = c.registry
+		}
+	}
+	return gatherers
+}
+
+// metricsHandler serves all metrics or only the families of the collectors
+// given via ?collect[]=, like node_exporter does. Any other query parameter
+// filters the series by label value.
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshIfStale()
+		available := collectorGatherers()
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 {
+			for name := range available {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		var gatherers prometheus.Gatherers
+		for _, name := range slices.Compact(names) {
+			g, ok := available[name]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown collector %q", name), http.StatusBadRequest)
+				return
+			}
+			gatherers = append(gatherers, g)
+		}
+
+		gatherer := labeledGatherer(gatherers)
+		if filters := labelFilters(r.URL.Query()); len(filters) > 0 {
+			gatherer = filteredGatherer(gatherer, filters)
+		}
+
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// labelFilters returns the query parameters other than collect[] as label
+// filters, every value of a parameter is accepted
+func labelFilters(query url.Values) map[string][]string {
+	filters := map[string][]string{}
+	for name, values := range query {
+		if name != "collect[]" {
+			filters[name] = values
+		}
+	}
+	return filters
+}
+
+// filteredGatherer keeps only the series having all filter labels with one of
+// the given values, e.g. /metrics?status=Active for ad-hoc inspection
+func filteredGatherer(g prometheus.Gatherer, filters map[string][]string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		var filtered []*dto.MetricFamily
+		for _, mf := range families {
+			mf.Metric = slices.DeleteFunc(mf.Metric, func(m *dto.Metric) bool {
+				for name, values := range filters {
+					idx := slices.IndexFunc(m.Label, func(l *dto.LabelPair) bool { return l.GetName() == name })
+					if idx < 0 || !slices.Contains(values, m.Label[idx].GetValue()) {
+						return true
+					}
+				}
+				return false
+			})
+			if len(mf.Metric) > 0 {
+				filtered = append(filtered, mf)
+			}
+		}
+		return filtered, err
+	})
+}
+
+// healthzHandler reports that the process is alive, and unhealthy once the
+// last successful fetch is older than -health.stale-multiple fetch intervals,
+// so a wedged or permanently failing exporter gets restarted
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	interval := time.Duration(fetchInterval)
+	if stale := staleFor(time.Now(), interval); stale > 0 {
+		last := time.Unix(0, lastSuccess.Load())
+		http.Error(w, fmt.Sprintf("last successful fetch at %s, more than %d fetch intervals ago", last.Format(time.RFC3339), healthStaleMultiple), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// readyzHandler reports ready once the first fetch succeeded, so rollouts
+// wait for real data instead of empty or restored metrics. The body has the
+// number of subscriptions loaded.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	updateMu.Lock()
+	count := len(lastSubscriptions)
+	updateMu.Unlock()
+	if draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !fetched.Load() {
+		http.Error(w, fmt.Sprintf("waiting for first successful fetch, %d subscriptions loaded", count), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "OK, %d subscriptions loaded\n", count)
+}
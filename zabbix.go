@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadav/redhat-subscription-exporter/pkg/collector"
+	"github.com/dadav/redhat-subscription-exporter/pkg/rhsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// zabbixBatchSize is the number of values sent per request, like
+	// zabbix_sender does
+	zabbixBatchSize = 250
+	// zabbixTimeout bounds a request to -zabbix.server
+	zabbixTimeout = 15 * time.Second
+	// zabbixMaxResponse bounds the response read from -zabbix.server
+	zabbixMaxResponse = 1 << 20
+)
+
+// zabbixHeader starts every message of the Zabbix protocol, followed by the
+// little-endian data length and 4 reserved bytes
+var zabbixHeader = []byte("ZBXD\x01")
+
+var (
+	zabbixServer string
+	zabbixHost   string
+
+	ZabbixErrorsCounter = promauto.With(exporterRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "redhat_exporter_zabbix_errors_total",
+		Help: "Total number of failed pushes to the -zabbix.server trapper.",
+	})
+
+	zabbixFailedRe = regexp.MustCompile(`failed: (\d+)`)
+)
+
+// zabbixValue is a value of a trapper item
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// zabbixKey returns the key of a per-subscription item, e.g.
+// redhat.subscription.quantity[5000]. The parameter is only quoted if needed,
+// like Zabbix quotes the {#SUBSCRIPTION} macro of an item prototype.
+func zabbixKey(item, subscription string) string {
+	if strings.ContainsAny(subscription, `",[] `) {
+		subscription = strconv.Quote(subscription)
+	}
+	return "redhat.subscription." + item + "[" + subscription + "]"
+}
+
+// zabbixValues returns the discovery of the subscriptions for the
+// redhat.subscription.discovery low-level discovery rule, followed by the
+// values of the items of every subscription
+func zabbixValues(subs []rhsm.Subscription, now time.Time) ([]zabbixValue, error) {
+	subs, _ = collector.MergeDuplicates(subs)
+	opts := collectorOptions(nil)
+	clock := now.Unix()
+
+	type discovered struct {
+		Subscription string `json:"{#SUBSCRIPTION}"`
+		Name         string `json:"{#NAME}"`
+		SKU          string `json:"{#SKU}"`
+		Contract     string `json:"{#CONTRACT}"`
+		Account      string `json:"{#ACCOUNT}"`
+	}
+	discovery := []discovered{}
+	var values []zabbixValue
+	add := func(key, value string) {
+		values = append(values, zabbixValue{Host: zabbixHost, Key: key, Value: value, Clock: clock})
+	}
+	for _, s := range subs {
+		number := s.SubscriptionNumber
+		discovery = append(discovery, discovered{number, s.SubscriptionName, s.SKU, s.ContractNumber, s.Account})
+
+		add(zabbixKey("status", number), s.Status)
+		if q, _, err := opts.Quantity(s.Quantity); err == nil && !math.IsInf(q, 0) {
+			add(zabbixKey("quantity", number), strconv.FormatFloat(q, 'f', -1, 64))
+		}
+		active := "0"
+		if !s.StartDate.After(now) && (s.EndDate.IsZero() || s.EndDate.After(now)) {
+			active = "1"
+		}
+		add(zabbixKey("active", number), active)
+		if !s.EndDate.IsZero() {
+			add(zabbixKey("end", number), strconv.FormatInt(s.EndDate.Unix(), 10))
+			add(zabbixKey("days_remaining", number), strconv.FormatFloat(math.Floor(s.EndDate.Sub(now).Hours()/24), 'f', -1, 64))
+		}
+	}
+
+	data, err := json.Marshal(discovery)
+	if err != nil {
+		return nil, err
+	}
+	return append([]zabbixValue{{Host: zabbixHost, Key: "redhat.subscription.discovery", Value: string(data), Clock: clock}}, values...), nil
+}
+
+// zabbixSend sends a request in the Zabbix protocol and returns the
+// decoded response
+func zabbixSend(ctx context.Context, request interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	msg.Write(zabbixHeader)
+	binary.Write(&msg, binary.LittleEndian, uint64(len(data)))
+	msg.Write(data)
+
+	dialer := net.Dialer{Timeout: zabbixTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", zabbixServer)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(zabbixTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return nil, errors.New("invalid response header")
+	}
+	size := binary.LittleEndian.Uint32(header[len(zabbixHeader):])
+	if size > zabbixMaxResponse {
+		return nil, fmt.Errorf("response of %d bytes too large", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return response, nil
+}
+
+// pushZabbix sends the items of the subscriptions to the -zabbix.server
+// trapper with the sender protocol. Values of items that don't exist on
+// -zabbix.host yet, e.g. before the discovery created them, are rejected by
+// the server and logged, the next push sends them again.
+func pushZabbix(ctx context.Context, subs []rhsm.Subscription) error {
+	now := currentTime()
+	values, err := zabbixValues(subs, now)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for batch := range slices.Chunk(values, zabbixBatchSize) {
+		response, err := zabbixSend(ctx, map[string]interface{}{
+			"request": "sender data",
+			"data":    batch,
+			"clock":   now.Unix(),
+		})
+		if err != nil {
+			return err
+		}
+		info, _ := response["info"].(string)
+		if response["response"] != "success" {
+			return fmt.Errorf("server responded %v: %s", response["response"], info)
+		}
+		if m := zabbixFailedRe.FindStringSubmatch(info); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			failed += n
+		}
+	}
+	if failed > 0 {
+		slog.Warn("Zabbix rejected values of unknown items", "host", zabbixHost, "failed", failed, "total", len(values))
+	}
+	return nil
+}